@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+const (
+	batchModeAtomic     = "atomic"
+	batchModeBestEffort = "best_effort"
+
+	maxBatchOperations = 500
+)
+
+// errBatchAborted rolls back an atomic batch once one of its operations has failed
+var errBatchAborted = errors.New("batch aborted")
+
+type batchOperation struct {
+	Op string `json:"op"`
+	ID int64  `json:"id"`
+	// Version is the version an update or delete expects the movie to be at, like If-Match
+	Version *int32 `json:"version"`
+	Movie   struct {
+		Title   *string        `json:"title"`
+		Year    *int32         `json:"year"`
+		Runtime *store.Runtime `json:"runtime"`
+		Genres  []string       `json:"genres"`
+	} `json:"movie"`
+}
+
+type batchResult struct {
+	Index  int          `json:"index"`
+	Status int          `json:"status"`
+	Movie  *store.Movie `json:"movie,omitempty"`
+	Error  any          `json:"error,omitempty"`
+}
+
+type batchMoviesPayload struct {
+	Mode       string           `json:"mode"`
+	Operations []batchOperation `json:"operations"`
+}
+
+// batchMoviesHandler runs many create, update and delete operations in one request. In atomic
+// mode they share a transaction and the first failure rolls back the whole batch, while in
+// best-effort mode each operation is committed on its own. Every operation gets its own status.
+func (app *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var payload batchMoviesPayload
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if payload.Mode == "" {
+		payload.Mode = batchModeAtomic
+	}
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(payload.Mode, batchModeAtomic, batchModeBestEffort), "mode", validator.CodeNotPermitted, "must be atomic or best_effort")
+	v.Check(len(payload.Operations) > 0, "operations", validator.CodeTooFew, "must contain at least 1 operation")
+	v.Check(len(payload.Operations) <= maxBatchOperations, "operations", validator.CodeTooMany, fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	results := make([]batchResult, len(payload.Operations))
+	status := http.StatusOK
+
+	switch payload.Mode {
+	case batchModeAtomic:
+		failed := -1
+		err = app.store.Tx.InTx(r.Context(), func(tx store.TxStorage) error {
+			for i, op := range payload.Operations {
+				results[i] = app.runBatchOperation(r, tx, i, op)
+				if results[i].Status >= 300 {
+					failed = i
+					return errBatchAborted
+				}
+			}
+			return nil
+		})
+		switch {
+		case errors.Is(err, errBatchAborted):
+			// the failed operation decides the status of the batch, nothing else was kept
+			status = results[failed].Status
+			for i := range results {
+				switch {
+				case i < failed:
+					results[i] = batchResult{Index: i, Status: http.StatusFailedDependency, Error: fmt.Sprintf("rolled back because operation %d failed", failed)}
+				case i > failed:
+					results[i] = batchResult{Index: i, Status: http.StatusFailedDependency, Error: fmt.Sprintf("not run because operation %d failed", failed)}
+				}
+			}
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+	case batchModeBestEffort:
+		for i, op := range payload.Operations {
+			err = app.store.Tx.InTx(r.Context(), func(tx store.TxStorage) error {
+				results[i] = app.runBatchOperation(r, tx, i, op)
+				if results[i].Status >= 300 {
+					return errBatchAborted
+				}
+				return nil
+			})
+			if err != nil && !errors.Is(err, errBatchAborted) {
+				app.logError(r, err)
+				results[i] = batchResult{Index: i, Status: http.StatusInternalServerError, Error: "the server encountered a problem and could not process this operation"}
+			}
+			if results[i].Status >= 300 {
+				status = http.StatusMultiStatus
+			}
+		}
+	}
+
+	if err := app.writeJSON(w, r, status, envelope{"mode": payload.Mode, "results": results}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runBatchOperation applies one operation through the transaction's stores, turning failures
+// into the status and error the matching single-movie endpoint would have responded with
+func (app *application) runBatchOperation(r *http.Request, tx store.TxStorage, index int, op batchOperation) batchResult {
+	ctx := r.Context()
+	user := app.contextGetUser(r)
+
+	fail := func(status int, message any) batchResult {
+		return batchResult{Index: index, Status: status, Error: message}
+	}
+	serverError := func(err error) batchResult {
+		app.logError(r, err)
+		return fail(http.StatusInternalServerError, "the server encountered a problem and could not process this operation")
+	}
+
+	v := validator.New()
+
+	var movie, before *store.Movie
+	switch op.Op {
+	case "create":
+		v.Check(op.ID == 0, "id", validator.CodeNotAllowed, "must not be provided for a create")
+		v.Check(op.Version == nil, "version", validator.CodeNotAllowed, "must not be provided for a create")
+		movie = &store.Movie{}
+
+	case "update", "delete":
+		v.Check(op.ID > 0, "id", validator.CodeRequired, "must be provided")
+		if !v.Valid() {
+			return fail(http.StatusUnprocessableEntity, v.Errors)
+		}
+
+		current, err := tx.Movies.Get(ctx, op.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrorNotFound):
+				return fail(http.StatusNotFound, "the requested resource could not be found")
+			default:
+				return serverError(err)
+			}
+		}
+		if op.Version != nil && *op.Version != current.Version {
+			return fail(http.StatusPreconditionFailed, "the resource has changed since it was last read, fetch it again and retry")
+		}
+		copied := *current
+		before, movie = &copied, current
+
+	default:
+		v.AddError("op", validator.CodeNotPermitted, "must be create, update or delete")
+	}
+	if !v.Valid() {
+		return fail(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	if op.Op == "delete" {
+		if err := tx.Movies.Delete(ctx, movie); err != nil {
+			switch {
+			case errors.Is(err, store.ErrEditConflict):
+				return fail(http.StatusConflict, "unable to update the record due to an edit conflict, please try again")
+			default:
+				return serverError(err)
+			}
+		}
+		if err := tx.Revisions.Create(ctx, store.NewMovieRevision(store.RevisionActionDelete, user.ID, before, nil)); err != nil {
+			return serverError(err)
+		}
+		return batchResult{Index: index, Status: http.StatusOK}
+	}
+
+	if op.Movie.Title != nil {
+		movie.Title = *op.Movie.Title
+	}
+	if op.Movie.Year != nil {
+		movie.Year = *op.Movie.Year
+	}
+	if op.Movie.Runtime != nil {
+		movie.Runtime = *op.Movie.Runtime
+	}
+	if op.Movie.Genres != nil {
+		genres, err := app.normalizeGenres(ctx, v, "genres", op.Movie.Genres)
+		if err != nil {
+			return serverError(err)
+		}
+		movie.Genres = genres
+	}
+	if store.ValidateMovie(v, movie); !v.Valid() {
+		return fail(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	action, status := store.RevisionActionCreate, http.StatusCreated
+	var err error
+	if op.Op == "create" {
+		err = tx.Movies.Create(ctx, movie)
+	} else {
+		action, status = store.RevisionActionUpdate, http.StatusOK
+		err = tx.Movies.Update(ctx, movie)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrEditConflict):
+			return fail(http.StatusConflict, "unable to update the record due to an edit conflict, please try again")
+		default:
+			return serverError(err)
+		}
+	}
+
+	if err := tx.Revisions.Create(ctx, store.NewMovieRevision(action, user.ID, before, movie)); err != nil {
+		return serverError(err)
+	}
+	return batchResult{Index: index, Status: status, Movie: movie}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+type batchResponse struct {
+	Mode    string `json:"mode"`
+	Results []struct {
+		Index  int          `json:"index"`
+		Status int          `json:"status"`
+		Movie  *store.Movie `json:"movie"`
+	} `json:"results"`
+}
+
+func newBatchTestCatalog() *memoryCatalog {
+	return &memoryCatalog{
+		movies: map[int64]store.Movie{1: {ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 2}},
+		lastID: 1,
+	}
+}
+
+func doBatch(t *testing.T, catalog *memoryCatalog, body string) (int, batchResponse) {
+	t.Helper()
+
+	app := &application{store: catalog.storage()}
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies/batch", strings.NewReader(body))
+	r = app.contextSetUser(r, &store.User{ID: 3, Activated: true})
+
+	w := httptest.NewRecorder()
+	app.batchMoviesHandler(w, r)
+
+	var resp batchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, resp
+}
+
+// statuses returns the status of each operation of a batch
+func (resp batchResponse) statuses() []int {
+	statuses := make([]int, len(resp.Results))
+	for i, result := range resp.Results {
+		statuses[i] = result.Status
+	}
+	return statuses
+}
+
+// a create, an update expecting a stale version and an update that would succeed
+const failingBatchOperations = `[
+	{"op": "create", "movie": {"title": "Coco", "year": 2017, "runtime": "105 mins", "genres": ["Cartoon"]}},
+	{"op": "update", "id": 1, "version": 1, "movie": {"title": "Moana 2"}},
+	{"op": "update", "id": 1, "movie": {"year": 2017}}
+]`
+
+func TestBatchAtomicRollsBackOnFailure(t *testing.T) {
+	catalog := newBatchTestCatalog()
+
+	status, resp := doBatch(t, catalog, `{"mode": "atomic", "operations": `+failingBatchOperations+`}`)
+	if status != http.StatusPreconditionFailed {
+		t.Errorf("got status %d, want the status of the failed operation", status)
+	}
+	if got, want := resp.statuses(), []int{http.StatusFailedDependency, http.StatusPreconditionFailed, http.StatusFailedDependency}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got statuses %v, want %v", got, want)
+	}
+
+	if len(catalog.movies) != 1 || catalog.movies[1].Version != 2 || len(catalog.revisions) != 0 {
+		t.Errorf("got %v and %d revisions, want nothing written", catalog.movies, len(catalog.revisions))
+	}
+}
+
+func TestBatchBestEffortKeepsWhatSucceeded(t *testing.T) {
+	catalog := newBatchTestCatalog()
+
+	status, resp := doBatch(t, catalog, `{"mode": "best_effort", "operations": `+failingBatchOperations+`}`)
+	if status != http.StatusMultiStatus {
+		t.Errorf("got status %d, want %d", status, http.StatusMultiStatus)
+	}
+	if got, want := resp.statuses(), []int{http.StatusCreated, http.StatusPreconditionFailed, http.StatusOK}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got statuses %v, want %v", got, want)
+	}
+
+	want := map[int64]store.Movie{
+		1: {ID: 1, Title: "Moana", Year: 2017, Runtime: 107, Genres: []string{"animation"}, Version: 3},
+		2: {ID: 2, Title: "Coco", Year: 2017, Runtime: 105, Genres: []string{"animation"}, Version: 1},
+	}
+	if !reflect.DeepEqual(catalog.movies, want) {
+		t.Errorf("got %v, want %v", catalog.movies, want)
+	}
+	if len(catalog.revisions) != 2 {
+		t.Errorf("got %d revisions, want one per operation that succeeded", len(catalog.revisions))
+	}
+}
+
+func TestBatchAtomicCommitsEveryOperation(t *testing.T) {
+	catalog := newBatchTestCatalog()
+
+	status, resp := doBatch(t, catalog, `{"operations": [
+		{"op": "update", "id": 1, "version": 2, "movie": {"title": "Moana 2"}},
+		{"op": "update", "id": 1, "version": 3, "movie": {"year": 2024}},
+		{"op": "delete", "id": 1, "version": 4}
+	]}`)
+	if status != http.StatusOK || resp.Mode != batchModeAtomic {
+		t.Errorf("got status %d in %s mode, want %d in atomic mode", status, resp.Mode, http.StatusOK)
+	}
+	if got, want := resp.statuses(), []int{http.StatusOK, http.StatusOK, http.StatusOK}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got statuses %v, want %v", got, want)
+	}
+
+	// later operations see what the earlier ones wrote in the same transaction
+	if len(catalog.movies) != 0 {
+		t.Errorf("got %v, want the movie deleted", catalog.movies)
+	}
+	var actions []string
+	for _, rev := range catalog.revisions {
+		actions = append(actions, rev.Action)
+	}
+	if want := []string{store.RevisionActionUpdate, store.RevisionActionUpdate, store.RevisionActionDelete}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("got revisions %v, want %v", actions, want)
+	}
+}
+
+func TestBatchRejectsInvalidOperations(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"mode": "eventually", "operations": [{"op": "delete", "id": 1}]}`, http.StatusUnprocessableEntity},
+		{`{"operations": []}`, http.StatusUnprocessableEntity},
+		{`{"operations": [{"op": "upsert", "id": 1}]}`, http.StatusUnprocessableEntity},
+		{`{"operations": [{"op": "create", "id": 1, "movie": {"title": "Coco"}}]}`, http.StatusUnprocessableEntity},
+		{`{"operations": [{"op": "delete", "id": 7}]}`, http.StatusNotFound},
+		{`{"operations": [{"op": "update", "id": 1, "movie": {"genres": ["western"]}}]}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		catalog := newBatchTestCatalog()
+		if status, _ := doBatch(t, catalog, tt.body); status != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.body, status, tt.status)
+		}
+		if len(catalog.revisions) != 0 {
+			t.Errorf("%s: got a movie written", tt.body)
+		}
+	}
+}
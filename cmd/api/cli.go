@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AmiyoKm/green_light/internal/env"
+	"github.com/AmiyoKm/green_light/internal/importer"
+	"github.com/AmiyoKm/green_light/internal/jsonlog"
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+// runs `api import [flags] <file>` which imports a CSV or NDJSON file straight into the database
+func runImportCommand(logger *jsonlog.Logger, args []string) error {
+	var cfg config
+
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.StringVar(&cfg.db.dsn, "db-dsn", env.GetString("PROD_DB_DSN", ""), "PostgreSQL DSN")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 5, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 5, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max idle time")
+	fs.IntVar(&cfg.imports.batchSize, "batch-size", importer.DefaultBatchSize, "Number of rows written per batch")
+
+	format := fs.String("format", "", "Import file format (csv|ndjson), guessed from the file extension when empty")
+	mode := fs.String("mode", store.ImportModeSkip, "Import mode (skip|upsert|dry-run)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import [flags] <file>\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("import requires exactly one file argument")
+	}
+	path := fs.Arg(0)
+
+	job := &store.ImportJob{
+		Format: *format,
+		Mode:   *mode,
+	}
+	if job.Format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			job.Format = store.ImportFormatCSV
+		case ".ndjson", ".jsonl":
+			job.Format = store.ImportFormatNDJSON
+		}
+	}
+
+	v := validator.New()
+	if store.ValidateImportJob(v, job); !v.Valid() {
+		return fmt.Errorf("invalid import options: %v", v.Errors)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	storage := store.NewStorage(db)
+
+	ctx := context.Background()
+	if err := storage.Imports.CreateJob(ctx, job); err != nil {
+		return err
+	}
+
+	logger.PrintInfo("starting import", map[string]string{
+		"import_job": fmt.Sprintf("%d", job.ID),
+		"file":       path,
+		"format":     job.Format,
+		"mode":       job.Mode,
+	})
+
+	err = importer.New(storage, cfg.imports.batchSize).Run(ctx, job, file)
+
+	for _, rowError := range job.RowErrors {
+		logger.PrintInfo("row rejected", map[string]string{
+			"line":   fmt.Sprintf("%d", rowError.Line),
+			"errors": fmt.Sprintf("%v", rowError.Errors),
+		})
+	}
+	logger.PrintInfo("import finished", map[string]string{
+		"import_job": fmt.Sprintf("%d", job.ID),
+		"status":     job.Status,
+		"processed":  fmt.Sprintf("%d", job.Processed),
+		"inserted":   fmt.Sprintf("%d", job.Inserted),
+		"updated":    fmt.Sprintf("%d", job.Updated),
+		"skipped":    fmt.Sprintf("%d", job.Skipped),
+		"failed":     fmt.Sprintf("%d", job.Failed),
+	})
+	return err
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+// loadOwnedCollection reads the collection named by the id parameter for a request that
+// changes it, writing the error response and returning nil unless the user owns it and
+// the request's preconditions hold
+func (app *application) loadOwnedCollection(w http.ResponseWriter, r *http.Request) *store.Collection {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	user := app.contextGetUser(r)
+
+	c, err := app.store.Collections.Get(r.Context(), id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	if c.OwnerID != user.ID {
+		app.notPermittedResponse(w, r)
+		return nil
+	}
+	if !app.preconditionsMet(w, r, collectionETag(c), int64(c.Version)) {
+		return nil
+	}
+	return c
+}
+
+var collectionSortSafeList = []string{"id", "title", "-id", "-title"}
+
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Kind string
+		store.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	input.Kind = app.readString(qs, "kind", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.SortSafeList = collectionSortSafeList
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+
+	if input.Kind != "" {
+		v.Check(validator.PermittedValue(input.Kind, store.CollectionKindFranchise, store.CollectionKindList), "kind", validator.CodeNotPermitted, "must be franchise or list")
+	}
+
+	if store.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	collections, metadata, err := app.store.Collections.GetAll(r.Context(), user.ID, input.Kind, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"collections": collections, "metadata": metadata}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	c, err := app.store.Collections.Get(r.Context(), id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.writeRepresentation(w, r, collectionETag(c), envelope{"collection": c}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type createCollectionPayload struct {
+	Kind        string `json:"kind"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+}
+
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var payload createCollectionPayload
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	c := &store.Collection{
+		Kind:        payload.Kind,
+		Title:       payload.Title,
+		Description: payload.Description,
+		OwnerID:     app.contextGetUser(r).ID,
+		Visibility:  payload.Visibility,
+	}
+	// collections are private until their owner publishes them
+	if c.Visibility == "" {
+		c.Visibility = store.CollectionVisibilityPrivate
+	}
+
+	v := validator.New()
+	if store.ValidateCollection(v, c); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.store.Collections.Create(r.Context(), c)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	c.Movies = []store.CollectionMovie{}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%d", c.ID))
+	headers.Set("ETag", collectionETag(c))
+	if err := app.writeJSON(w, r, http.StatusCreated, envelope{"collection": c}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type updateCollectionPayload struct {
+	Kind        *string `json:"kind"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
+}
+
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	c := app.loadOwnedCollection(w, r)
+	if c == nil {
+		return
+	}
+
+	var payload updateCollectionPayload
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Kind != nil {
+		c.Kind = *payload.Kind
+	}
+	if payload.Title != nil {
+		c.Title = *payload.Title
+	}
+	if payload.Description != nil {
+		c.Description = *payload.Description
+	}
+	if payload.Visibility != nil {
+		c.Visibility = *payload.Visibility
+	}
+
+	v := validator.New()
+	if store.ValidateCollection(v, c); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.store.Collections.Update(r.Context(), c)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", collectionETag(c))
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"collection": c}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	c := app.loadOwnedCollection(w, r)
+	if c == nil {
+		return
+	}
+
+	err := app.store.Collections.Delete(r.Context(), c.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"message": "collection successfully deleted"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writeCollection responds with the collection as it is after an ordering operation
+func (app *application) writeCollection(w http.ResponseWriter, r *http.Request, id int64) {
+	c, err := app.store.Collections.Get(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", collectionETag(c))
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"collection": c}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type addCollectionMoviePayload struct {
+	MovieID  int64 `json:"movie_id"`
+	Position int   `json:"position"`
+}
+
+func (app *application) addCollectionMovieHandler(w http.ResponseWriter, r *http.Request) {
+	c := app.loadOwnedCollection(w, r)
+	if c == nil {
+		return
+	}
+
+	var payload addCollectionMoviePayload
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.MovieID > 0, "movie_id", validator.CodeRequired, "must be provided")
+	v.Check(payload.Position >= 0, "position", validator.CodeTooSmall, "must not be negative")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.store.Collections.AddMovie(r.Context(), c, payload.MovieID, payload.Position)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			v.AddError("movie_id", validator.CodeNotFound, "must be the id of an existing movie")
+			app.failedValidationResponse(w, r, v)
+		case errors.Is(err, store.ErrMovieInCollection):
+			v.AddError("movie_id", validator.CodeConflict, "is already in the collection")
+			app.failedValidationResponse(w, r, v)
+		case errors.Is(err, store.ErrCollectionFull):
+			v.AddError("movie_id", validator.CodeTooMany, fmt.Sprintf("cannot be added, a collection holds at most %d movies", store.MaxCollectionMovies))
+			app.failedValidationResponse(w, r, v)
+		case errors.Is(err, store.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeCollection(w, r, c.ID)
+}
+
+func (app *application) removeCollectionMovieHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readMovieIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	c := app.loadOwnedCollection(w, r)
+	if c == nil {
+		return
+	}
+
+	err = app.store.Collections.RemoveMovie(r.Context(), c, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, store.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeCollection(w, r, c.ID)
+}
+
+type reorderCollectionMoviesPayload struct {
+	MovieIDs []int64 `json:"movie_ids"`
+}
+
+func (app *application) reorderCollectionMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	c := app.loadOwnedCollection(w, r)
+	if c == nil {
+		return
+	}
+
+	var payload reorderCollectionMoviesPayload
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.MovieIDs != nil, "movie_ids", validator.CodeRequired, "must be provided")
+	v.Check(validator.Unique(payload.MovieIDs), "movie_ids", validator.CodeDuplicate, "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.store.Collections.ReorderMovies(r.Context(), c, payload.MovieIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrCollectionMovies):
+			v.AddError("movie_ids", validator.CodeInvalid, "must list every movie of the collection exactly once")
+			app.failedValidationResponse(w, r, v)
+		case errors.Is(err, store.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeCollection(w, r, c.ID)
+}
@@ -9,7 +9,11 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey      = contextKey("user")
+	requestIDContextKey = contextKey("request_id")
+	graphQLContextKey   = contextKey("graphql")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *store.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -23,3 +27,28 @@ func (app *application) contextGetUser(r *http.Request) *store.User {
 	}
 	return user
 }
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the request's ID, which is empty for requests that never went
+// through the requestID middleware
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+func (app *application) contextSetGraphQLRequest(ctx context.Context, req *graphQLRequest) context.Context {
+	return context.WithValue(ctx, graphQLContextKey, req)
+}
+
+// contextGetGraphQLRequest returns the state graphqlHandler shares with the resolvers
+func (app *application) contextGetGraphQLRequest(ctx context.Context) *graphQLRequest {
+	req, ok := ctx.Value(graphQLContextKey).(*graphQLRequest)
+	if !ok {
+		panic("missing graphql request in context")
+	}
+	return req
+}
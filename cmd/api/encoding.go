@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/AmiyoKm/green_light/internal/cbor"
+	"github.com/AmiyoKm/green_light/internal/msgpack"
+)
+
+// responseEncoder writes response envelopes in one format, which the Accept header selects by
+// any of its media types. The first media type is the one sent as the Content-Type.
+type responseEncoder struct {
+	mediaTypes []string
+	// tabular encoders only write envelopes that hold a single list, such as those of list endpoints
+	tabular bool
+	encode  func(data envelope, pretty bool) ([]byte, error)
+}
+
+// binaryFormat is a format requests may be sent in as well as responses read in. Both directions
+// go through the generic values encoding/json decodes into, so field names, validation and error
+// messages stay those of JSON.
+type binaryFormat struct {
+	name       string
+	mediaTypes []string
+	marshal    func(v any) ([]byte, error)
+	unmarshal  func(data []byte) (any, error)
+}
+
+var binaryFormats = []binaryFormat{
+	{
+		name:       "MessagePack",
+		mediaTypes: []string{"application/msgpack", "application/vnd.msgpack", "application/x-msgpack"},
+		marshal:    msgpack.Marshal,
+		unmarshal:  msgpack.Unmarshal,
+	},
+	{
+		name:       "CBOR",
+		mediaTypes: []string{"application/cbor"},
+		marshal:    cbor.Marshal,
+		unmarshal:  cbor.Unmarshal,
+	},
+}
+
+var jsonEncoder = &responseEncoder{
+	mediaTypes: []string{"application/json"},
+	encode: func(data envelope, pretty bool) ([]byte, error) {
+		var js []byte
+		var err error
+		if pretty {
+			js, err = json.MarshalIndent(data, "", "\t")
+		} else {
+			js, err = json.Marshal(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return append(js, '\n'), nil
+	},
+}
+
+// responseEncoders is the registry writeJSON negotiates over. JSON comes first, so it wins ties
+// and answers */* and missing Accept headers.
+var responseEncoders = func() []*responseEncoder {
+	encoders := []*responseEncoder{
+		jsonEncoder,
+		{mediaTypes: []string{"text/csv"}, tabular: true, encode: encodeCSV},
+	}
+	for _, format := range binaryFormats {
+		encoders = append(encoders, &responseEncoder{
+			mediaTypes: format.mediaTypes,
+			encode: func(data envelope, _ bool) ([]byte, error) {
+				v, err := toGeneric(data)
+				if err != nil {
+					return nil, err
+				}
+				return format.marshal(v)
+			},
+		})
+	}
+	return encoders
+}()
+
+// requestFormat returns the binary format a request body is sent in, nil meaning JSON
+func requestFormat(contentType string) *binaryFormat {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for i, format := range binaryFormats {
+		for _, t := range format.mediaTypes {
+			if mediaType == t {
+				return &binaryFormats[i]
+			}
+		}
+	}
+	return nil
+}
+
+// binarySyntaxOffset returns where a binary format decoder found a body to be malformed
+func binarySyntaxOffset(err error) (int64, bool) {
+	var msgpackError *msgpack.SyntaxError
+	var cborError *cbor.SyntaxError
+
+	switch {
+	case errors.As(err, &msgpackError):
+		return msgpackError.Offset, true
+	case errors.As(err, &cborError):
+		return cborError.Offset, true
+	default:
+		return 0, false
+	}
+}
+
+// toGeneric turns a value into what encoding/json would decode its JSON into, keeping numbers exact
+func toGeneric(v any) (any, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
+
+// negotiateEncoder picks the encoder the Accept header prefers. A media range gives its quality
+// to the encoders it matches unless a more specific range also matches them, and ties go to the
+// earliest registered encoder. It returns false when the header accepts none of the encoders.
+func negotiateEncoder(header string, encoders []*responseEncoder) (*responseEncoder, bool) {
+	if strings.TrimSpace(header) == "" {
+		return encoders[0], true
+	}
+
+	type mediaRange struct {
+		typ, subtype string
+		q            float64
+	}
+
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		typ, subtype, _ := strings.Cut(mediaType, "/")
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q})
+	}
+
+	var best *responseEncoder
+	bestQ := 0.0
+	for _, enc := range encoders {
+		encQ := 0.0
+		for _, mediaType := range enc.mediaTypes {
+			typ, subtype, _ := strings.Cut(mediaType, "/")
+
+			specificity, q := 0, 0.0
+			for _, mr := range ranges {
+				s := 0
+				switch {
+				case mr.typ == typ && mr.subtype == subtype:
+					s = 3
+				case mr.typ == typ && mr.subtype == "*":
+					s = 2
+				case mr.typ == "*" && mr.subtype == "*":
+					s = 1
+				}
+				if s > specificity {
+					specificity, q = s, mr.q
+				}
+			}
+			encQ = max(encQ, q)
+		}
+		if encQ > bestQ {
+			best, bestQ = enc, encQ
+		}
+	}
+	return best, best != nil
+}
+
+// tableKey returns the key of the only list in an envelope, which tabular encoders write
+func tableKey(data envelope) (string, bool) {
+	key, found := "", false
+	for k, v := range data {
+		value := reflect.ValueOf(v)
+		if value.Kind() != reflect.Slice || value.Type().Elem().Kind() == reflect.Uint8 {
+			continue
+		}
+		if found {
+			return "", false
+		}
+		key, found = k, true
+	}
+	return key, found
+}
+
+// encodeCSV writes the list of an envelope with a row per item and a column per field, in the
+// order the fields first appear. Lists of values are joined with | like the CSV export does, other
+// nested values are written as JSON, and the rest of the envelope such as metadata is left out.
+func encodeCSV(data envelope, _ bool) ([]byte, error) {
+	key, _ := tableKey(data)
+
+	js, err := json.Marshal(data[key])
+	if err != nil {
+		return nil, err
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(js, &items); err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	seen := make(map[string]bool)
+	rows := make([]map[string]any, len(items))
+	for i, item := range items {
+		keys, err := objectKeys(item)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.UseNumber()
+		if err := dec.Decode(&rows[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	if len(columns) > 0 {
+		if err := cw.Write(columns); err != nil {
+			return nil, err
+		}
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			if record[i], err = csvCell(row[column]); err != nil {
+				return nil, err
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// objectKeys returns the keys of a JSON object in the order they are written
+func objectKeys(js json.RawMessage) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, errors.New("csv: list items must be objects")
+	}
+
+	var keys []string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, t.(string))
+
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func csvCell(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		values := make([]string, len(v))
+		for i, item := range v {
+			switch item.(type) {
+			case []any, map[string]any:
+				js, err := json.Marshal(v)
+				return string(js), err
+			}
+			values[i], _ = csvCell(item)
+		}
+		return strings.Join(values, "|"), nil
+	default:
+		js, err := json.Marshal(v)
+		return string(js), err
+	}
+}
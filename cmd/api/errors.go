@@ -3,83 +3,150 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/AmiyoKm/green_light/internal/validator"
 )
 
+const problemMediaType = "application/problem+json"
+
+// problemTypePrefix makes the type of a problem a URI from its code, as RFC 9457 requires
+const problemTypePrefix = "urn:greenlight:problem:"
+
 func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
+		"request_id":     app.contextGetRequestID(r),
 	})
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
+// errorResponse writes an RFC 9457 problem for the status. code is a stable, machine-readable
+// name of the error that clients can branch on, and message its detail, either a string or the
+// validator's errors. With legacy errors enabled the message is wrapped in {"error": ...} instead.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message any) {
 	env := envelope{"error": message}
+	if v, ok := message.(*validator.Validator); ok {
+		env = envelope{"error": v.Errors}
+	}
+	var headers http.Header
+
+	if !app.config.errors.legacy {
+		env = envelope{
+			"type":     problemTypePrefix + code,
+			"title":    http.StatusText(status),
+			"status":   status,
+			"code":     code,
+			"instance": r.URL.Path,
+		}
+		if id := app.contextGetRequestID(r); id != "" {
+			env["request_id"] = id
+		}
+
+		switch message := message.(type) {
+		case *validator.Validator:
+			env["detail"] = "the request contains invalid fields, see errors for each of them"
+			env["errors"] = fieldErrors(message)
+		default:
+			env["detail"] = message
+		}
+
+		headers = http.Header{"Content-Type": {problemMediaType}}
+	}
 
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, r, status, env, headers)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
 
+type fieldError struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// fieldErrors lists the validator's errors by field name, each with its code
+func fieldErrors(v *validator.Validator) []fieldError {
+	fields := make([]fieldError, 0, len(v.Errors))
+	for field, message := range v.Errors {
+		fields = append(fields, fieldError{Field: field, Code: v.Codes[field], Detail: message})
+	}
+	slices.SortFunc(fields, func(a, b fieldError) int {
+		return strings.Compare(a.Field, b.Field)
+	})
+	return fields
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, 500, message)
+	app.errorResponse(w, r, 500, "internal_error", message)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, "not_found", message)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, "method_not_allowed", message)
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, "bad_request", err.Error())
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, "validation_failed", v)
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, "edit_conflict", message)
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has changed since it was last read, fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, "precondition_failed", message)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, "rate_limit_exceeded", message)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := `invalid authentication credentials`
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid_credentials", message)
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 
 	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid_token", message)
+}
+
+// accessMessages are the messages of the codes checkAccess returns, which GraphQL reports too
+var accessMessages = map[string]string{
+	"authentication_required": "you must be authenticated to access this message",
+	"inactive_account":        "your user account must be activated to access this resource",
+	"not_permitted":           "your user doesn't have the necessary permissions to access this resource",
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
-	message := "you must be authenticated to access this message"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "authentication_required", accessMessages["authentication_required"])
 }
 
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, "inactive_account", accessMessages["inactive_account"])
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user doesn't have the necessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, "not_permitted", accessMessages["not_permitted"])
 }
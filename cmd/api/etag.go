@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+// Versioned resources are validated with strong entity tags made of the kind of resource, its key
+// and its version, such as "movie-42-v7". Every write bumps the version, so the tag changes with it.
+func etag(kind string, key any, version int64) string {
+	return fmt.Sprintf(`"%s-%v-v%d"`, kind, key, version)
+}
+
+func movieETag(movie *store.Movie) string {
+	return etag("movie", movie.ID, int64(movie.Version))
+}
+
+func userETag(user *store.User) string {
+	return etag("user", user.ID, int64(user.Version))
+}
+
+func genreETag(genre *store.Genre) string {
+	return etag("genre", genre.Slug, int64(genre.Version))
+}
+
+func collectionETag(c *store.Collection) string {
+	return etag("collection", c.ID, int64(c.Version))
+}
+
+// representationETag tags one representation of a resource at the version of tag, such as
+// "movie-42-v7-1a2b3c4d5e6f7a8b". The same version is represented differently depending on fields=,
+// include=, Accept-Language and Accept, and a GET may embed data that is versioned apart from the
+// resource, so the hash of the body tells the representations apart.
+func representationETag(tag string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.TrimSuffix(tag, `"`) + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// ofVersion reports whether candidate is tag or the tag of one of its representations
+func ofVersion(candidate, tag string) bool {
+	if candidate == tag {
+		return true
+	}
+	hash, ok := strings.CutPrefix(candidate, strings.TrimSuffix(tag, `"`)+"-")
+	if !ok {
+		return false
+	}
+	hash, ok = strings.CutSuffix(hash, `"`)
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) != 16 {
+		return false
+	}
+	return ok
+}
+
+// etagMatches reports whether tag, or one of its representations, is in the list of entity tags of
+// an If-Match or If-None-Match header. If-None-Match compares weakly, ignoring the W/ prefix, while
+// If-Match compares strongly and never matches a weak tag.
+func etagMatches(header []string, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(strings.Join(header, ","), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || ofVersion(candidate, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedResponseWriter holds a response back so that it can be tagged before it is sent
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (bw *bufferedResponseWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(status int) {
+	if bw.status == 0 {
+		bw.status = status
+	}
+}
+
+func (bw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	return bw.body.Write(b)
+}
+
+// writeRepresentation is writeJSON for a GET of the versioned resource with the tag. The response
+// is tagged with representationETag, and answered with 304 Not Modified when the client already
+// holds that very representation. If-Match is still checked against the version tag, so the tag
+// of a GET can be sent back to update the resource.
+func (app *application) writeRepresentation(w http.ResponseWriter, r *http.Request, tag string, data envelope, headers http.Header) error {
+	bw := &bufferedResponseWriter{header: w.Header()}
+	if err := app.writeJSON(bw, r, http.StatusOK, data, headers); err != nil {
+		return err
+	}
+
+	if bw.status == http.StatusOK {
+		tag = representationETag(tag, bw.body.Bytes())
+		w.Header().Set("ETag", tag)
+
+		if header := r.Header.Values("If-None-Match"); len(header) > 0 && etagMatches(header, tag, true) {
+			w.Header().Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
+	w.WriteHeader(bw.status)
+	w.Write(bw.body.Bytes())
+	return nil
+}
+
+// preconditionsMet checks the conditional headers of a request that changes a resource against its
+// current tag, writing the error response and returning false when the client's copy is stale.
+// X-Expected-Version is still honoured for older clients when If-Match is not sent.
+func (app *application) preconditionsMet(w http.ResponseWriter, r *http.Request, tag string, version int64) bool {
+	if header := r.Header.Values("If-Match"); len(header) > 0 {
+		if !etagMatches(header, tag, false) {
+			app.preconditionFailedResponse(w, r)
+			return false
+		}
+	} else if expected := r.Header.Get("X-Expected-Version"); expected != "" {
+		if expected != strconv.FormatInt(version, 10) {
+			app.editConflictResponse(w, r)
+			return false
+		}
+	}
+
+	if header := r.Header.Values("If-None-Match"); len(header) > 0 && etagMatches(header, tag, true) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// absentPreconditionsMet is preconditionsMet for a resource that does not exist yet, which no
+// If-Match or expected version can match.
+func (app *application) absentPreconditionsMet(w http.ResponseWriter, r *http.Request) bool {
+	if len(r.Header.Values("If-Match")) > 0 {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+	if r.Header.Get("X-Expected-Version") != "" {
+		app.editConflictResponse(w, r)
+		return false
+	}
+	return true
+}
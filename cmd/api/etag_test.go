@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+func TestWriteRepresentationTagsTheBody(t *testing.T) {
+	app := &application{}
+	tag := etag("movie", 42, 7)
+
+	get := func(data envelope, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies/42", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		if err := app.writeRepresentation(w, r, tag, data, nil); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	full := get(envelope{"movie": map[string]any{"id": 42, "title": "Moana"}}, "")
+	if full.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", full.Code, http.StatusOK)
+	}
+	fullTag := full.Header().Get("ETag")
+	if !ofVersion(fullTag, tag) || fullTag == tag {
+		t.Fatalf("got ETag %s, want a representation of %s", fullTag, tag)
+	}
+
+	if w := get(envelope{"movie": map[string]any{"id": 42, "title": "Moana"}}, fullTag); w.Code != http.StatusNotModified || w.Body.Len() > 0 {
+		t.Errorf("the same representation got %d %q, want %d", w.Code, w.Body, http.StatusNotModified)
+	}
+	if w := get(envelope{"movie": map[string]any{"id": 42, "title": "Moana"}}, "W/"+fullTag); w.Code != http.StatusNotModified {
+		t.Errorf("a weak If-None-Match got %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	// e.g. fields=title or another Accept-Language, at the same version
+	partial := get(envelope{"movie": map[string]any{"title": "Vaiana"}}, fullTag)
+	if partial.Code != http.StatusOK {
+		t.Errorf("another representation got %d, want %d", partial.Code, http.StatusOK)
+	}
+	if partial.Header().Get("ETag") == fullTag {
+		t.Error("two representations share a tag")
+	}
+
+	// the version tag alone no longer validates a GET
+	if w := get(envelope{"movie": map[string]any{"id": 42, "title": "Moana"}}, tag); w.Code != http.StatusOK {
+		t.Errorf("the version tag got %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestPreconditionsAcceptRepresentationsOfTheVersion(t *testing.T) {
+	app := &application{}
+	tag := etag("movie", 42, 7)
+	body := []byte(`{"movie":{"id":42}}`)
+
+	tests := []struct {
+		ifMatch string
+		want    bool
+	}{
+		{tag, true},
+		{representationETag(tag, body), true},
+		{representationETag(etag("movie", 42, 6), body), false},
+		{etag("movie", 42, 70), false},
+		{`"movie-42-v7-not-a-hash"`, false},
+		{"W/" + representationETag(tag, body), false},
+		{"*", true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPatch, "/v1/movies/42", nil)
+		r.Header.Set("If-Match", tt.ifMatch)
+		w := httptest.NewRecorder()
+
+		if got := app.preconditionsMet(w, r, tag, 7); got != tt.want {
+			t.Errorf("If-Match %s: got %t, want %t", tt.ifMatch, got, tt.want)
+		}
+	}
+}
+
+func TestShowCurrentUserIsTaggedByVersion(t *testing.T) {
+	app := &application{}
+	user := &store.User{ID: 3, Name: "Alice", Activated: true, Version: 2}
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/users/me", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		app.showCurrentUserHandler(w, app.contextSetUser(r, user))
+		return w
+	}
+
+	w := get("")
+	tag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || !ofVersion(tag, userETag(user)) {
+		t.Fatalf("got %d with ETag %s, want %d with a representation of %s", w.Code, tag, http.StatusOK, userETag(user))
+	}
+	if w := get(tag); w.Code != http.StatusNotModified {
+		t.Errorf("got %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	// the tag is good for the If-Match of a password change
+	r := httptest.NewRequest(http.MethodPut, "/v1/users/password", nil)
+	r.Header.Set("If-Match", tag)
+	if !app.preconditionsMet(httptest.NewRecorder(), r, userETag(user), int64(user.Version)) {
+		t.Error("the tag of the GET does not satisfy If-Match")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+const (
+	exportFormatNDJSON   = "ndjson"
+	exportFormatCSV      = "csv"
+	exportFormatColumnar = "columnar"
+
+	// rows per row group in the columnar format
+	columnarRowGroupSize = 1000
+
+	// the response is flushed to the client after this many rows
+	exportFlushEvery = 500
+)
+
+// movieExportWriter encodes movies one at a time onto the response body
+type movieExportWriter interface {
+	Write(movie *store.Movie) error
+	// Close writes anything still buffered by the encoder
+	Close() error
+}
+
+type ndjsonExportWriter struct {
+	enc *json.Encoder
+}
+
+func (ew *ndjsonExportWriter) Write(movie *store.Movie) error {
+	return ew.enc.Encode(movie)
+}
+
+func (ew *ndjsonExportWriter) Close() error {
+	return nil
+}
+
+// csvExportWriter writes the same columns the CSV importer reads, so an export can be imported again
+type csvExportWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (ew *csvExportWriter) Write(movie *store.Movie) error {
+	if !ew.headerWritten {
+		if err := ew.w.Write([]string{"id", "title", "year", "runtime", "genres", "version"}); err != nil {
+			return err
+		}
+		ew.headerWritten = true
+	}
+	return ew.w.Write([]string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.FormatInt(int64(movie.Year), 10),
+		strconv.FormatInt(int64(movie.Runtime), 10),
+		strings.Join(movie.Genres, "|"),
+		strconv.FormatInt(int64(movie.Version), 10),
+	})
+}
+
+func (ew *csvExportWriter) Close() error {
+	if !ew.headerWritten {
+		if err := ew.w.Write([]string{"id", "title", "year", "runtime", "genres", "version"}); err != nil {
+			return err
+		}
+	}
+	ew.w.Flush()
+	return ew.w.Error()
+}
+
+type columnarRowGroup struct {
+	RowGroup int        `json:"row_group"`
+	NumRows  int        `json:"num_rows"`
+	ID       []int64    `json:"id"`
+	Title    []string   `json:"title"`
+	Year     []int32    `json:"year"`
+	Runtime  []int32    `json:"runtime"`
+	Genres   [][]string `json:"genres"`
+	Version  []int32    `json:"version"`
+}
+
+// columnarExportWriter writes a schema line followed by row groups that hold one array per column,
+// so that analytics tools can load a column without parsing every row
+type columnarExportWriter struct {
+	enc           *json.Encoder
+	group         columnarRowGroup
+	schemaWritten bool
+}
+
+var columnarSchema = envelope{
+	"schema": []map[string]string{
+		{"name": "id", "type": "int64"},
+		{"name": "title", "type": "string"},
+		{"name": "year", "type": "int32"},
+		{"name": "runtime", "type": "int32", "unit": "minutes"},
+		{"name": "genres", "type": "list<string>"},
+		{"name": "version", "type": "int32"},
+	},
+	"row_group_size": columnarRowGroupSize,
+}
+
+func (ew *columnarExportWriter) Write(movie *store.Movie) error {
+	if !ew.schemaWritten {
+		if err := ew.enc.Encode(columnarSchema); err != nil {
+			return err
+		}
+		ew.schemaWritten = true
+	}
+
+	g := &ew.group
+	g.ID = append(g.ID, movie.ID)
+	g.Title = append(g.Title, movie.Title)
+	g.Year = append(g.Year, movie.Year)
+	g.Runtime = append(g.Runtime, int32(movie.Runtime))
+	g.Genres = append(g.Genres, movie.Genres)
+	g.Version = append(g.Version, movie.Version)
+	g.NumRows++
+
+	if g.NumRows == columnarRowGroupSize {
+		return ew.flushGroup()
+	}
+	return nil
+}
+
+func (ew *columnarExportWriter) flushGroup() error {
+	if err := ew.enc.Encode(ew.group); err != nil {
+		return err
+	}
+	// the column slices are reused so memory stays at one row group
+	ew.group = columnarRowGroup{
+		RowGroup: ew.group.RowGroup + 1,
+		ID:       ew.group.ID[:0],
+		Title:    ew.group.Title[:0],
+		Year:     ew.group.Year[:0],
+		Runtime:  ew.group.Runtime[:0],
+		Genres:   ew.group.Genres[:0],
+		Version:  ew.group.Version[:0],
+	}
+	return nil
+}
+
+func (ew *columnarExportWriter) Close() error {
+	if !ew.schemaWritten {
+		if err := ew.enc.Encode(columnarSchema); err != nil {
+			return err
+		}
+	}
+	if ew.group.NumRows > 0 {
+		return ew.flushGroup()
+	}
+	return nil
+}
+
+func newMovieExportWriter(format string, w io.Writer) (movieExportWriter, string, string) {
+	switch format {
+	case exportFormatCSV:
+		return &csvExportWriter{w: csv.NewWriter(w)}, "text/csv", "movies.csv"
+	case exportFormatColumnar:
+		return &columnarExportWriter{enc: json.NewEncoder(w)}, "application/x-ndjson", "movies.columnar.ndjson"
+	default:
+		return &ndjsonExportWriter{enc: json.NewEncoder(w)}, "application/x-ndjson", "movies.ndjson"
+	}
+}
+
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		store.MovieFilter
+		Format string
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	input.MovieFilter.Title = app.readString(qs, "title", "")
+	input.MovieFilter.Genres = app.readCSV(qs, "genres", []string{})
+	input.Format = app.readString(qs, "format", exportFormatNDJSON)
+
+	var err error
+	input.MovieFilter.Genres, err = app.resolveGenreFilter(r.Context(), input.MovieFilter.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v.Check(validator.PermittedValue(input.Format, exportFormatNDJSON, exportFormatCSV, exportFormatColumnar), "format", validator.CodeNotPermitted, "must be ndjson, csv or columnar")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// exports run well past the server's WriteTimeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(app.config.export.timeout)); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	buf := bufio.NewWriter(w)
+	ew, contentType, filename := newMovieExportWriter(input.Format, buf)
+
+	rows := 0
+	err = app.store.Movies.Export(r.Context(), input.MovieFilter, func(movie *store.Movie) error {
+		if rows == 0 {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+			w.WriteHeader(http.StatusOK)
+		}
+		rows++
+
+		if err := ew.Write(movie); err != nil {
+			return err
+		}
+		if rows%exportFlushEvery == 0 {
+			if err := buf.Flush(); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// once the first row is out the status line has been sent, so the stream is just cut short
+		if rows == 0 {
+			app.serverErrorResponse(w, r, err)
+		} else {
+			app.logError(r, err)
+		}
+		return
+	}
+
+	if rows == 0 {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		w.WriteHeader(http.StatusOK)
+	}
+	if err := ew.Close(); err != nil {
+		app.logError(r, err)
+		return
+	}
+	if err := buf.Flush(); err != nil {
+		app.logError(r, err)
+	}
+}
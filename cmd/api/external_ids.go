@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) lookupMovieHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	source := app.readString(qs, "source", "")
+	externalID := app.readString(qs, "id", "")
+
+	v := validator.New()
+	if store.ValidateExternalID(v, source, externalID); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	movie, err := app.store.Movies.GetByExternalID(r.Context(), source, externalID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	if err := app.writeRepresentation(w, r, movieETag(movie), envelope{"movie": movie}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type upsertMovieByExternalIDPayload struct {
+	Title   string        `json:"title"`
+	Year    int32         `json:"year"`
+	Runtime store.Runtime `json:"runtime"`
+	Genres  []string      `json:"genres"`
+}
+
+// upsertMovieByExternalIDHandler creates or replaces the movie known to an upstream catalogue by
+// the given identifier. Sending the same body again leaves the movie and its version untouched,
+// and If-Match guards the update of an existing movie like it does for PATCH.
+func (app *application) upsertMovieByExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	source := params.ByName("source")
+	externalID := params.ByName("external_id")
+
+	var payload upsertMovieByExternalIDPayload
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	incoming := &store.Movie{
+		Title:   payload.Title,
+		Year:    payload.Year,
+		Runtime: payload.Runtime,
+	}
+
+	v := validator.New()
+	store.ValidateExternalID(v, source, externalID)
+	incoming.Genres, err = app.normalizeGenres(r.Context(), v, "genres", payload.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if store.ValidateMovie(v, incoming); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	movie, err := app.store.Movies.GetByExternalID(r.Context(), source, externalID)
+	switch {
+	case errors.Is(err, store.ErrorNotFound):
+		app.createMovieByExternalID(w, r, incoming, source, externalID)
+		return
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.preconditionsMet(w, r, movieETag(movie), int64(movie.Version)) {
+		return
+	}
+
+	unchanged := movie.Title == incoming.Title &&
+		movie.Year == incoming.Year &&
+		movie.Runtime == incoming.Runtime &&
+		slices.Equal(movie.Genres, incoming.Genres)
+
+	if !unchanged {
+		before := *movie
+
+		movie.Title = incoming.Title
+		movie.Year = incoming.Year
+		movie.Runtime = incoming.Runtime
+		movie.Genres = incoming.Genres
+
+		err = app.store.Tx.InTx(r.Context(), func(tx store.TxStorage) error {
+			if err := tx.Movies.Update(r.Context(), movie); err != nil {
+				return err
+			}
+			return app.recordMovieRevision(r, tx, store.RevisionActionUpdate, &before, movie)
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(movie))
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createMovieByExternalID(w http.ResponseWriter, r *http.Request, movie *store.Movie, source, externalID string) {
+	if !app.absentPreconditionsMet(w, r) {
+		return
+	}
+
+	err := app.store.Tx.InTx(r.Context(), func(tx store.TxStorage) error {
+		if err := tx.Movies.CreateWithExternalID(r.Context(), movie, source, externalID); err != nil {
+			return err
+		}
+		return app.recordMovieRevision(r, tx, store.RevisionActionCreate, nil, movie)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(movie))
+	if err := app.writeJSON(w, r, http.StatusCreated, envelope{"movie": movie}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+// movieIncludes are the related resources that include= can embed in movie responses
+var movieIncludes = []string{"collections", "external_ids", "localizations"}
+
+// readIncludes reads the comma-separated include= list, checking every name against safelist
+func (app *application) readIncludes(qs url.Values, safelist []string, v *validator.Validator) []string {
+	includes := app.readCSV(qs, "include", []string{})
+
+	for _, name := range includes {
+		if !validator.PermittedValue(name, safelist...) {
+			v.AddError("include", validator.CodeNotPermitted, fmt.Sprintf("invalid include %q, must be a comma-separated list of %s", name, strings.Join(safelist, ", ")))
+			break
+		}
+	}
+	v.Check(validator.Unique(includes), "include", validator.CodeDuplicate, "must not contain duplicate values")
+	return includes
+}
+
+// loadMovieIncludes fetches the related resources named by includes for all the movies at once,
+// keyed by movie id and then by include
+func (app *application) loadMovieIncludes(r *http.Request, movies []*store.Movie, includes []string) (map[int64]map[string]any, error) {
+	ids := make([]int64, len(movies))
+	related := make(map[int64]map[string]any, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+		related[movie.ID] = make(map[string]any, len(includes))
+	}
+	if len(ids) == 0 {
+		return related, nil
+	}
+
+	for _, include := range includes {
+		switch include {
+		case "collections":
+			collections, err := app.store.Collections.GetForMovies(r.Context(), ids, app.contextGetUser(r).ID)
+			if err != nil {
+				return nil, err
+			}
+			for id, c := range collections {
+				related[id][include] = c
+			}
+		case "external_ids":
+			externalIDs, err := app.store.Movies.GetExternalIDsForMovies(r.Context(), ids)
+			if err != nil {
+				return nil, err
+			}
+			for id, e := range externalIDs {
+				related[id][include] = e
+			}
+		case "localizations":
+			localizations, err := app.store.Movies.GetLocalizationsForMovies(r.Context(), ids)
+			if err != nil {
+				return nil, err
+			}
+			for id, l := range localizations {
+				related[id][include] = l
+			}
+		}
+	}
+	return related, nil
+}
+
+// sparseMovies returns the objects of the movies cut down to fields, or whole when fields is
+// empty, with the related resources of each movie added in. Members are the values of the movie
+// rather than their JSON, and empty ones are left out as in the JSON of a whole movie.
+// original_title goes along with title and the relevance of a search is always kept.
+func sparseMovies(movies []*store.Movie, fields []string, related map[int64]map[string]any) []map[string]any {
+	if len(fields) == 0 {
+		fields = store.MovieFields
+	}
+
+	objects := make([]map[string]any, 0, len(movies))
+	for _, movie := range movies {
+		object := make(map[string]any, len(fields)+2+len(related[movie.ID]))
+		for _, field := range fields {
+			switch field {
+			case "id":
+				object["id"] = movie.ID
+			case "title":
+				object["title"] = movie.Title
+				if movie.OriginalTitle != "" {
+					object["original_title"] = movie.OriginalTitle
+				}
+			case "year":
+				if movie.Year != 0 {
+					object["year"] = movie.Year
+				}
+			case "runtime":
+				if movie.Runtime != 0 {
+					object["runtime"] = movie.Runtime
+				}
+			case "genres":
+				if len(movie.Genres) > 0 {
+					object["genres"] = movie.Genres
+				}
+			case "version":
+				object["version"] = movie.Version
+			}
+		}
+		if movie.Relevance != 0 {
+			object["relevance"] = movie.Relevance
+		}
+		for name, value := range related[movie.ID] {
+			object[name] = value
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// movieRepresentation returns what a response should send for the movies: the movies themselves,
+// or their sparse objects when fields= or include= were asked for
+func (app *application) movieRepresentation(r *http.Request, movies []*store.Movie, fields, includes []string) (any, error) {
+	if len(fields) == 0 && len(includes) == 0 {
+		return movies, nil
+	}
+
+	related, err := app.loadMovieIncludes(r, movies, includes)
+	if err != nil {
+		return nil, err
+	}
+	return sparseMovies(movies, fields, related), nil
+}
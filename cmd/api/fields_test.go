@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+func TestSparseMoviesInBinaryFormats(t *testing.T) {
+	app := &application{store: store.Storage{
+		Movies: &memoryMovies{movies: []*store.Movie{
+			{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 3},
+		}},
+	}}
+	app.config.search.language = "english"
+
+	tests := []struct {
+		fields string
+		want   map[string]any
+	}{
+		{"title,runtime", map[string]any{"title": "Moana", "runtime": "107 mins"}},
+		{"id,genres,version", map[string]any{"id": json.Number("1"), "genres": []any{"animation"}, "version": json.Number("3")}},
+	}
+
+	for _, format := range binaryFormats {
+		for _, tt := range tests {
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies?fields="+tt.fields, nil)
+			r.Header.Set("Accept", format.mediaTypes[0])
+			w := httptest.NewRecorder()
+			app.listMoviesHandler(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s %s: got status %d: %s", format.name, tt.fields, w.Code, w.Body)
+			}
+
+			v, err := format.unmarshal(w.Body.Bytes())
+			if err != nil {
+				t.Fatalf("%s %s: %v", format.name, tt.fields, err)
+			}
+			body, _ := v.(map[string]any)
+			movies, _ := body["movies"].([]any)
+			if len(movies) != 1 {
+				t.Fatalf("%s %s: got %v, want a single movie", format.name, tt.fields, body)
+			}
+			if !reflect.DeepEqual(movies[0], tt.want) {
+				t.Errorf("%s %s: got %#v, want %#v", format.name, tt.fields, movies[0], tt.want)
+			}
+		}
+	}
+}
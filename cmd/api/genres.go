@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// normalizeGenres rewrites the genres of a movie to the slugs of the taxonomy, dropping the
+// duplicates that different spellings of one genre leave behind. Unknown genres are reported
+// on v under key along with the closest known genres.
+func (app *application) normalizeGenres(ctx context.Context, v *validator.Validator, key string, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return names, nil
+	}
+
+	resolved, err := app.store.Genres.Resolve(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	slugs := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	var unknown []string
+
+	for _, name := range names {
+		slug, ok := resolved[name]
+		if !ok {
+			suggestions, err := app.store.Genres.Suggest(ctx, name, 3)
+			if err != nil {
+				return nil, err
+			}
+			message := fmt.Sprintf("%q", name)
+			if len(suggestions) > 0 {
+				message += fmt.Sprintf(" (did you mean %s?)", strings.Join(suggestions, ", "))
+			}
+			unknown = append(unknown, message)
+			continue
+		}
+		if !seen[slug] {
+			seen[slug] = true
+			slugs = append(slugs, slug)
+		}
+	}
+
+	if len(unknown) > 0 {
+		v.AddError(key, validator.CodeNotFound, "contains unknown genres: "+strings.Join(unknown, ", "))
+	}
+	return slugs, nil
+}
+
+// resolveGenreFilter rewrites the genres of a listing filter to their slugs. Unknown genres are
+// kept as they are, so they simply match no movie.
+func (app *application) resolveGenreFilter(ctx context.Context, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return names, nil
+	}
+
+	resolved, err := app.store.Genres.Resolve(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	slugs := make([]string, 0, len(names))
+	for _, name := range names {
+		if slug, ok := resolved[name]; ok {
+			slugs = append(slugs, slug)
+		} else {
+			slugs = append(slugs, name)
+		}
+	}
+	return slugs, nil
+}
+
+// validateGenreParent checks that the parent of the genre exists and does not descend from it
+func (app *application) validateGenreParent(ctx context.Context, v *validator.Validator, genre *store.Genre) error {
+	if genre.Parent == nil || *genre.Parent == genre.Slug {
+		return nil
+	}
+
+	slug := *genre.Parent
+	for depth := 0; slug != ""; depth++ {
+		parent, err := app.store.Genres.Get(ctx, slug)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrorNotFound) && depth == 0:
+				v.AddError("parent", validator.CodeNotFound, "must be the slug of an existing genre")
+				return nil
+			case errors.Is(err, store.ErrorNotFound):
+				return nil
+			default:
+				return err
+			}
+		}
+		if parent.Slug == genre.Slug {
+			v.AddError("parent", validator.CodeInvalid, "must not be one of the genre's own sub-genres")
+			return nil
+		}
+		if depth == 10 {
+			v.AddError("parent", validator.CodeTooLarge, "must not be nested more than 10 levels deep")
+			return nil
+		}
+
+		slug = ""
+		if parent.Parent != nil {
+			slug = *parent.Parent
+		}
+	}
+	return nil
+}
+
+func genreAliasSlugs(aliases []string) []string {
+	slugs := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		slugs = append(slugs, store.GenreSlug(alias))
+	}
+	return slugs
+}
+
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.store.Genres.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"genres": genres}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showGenreHandler(w http.ResponseWriter, r *http.Request) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	genre, err := app.store.Genres.Get(r.Context(), slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := app.writeRepresentation(w, r, genreETag(genre), envelope{"genre": genre}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type createGenrePayload struct {
+	Slug    string   `json:"slug"`
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+	Parent  *string  `json:"parent"`
+}
+
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var payload createGenrePayload
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	genre := &store.Genre{
+		Slug:    payload.Slug,
+		Name:    payload.Name,
+		Aliases: genreAliasSlugs(payload.Aliases),
+		Parent:  payload.Parent,
+	}
+	// the slug defaults to the one made from the name
+	if genre.Slug == "" {
+		genre.Slug = store.GenreSlug(genre.Name)
+	}
+
+	v := validator.New()
+	store.ValidateGenre(v, genre)
+	if err := app.validateGenreParent(r.Context(), v, genre); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.store.Genres.Create(r.Context(), genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicateGenre):
+			v.AddError("slug", validator.CodeConflict, "the slug or one of the aliases is already used by another genre")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%s", genre.Slug))
+	headers.Set("ETag", genreETag(genre))
+	if err := app.writeJSON(w, r, http.StatusCreated, envelope{"genre": genre}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type updateGenrePayload struct {
+	Name    *string         `json:"name"`
+	Aliases []string        `json:"aliases"`
+	Parent  json.RawMessage `json:"parent"`
+}
+
+func (app *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	genre, err := app.store.Genres.Get(r.Context(), slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.preconditionsMet(w, r, genreETag(genre), int64(genre.Version)) {
+		return
+	}
+
+	// parent is kept raw to tell a null, which removes the parent, from a missing field
+	var payload updateGenrePayload
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Name != nil {
+		genre.Name = *payload.Name
+	}
+	if payload.Aliases != nil {
+		genre.Aliases = genreAliasSlugs(payload.Aliases)
+	}
+	if payload.Parent != nil {
+		var parent *string
+		if err := json.Unmarshal(payload.Parent, &parent); err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("body contains incorrect JSON types for field %q", "parent"))
+			return
+		}
+		genre.Parent = parent
+	}
+
+	v := validator.New()
+	store.ValidateGenre(v, genre)
+	if err := app.validateGenreParent(r.Context(), v, genre); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.store.Genres.Update(r.Context(), genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicateGenre):
+			v.AddError("aliases", validator.CodeConflict, "must not contain the slug or an alias of another genre")
+			app.failedValidationResponse(w, r, v)
+		case errors.Is(err, store.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", genreETag(genre))
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"genre": genre}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteGenreHandler(w http.ResponseWriter, r *http.Request) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	genre, err := app.store.Genres.Get(r.Context(), slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.preconditionsMet(w, r, genreETag(genre), int64(genre.Version)) {
+		return
+	}
+
+	err = app.store.Genres.Delete(r.Context(), genre.Slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, store.ErrGenreInUse):
+			app.errorResponse(w, r, http.StatusConflict, "genre_in_use", "the genre is still used by movies and cannot be deleted")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"message": "genre successfully deleted"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
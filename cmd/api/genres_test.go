@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+// memoryGenres resolves names through its slugs and aliases, the rest of the store is not used
+// by these tests
+type memoryGenres struct {
+	*store.GenreStore
+	// keys maps every slug and alias to the slug of its genre
+	keys map[string]string
+}
+
+func (g *memoryGenres) Resolve(ctx context.Context, names []string) (map[string]string, error) {
+	resolved := make(map[string]string)
+	for _, name := range names {
+		if slug, ok := g.keys[store.GenreSlug(name)]; ok {
+			resolved[name] = slug
+		}
+	}
+	return resolved, nil
+}
+
+func (g *memoryGenres) Suggest(ctx context.Context, name string, limit int) ([]string, error) {
+	if store.GenreSlug(name) == "scifi-fantasy" {
+		return []string{"sci-fi", "fantasy"}, nil
+	}
+	return []string{}, nil
+}
+
+func newGenreTestApp() *application {
+	return &application{store: store.Storage{Genres: &memoryGenres{keys: map[string]string{
+		"sci-fi":    "sci-fi",
+		"scifi":     "sci-fi",
+		"sf":        "sci-fi",
+		"fantasy":   "fantasy",
+		"drama":     "drama",
+		"animation": "animation",
+		"cartoon":   "animation",
+	}}}}
+}
+
+func TestNormalizeGenres(t *testing.T) {
+	app := newGenreTestApp()
+
+	tests := []struct {
+		name   string
+		genres []string
+		slugs  []string
+		err    string
+	}{
+		{"none", []string{}, []string{}, ""},
+		{"slugs", []string{"drama", "sci-fi"}, []string{"drama", "sci-fi"}, ""},
+		{"spellings and aliases", []string{"Sci Fi", "Drama", "SF"}, []string{"sci-fi", "drama"}, ""},
+		{"duplicates after normalising", []string{"scifi", "sci-fi", "Sci-Fi"}, []string{"sci-fi"}, ""},
+		{
+			"unknown genres",
+			[]string{"drama", "Scifi Fantasy", "western"},
+			[]string{"drama"},
+			`contains unknown genres: "Scifi Fantasy" (did you mean sci-fi, fantasy?), "western"`,
+		},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		slugs, err := app.normalizeGenres(context.Background(), v, "genres", tt.genres)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(slugs, tt.slugs) {
+			t.Errorf("%s: got %v, want %v", tt.name, slugs, tt.slugs)
+		}
+		if got := v.Errors["genres"]; got != tt.err {
+			t.Errorf("%s: got error %q, want %q", tt.name, got, tt.err)
+		}
+		if tt.err != "" && v.Codes["genres"] != validator.CodeNotFound {
+			t.Errorf("%s: got code %q, want %q", tt.name, v.Codes["genres"], validator.CodeNotFound)
+		}
+	}
+}
+
+func TestResolveGenreFilterKeepsUnknownGenres(t *testing.T) {
+	app := newGenreTestApp()
+
+	slugs, err := app.resolveGenreFilter(context.Background(), []string{"SF", "western", "Drama"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sci-fi", "western", "drama"}; !reflect.DeepEqual(slugs, want) {
+		t.Errorf("got %v, want %v", slugs, want)
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/graphql"
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+type graphqlRequestPayload struct {
+	Query         string         `json:"query"`
+	OperationName *string        `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+	// Extensions is accepted for the clients that send it, and ignored
+	Extensions map[string]any `json:"extensions"`
+}
+
+// graphQLRequest is what the resolvers of a request share: the request, for access checks and
+// localization, and the loaders that batch the lookups of the movies' related records
+type graphQLRequest struct {
+	w http.ResponseWriter
+	r *http.Request
+
+	// access caches checkAccess by access, as root fields often share one
+	access map[string]string
+
+	collections   *graphql.Loader[int64, []store.MovieCollection]
+	externalIDs   *graphql.Loader[int64, []store.ExternalID]
+	localizations *graphql.Loader[int64, *store.Localizations]
+}
+
+func (app *application) newGraphQLRequest(w http.ResponseWriter, r *http.Request) *graphQLRequest {
+	viewerID := app.contextGetUser(r).ID
+
+	return &graphQLRequest{
+		w:      w,
+		r:      r,
+		access: make(map[string]string),
+		collections: graphql.NewLoader(func(ctx context.Context, ids []int64) (map[int64][]store.MovieCollection, error) {
+			collections, err := app.store.Collections.GetForMovies(ctx, ids, viewerID)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				if collections[id] == nil {
+					collections[id] = []store.MovieCollection{}
+				}
+			}
+			return collections, nil
+		}),
+		externalIDs: graphql.NewLoader(func(ctx context.Context, ids []int64) (map[int64][]store.ExternalID, error) {
+			externalIDs, err := app.store.Movies.GetExternalIDsForMovies(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				if externalIDs[id] == nil {
+					externalIDs[id] = []store.ExternalID{}
+				}
+			}
+			return externalIDs, nil
+		}),
+		localizations: graphql.NewLoader(func(ctx context.Context, ids []int64) (map[int64]*store.Localizations, error) {
+			return app.store.Movies.GetLocalizationsForMovies(ctx, ids)
+		}),
+	}
+}
+
+// graphQLError is an error reported for a field, with the code the rest of the API uses for the
+// same problem
+type graphQLError struct {
+	code    string
+	message string
+	fields  *validator.Validator
+}
+
+func (e *graphQLError) Error() string {
+	return e.message
+}
+
+// graphQLArgs names the arguments of movies that the store's validation errors are about
+var graphQLArgs = map[string]string{
+	"genres_match": "genresMatch",
+	"genres_not":   "genresNot",
+	"year_gte":     "yearGte",
+	"year_lte":     "yearLte",
+	"q":            "search",
+	"page_size":    "pageSize",
+}
+
+func graphQLValidationError(v *validator.Validator) error {
+	fields := validator.New()
+	for key, message := range v.Errors {
+		arg, ok := graphQLArgs[key]
+		if !ok {
+			arg = key
+		}
+		fields.AddError(arg, v.Codes[key], message)
+	}
+	return &graphQLError{code: "validation_failed", message: "the arguments are invalid, see errors for each of them", fields: fields}
+}
+
+// formatGraphQLError reports the errors of resolvers. Errors other than graphQLErrors are logged
+// and reported as internal errors, like serverErrorResponse does.
+func (app *application) formatGraphQLError(ctx context.Context, err error) *graphql.Error {
+	var gqlErr *graphQLError
+	if errors.As(err, &gqlErr) {
+		extensions := map[string]any{"code": gqlErr.code}
+		if gqlErr.fields != nil {
+			extensions["errors"] = fieldErrors(gqlErr.fields)
+		}
+		return &graphql.Error{Message: gqlErr.message, Extensions: extensions}
+	}
+
+	app.logError(app.contextGetGraphQLRequest(ctx).r, err)
+	return &graphql.Error{
+		Message:    "the server encountered a problem and could not process your request",
+		Extensions: map[string]any{"code": "internal_error"},
+	}
+}
+
+// requireGraphQLAccess resolves the field for the users who would get through requireAccess
+// with the same access, and reports the error the others would get
+func (app *application) requireGraphQLAccess(access string, resolve graphql.ResolveFunc) graphql.ResolveFunc {
+	return func(ctx context.Context, source any, args map[string]any) (any, error) {
+		req := app.contextGetGraphQLRequest(ctx)
+
+		code, ok := req.access[access]
+		if !ok {
+			var err error
+			if code, err = app.checkAccess(req.r, access); err != nil {
+				return nil, err
+			}
+			req.access[access] = code
+		}
+
+		if code != "" {
+			return nil, &graphQLError{code: code, message: accessMessages[code]}
+		}
+		return resolve(ctx, source, args)
+	}
+}
+
+// moviePage is a page of movies as listMoviesHandler returns it
+type moviePage struct {
+	movies   []*store.Movie
+	metadata store.Metadata
+}
+
+type localizedTitle struct {
+	locale string
+	title  string
+}
+
+// field is a field of an object type whose value is read from the source
+func field[T any](name, description string, t graphql.Type, value func(source T) any) *graphql.FieldDef {
+	return &graphql.FieldDef{
+		Name:        name,
+		Description: description,
+		Type:        t,
+		Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return value(source.(T)), nil
+		},
+	}
+}
+
+// omitZero is null for the zero value, which the JSON responses leave out
+func omitZero[T comparable](v T) any {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
+
+func nonNull(t graphql.Type) graphql.Type {
+	return &graphql.NonNull{Of: t}
+}
+
+func listOf(t graphql.Type) graphql.Type {
+	return &graphql.NonNull{Of: &graphql.List{Of: &graphql.NonNull{Of: t}}}
+}
+
+// graphQLSchema is the schema of POST /v1/graphql. Its root fields require the same access as
+// the routes returning the same records.
+func (app *application) graphQLSchema() *graphql.Schema {
+	collectionType := &graphql.Object{
+		Name:        "MovieCollection",
+		Description: "A collection a movie belongs to.",
+		Fields: []*graphql.FieldDef{
+			field("id", "", nonNull(graphql.ID), func(c store.MovieCollection) any { return c.ID }),
+			field("kind", "franchise or list", nonNull(graphql.String), func(c store.MovieCollection) any { return c.Kind }),
+			field("title", "", nonNull(graphql.String), func(c store.MovieCollection) any { return c.Title }),
+			field("position", "The movie's position in the collection.", nonNull(graphql.Int), func(c store.MovieCollection) any { return c.Position }),
+		},
+	}
+
+	externalIDType := &graphql.Object{
+		Name:        "ExternalID",
+		Description: "The ID of a movie in another catalogue.",
+		Fields: []*graphql.FieldDef{
+			field("source", "", nonNull(graphql.String), func(id store.ExternalID) any { return id.Source }),
+			field("externalId", "", nonNull(graphql.String), func(id store.ExternalID) any { return id.ExternalID }),
+		},
+	}
+
+	localizedTitleType := &graphql.Object{
+		Name: "LocalizedTitle",
+		Fields: []*graphql.FieldDef{
+			field("locale", "A lower case language tag such as fr or pt-br.", nonNull(graphql.String), func(t localizedTitle) any { return t.locale }),
+			field("title", "", nonNull(graphql.String), func(t localizedTitle) any { return t.title }),
+		},
+	}
+
+	releaseType := &graphql.Object{
+		Name: "MovieRelease",
+		Fields: []*graphql.FieldDef{
+			field("country", "An upper case ISO 3166-1 alpha-2 code.", nonNull(graphql.String), func(r store.MovieRelease) any { return r.Country }),
+			field("releaseDate", "", nonNull(graphql.String), func(r store.MovieRelease) any { return r.ReleaseDate }),
+			field("certification", "The age rating given in the country.", graphql.String, func(r store.MovieRelease) any { return omitZero(r.Certification) }),
+		},
+	}
+
+	localizationsType := &graphql.Object{
+		Name:        "Localizations",
+		Description: "The alternate titles of a movie and its regional releases.",
+		Fields: []*graphql.FieldDef{
+			field("titles", "", listOf(localizedTitleType), func(l *store.Localizations) any {
+				titles := make([]localizedTitle, 0, len(l.Titles))
+				for _, locale := range slices.Sorted(maps.Keys(l.Titles)) {
+					titles = append(titles, localizedTitle{locale: locale, title: l.Titles[locale]})
+				}
+				return titles
+			}),
+			field("releases", "", listOf(releaseType), func(l *store.Localizations) any {
+				if l.Releases == nil {
+					return []store.MovieRelease{}
+				}
+				return l.Releases
+			}),
+		},
+	}
+
+	movieType := &graphql.Object{
+		Name: "Movie",
+		Fields: []*graphql.FieldDef{
+			field("id", "", nonNull(graphql.ID), func(m *store.Movie) any { return m.ID }),
+			field("title", "The title, localized for the Accept-Language header.", nonNull(graphql.String), func(m *store.Movie) any { return m.Title }),
+			field("originalTitle", "The title before it was localized.", graphql.String, func(m *store.Movie) any { return omitZero(m.OriginalTitle) }),
+			field("year", "", graphql.Int, func(m *store.Movie) any { return omitZero(m.Year) }),
+			field("runtime", "The runtime in minutes.", graphql.Int, func(m *store.Movie) any { return omitZero(int32(m.Runtime)) }),
+			field("genres", "", listOf(graphql.String), func(m *store.Movie) any {
+				if m.Genres == nil {
+					return []string{}
+				}
+				return m.Genres
+			}),
+			field("version", "", nonNull(graphql.Int), func(m *store.Movie) any { return m.Version }),
+			field("relevance", "How well the movie matches the search of movies.", graphql.Float, func(m *store.Movie) any { return omitZero(m.Relevance) }),
+			{
+				Name:        "collections",
+				Description: "The collections visible to the user the movie belongs to.",
+				Type:        listOf(collectionType),
+				Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+					return app.contextGetGraphQLRequest(ctx).collections.Load(ctx, source.(*store.Movie).ID), nil
+				},
+			},
+			{
+				Name:        "externalIds",
+				Description: "The IDs of the movie in other catalogues.",
+				Type:        listOf(externalIDType),
+				Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+					return app.contextGetGraphQLRequest(ctx).externalIDs.Load(ctx, source.(*store.Movie).ID), nil
+				},
+			},
+			{
+				Name:        "localizations",
+				Description: "The alternate titles and regional releases of the movie, if it has any.",
+				Type:        localizationsType,
+				Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+					return app.contextGetGraphQLRequest(ctx).localizations.Load(ctx, source.(*store.Movie).ID), nil
+				},
+			},
+		},
+	}
+
+	metadataType := &graphql.Object{
+		Name:        "Metadata",
+		Description: "The position of a page in the results, which is all 0 when there are none.",
+		Fields: []*graphql.FieldDef{
+			field("currentPage", "", nonNull(graphql.Int), func(m store.Metadata) any { return m.CurrentPage }),
+			field("pageSize", "", nonNull(graphql.Int), func(m store.Metadata) any { return m.PageSize }),
+			field("firstPage", "", nonNull(graphql.Int), func(m store.Metadata) any { return m.FirstPage }),
+			field("lastPage", "", nonNull(graphql.Int), func(m store.Metadata) any { return m.LastPage }),
+			field("totalRecords", "", nonNull(graphql.Int), func(m store.Metadata) any { return m.TotalRecord }),
+		},
+	}
+
+	moviePageType := &graphql.Object{
+		Name: "MoviePage",
+		Fields: []*graphql.FieldDef{
+			field("movies", "", listOf(movieType), func(p moviePage) any { return p.movies }),
+			field("metadata", "", nonNull(metadataType), func(p moviePage) any { return p.metadata }),
+		},
+	}
+
+	userType := &graphql.Object{
+		Name: "User",
+		Fields: []*graphql.FieldDef{
+			field("id", "", nonNull(graphql.ID), func(u *store.User) any { return u.ID }),
+			field("name", "", nonNull(graphql.String), func(u *store.User) any { return u.Name }),
+			field("email", "", nonNull(graphql.String), func(u *store.User) any { return u.Email }),
+			field("activated", "", nonNull(graphql.Boolean), func(u *store.User) any { return u.Activated }),
+			field("createdAt", "An RFC 3339 timestamp.", nonNull(graphql.String), func(u *store.User) any { return u.CreatedAt.Format(time.RFC3339) }),
+		},
+	}
+
+	genresMatchType := &graphql.Enum{
+		Name:        "GenresMatch",
+		Description: "Whether movies must have all the genres asked for or any of them.",
+		Values:      []string{"ALL", "ANY"},
+	}
+
+	query := &graphql.Object{
+		Name: "Query",
+		Fields: []*graphql.FieldDef{
+			{
+				Name:        "movie",
+				Description: "The movie with the ID, or the movie it was merged into. Requires movies:read.",
+				Type:        movieType,
+				Args:        []*graphql.ArgDef{{Name: "id", Type: nonNull(graphql.ID)}},
+				Resolve:     app.requireGraphQLAccess("movies:read", app.resolveMovie),
+			},
+			{
+				Name:        "movies",
+				Description: "A page of the movies matching the filters, as GET /v1/movies lists them. Requires movies:read.",
+				Type:        moviePageType,
+				Args: []*graphql.ArgDef{
+					{Name: "title", Type: graphql.String, Description: "Full-text match on the title."},
+					{Name: "genres", Type: &graphql.List{Of: nonNull(graphql.String)}, Description: "Genres, or their aliases, the movies must have."},
+					{Name: "genresMatch", Type: nonNull(genresMatchType), Default: "ALL"},
+					{Name: "genresNot", Type: &graphql.List{Of: nonNull(graphql.String)}, Description: "Genres, or their aliases, the movies must not have."},
+					{Name: "yearGte", Type: graphql.Int},
+					{Name: "yearLte", Type: graphql.Int},
+					{Name: "search", Type: graphql.String, Description: "A relevance search, which sorts by relevance unless sort is given."},
+					{Name: "page", Type: nonNull(graphql.Int), Default: 1},
+					{Name: "pageSize", Type: nonNull(graphql.Int), Default: 20},
+					{Name: "sort", Type: graphql.String, Description: "A comma-separated list of " + strings.Join(movieSortSafeList, ", ") + ", or relevance for a search."},
+				},
+				Resolve: app.requireGraphQLAccess("movies:read", app.resolveMovies),
+				// every field of a movie is selected once for each movie of the page
+				Complexity: func(args map[string]any, childComplexity int) int {
+					return 1 + args["pageSize"].(int)*childComplexity
+				},
+			},
+			{
+				Name:        "me",
+				Description: "The authenticated user. Requires an activated account.",
+				Type:        userType,
+				Resolve: app.requireGraphQLAccess(accessActivated, func(ctx context.Context, source any, args map[string]any) (any, error) {
+					return app.contextGetUser(app.contextGetGraphQLRequest(ctx).r), nil
+				}),
+			},
+		},
+	}
+
+	schema, err := graphql.NewSchema(query)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+func (app *application) resolveMovie(ctx context.Context, source any, args map[string]any) (any, error) {
+	req := app.contextGetGraphQLRequest(ctx)
+
+	id, err := strconv.ParseInt(args["id"].(string), 10, 64)
+	if err != nil || id < 1 {
+		return nil, nil
+	}
+
+	movie, err := app.store.Movies.Get(ctx, id)
+	if errors.Is(err, store.ErrorNotFound) {
+		var survivorID int64
+		if survivorID, err = app.store.Movies.GetRedirect(ctx, id); err == nil {
+			movie, err = app.store.Movies.Get(ctx, survivorID)
+		}
+	}
+	switch {
+	case errors.Is(err, store.ErrorNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err := app.localizeMovies(req.w, req.r, movie); err != nil {
+		return nil, err
+	}
+	return movie, nil
+}
+
+// stringsArg returns a list argument of strings, which is empty when it was not given
+func stringsArg(v any) []string {
+	items, _ := v.([]any)
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		list = append(list, item.(string))
+	}
+	return list
+}
+
+func (app *application) resolveMovies(ctx context.Context, source any, args map[string]any) (any, error) {
+	req := app.contextGetGraphQLRequest(ctx)
+
+	var filter store.MovieFilter
+	filter.Title, _ = args["title"].(string)
+	filter.GenresMatch = strings.ToLower(args["genresMatch"].(string))
+	if year, ok := args["yearGte"].(int); ok {
+		filter.YearGTE = int32(year)
+	}
+	if year, ok := args["yearLte"].(int); ok {
+		filter.YearLTE = int32(year)
+	}
+	filter.Search, _ = args["search"].(string)
+	filter.Language = app.config.search.language
+
+	filters := store.Filters{
+		Page:         args["page"].(int),
+		PageSize:     args["pageSize"].(int),
+		SortSafeList: movieSortSafeList,
+		Sort:         "id",
+	}
+	// a search is sorted by relevance unless another sort is asked for
+	if filter.Search != "" {
+		filters.SortSafeList = append(slices.Clone(filters.SortSafeList), "relevance")
+		filters.Sort = "relevance"
+	}
+	if sort, ok := args["sort"].(string); ok {
+		filters.Sort = sort
+	}
+
+	var err error
+	if filter.Genres, err = app.resolveGenreFilter(ctx, stringsArg(args["genres"])); err != nil {
+		return nil, err
+	}
+	if filter.GenresNot, err = app.resolveGenreFilter(ctx, stringsArg(args["genresNot"])); err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+	store.ValidateMovieFilter(v, filter)
+	if store.ValidateFilters(v, filters); !v.Valid() {
+		return nil, graphQLValidationError(v)
+	}
+
+	movies, metadata, err := app.store.Movies.GetAll(ctx, filter, filters)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.localizeMovies(req.w, req.r, movies...); err != nil {
+		return nil, err
+	}
+	return moviePage{movies: movies, metadata: metadata}, nil
+}
+
+// graphqlHandler runs a GraphQL query. Errors in the query and errors of fields are reported in
+// the errors of a 200 response, with the code of the matching error response in their extensions.
+func (app *application) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var input graphqlRequestPayload
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Query != "", "query", validator.CodeRequired, "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	req := graphql.Request{Query: input.Query, Variables: input.Variables}
+	if input.OperationName != nil {
+		req.OperationName = *input.OperationName
+	}
+
+	ctx := app.contextSetGraphQLRequest(r.Context(), app.newGraphQLRequest(w, r))
+	result := graphql.Execute(ctx, app.graphql, req, graphql.Options{
+		MaxDepth:      app.config.graphql.maxDepth,
+		MaxComplexity: app.config.graphql.maxComplexity,
+		FormatError:   app.formatGraphQLError,
+	})
+
+	// data is left out when the query could not be run, as the GraphQL spec asks
+	env := envelope{}
+	if result.Data != nil {
+		env["data"] = result.Data
+	}
+	if len(result.Errors) > 0 {
+		env["errors"] = result.Errors
+	}
+	if err := app.writeJSON(w, r, http.StatusOK, env, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
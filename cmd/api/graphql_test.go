@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+// memoryPermissions holds the permission codes of each user
+type memoryPermissions map[int64]store.Permissions
+
+func (p memoryPermissions) GetAllForUser(ctx context.Context, userID int64) (store.Permissions, error) {
+	return p[userID], nil
+}
+
+func (p memoryPermissions) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	p[userID] = append(p[userID], codes...)
+	return nil
+}
+
+// memoryMovies answers GetAll with its movies, the rest of the store is not used by these tests
+type memoryMovies struct {
+	*store.MovieStore
+	movies []*store.Movie
+}
+
+func (m *memoryMovies) GetAll(ctx context.Context, filter store.MovieFilter, filters store.Filters) ([]*store.Movie, store.Metadata, error) {
+	return m.movies, store.Metadata{TotalRecord: len(m.movies)}, nil
+}
+
+type graphQLResponse struct {
+	Data   map[string]any `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+func newGraphQLTestApp() *application {
+	app := &application{store: store.Storage{
+		Movies:      &memoryMovies{movies: []*store.Movie{{ID: 1, Title: "Moana", Year: 2016, Version: 1}}},
+		Permissions: memoryPermissions{2: {"movies:read"}},
+	}}
+	app.config.search.language = "english"
+	app.config.graphql.maxDepth = 10
+	app.config.graphql.maxComplexity = 1000
+	app.graphql = app.graphQLSchema()
+	return app
+}
+
+func (app *application) doGraphQL(t *testing.T, user *store.User, query string) graphQLResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]any{"query": query})
+	r := httptest.NewRequest(http.MethodPost, "/v1/graphql", bytes.NewReader(body))
+	r = app.contextSetUser(r, user)
+
+	w := httptest.NewRecorder()
+	app.graphqlHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	var resp graphQLResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestGraphQLRequiresAccess(t *testing.T) {
+	app := newGraphQLTestApp()
+
+	tests := []struct {
+		name string
+		user *store.User
+		code string
+	}{
+		{"anonymous", store.AnonymousUser, "authentication_required"},
+		{"inactive", &store.User{ID: 2}, "inactive_account"},
+		{"without movies:read", &store.User{ID: 1, Activated: true}, "not_permitted"},
+		{"with movies:read", &store.User{ID: 2, Activated: true}, ""},
+	}
+
+	for _, tt := range tests {
+		resp := app.doGraphQL(t, tt.user, `{ movies { movies { title } } }`)
+
+		if tt.code == "" {
+			if len(resp.Errors) > 0 {
+				t.Errorf("%s: got errors %v", tt.name, resp.Errors)
+			}
+			page, _ := resp.Data["movies"].(map[string]any)
+			if movies, _ := page["movies"].([]any); len(movies) != 1 {
+				t.Errorf("%s: got %v, want a page of one movie", tt.name, resp.Data)
+			}
+			continue
+		}
+
+		if len(resp.Errors) != 1 {
+			t.Fatalf("%s: got %d errors, want 1", tt.name, len(resp.Errors))
+		}
+		if code := resp.Errors[0].Extensions["code"]; code != tt.code {
+			t.Errorf("%s: got code %v, want %s", tt.name, code, tt.code)
+		}
+		// the field is nulled, the way a failed field is
+		if movies, ok := resp.Data["movies"]; !ok || movies != nil {
+			t.Errorf("%s: got movies %v, want null", tt.name, movies)
+		}
+	}
+}
+
+func TestGraphQLMoviesComplexityGrowsWithPageSize(t *testing.T) {
+	app := newGraphQLTestApp()
+	user := &store.User{ID: 2, Activated: true}
+
+	tests := []struct {
+		query string
+		err   string
+	}{
+		// 1 + 20 by default × (1 + 1 for the title of each movie)
+		{`{ movies { movies { title } } }`, ""},
+		// 1 + 100 × (1 + 3 fields) is 401
+		{`{ movies(pageSize: 100) { movies { id title year } } }`, ""},
+		// 1 + 100 × (1 + 3 fields + 1 + 2 for metadata) is 801
+		{`{ movies(pageSize: 100) { movies { id title year } metadata { totalRecords lastPage } } }`, ""},
+		// 1 + 100 × (1 + 6 fields + 1 + 2 for metadata) is 1001, although the page size is allowed
+		{`{ movies(pageSize: 100) { movies { id title year runtime version genres } metadata { totalRecords lastPage } } }`, "the query has a complexity of 1001, which exceeds the maximum of 1000"},
+		{`{ movies(pageSize: 90) { movies { id title year runtime version genres } metadata { totalRecords lastPage } } }`, ""},
+	}
+
+	for _, tt := range tests {
+		resp := app.doGraphQL(t, user, tt.query)
+
+		var got string
+		if len(resp.Errors) > 0 {
+			got = resp.Errors[0].Message
+		}
+		if got != tt.err {
+			t.Errorf("%s: got error %q, want %q", tt.query, got, tt.err)
+		}
+		if tt.err != "" && resp.Data != nil {
+			t.Errorf("%s: got data for a query over the limit", tt.query)
+		}
+	}
+}
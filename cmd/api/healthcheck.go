@@ -13,7 +13,7 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		},
 	}
 
-	if err := app.writeJSON(w, http.StatusOK, env, nil); err != nil {
+	if err := app.writeJSON(w, r, http.StatusOK, env, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
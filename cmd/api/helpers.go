@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/AmiyoKm/green_light/internal/validator"
 	"github.com/julienschmidt/httprouter"
@@ -26,29 +29,120 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, err
 }
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+	return int32(version), nil
+}
+
+func (app *application) readMovieIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("movie_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid movie_id parameter")
+	}
+	return id, nil
+}
+
+// writeJSON writes data in the format the request's Accept header prefers, compact JSON unless
+// another encoder is asked for. JSON is indented in development and when the pretty query
+// parameter is set. A safe request accepting none of the formats gets a 406, while other requests
+// and error responses fall back to JSON, since the request has already been acted on.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	encoders := responseEncoders
+	if _, ok := tableKey(data); !ok {
+		encoders = slices.DeleteFunc(slices.Clone(encoders), func(enc *responseEncoder) bool { return enc.tabular })
+	}
 
-	// for better indentation of JSON in the CLI
-	js, err := json.MarshalIndent(data, "", "\t")
+	w.Header().Add("Vary", "Accept")
+
+	enc, ok := negotiateEncoder(r.Header.Get("Accept"), encoders)
+	if !ok {
+		if status < http.StatusBadRequest && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			var mediaTypes []string
+			for _, enc := range encoders {
+				mediaTypes = append(mediaTypes, enc.mediaTypes[0])
+			}
+			message := fmt.Sprintf("this resource is available as %s", strings.Join(mediaTypes, ", "))
+			app.errorResponse(w, r, http.StatusNotAcceptable, "not_acceptable", message)
+			return nil
+		}
+		enc = jsonEncoder
+	}
+
+	pretty := app.config.env == "development"
+	if qs := r.URL.Query(); qs.Has("pretty") {
+		value, err := strconv.ParseBool(qs.Get("pretty"))
+		pretty = err != nil || value
+	}
+
+	body, err := enc.encode(data, pretty)
 	if err != nil {
 		return err
 	}
-	js = append(js, '\n')
+
+	contentType := enc.mediaTypes[0]
+	// callers may name a JSON-based media type, such as application/problem+json for errors
+	if ct := headers.Get("Content-Type"); enc == jsonEncoder && strings.HasSuffix(ct, "+json") {
+		contentType = ct
+	}
 
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
-	w.Write(js)
+	w.Write(body)
 	return nil
 }
 
+// maxJSONBodyBytes is the largest request body readJSON accepts
+const maxJSONBodyBytes = 1_048_576
+
+// readJSON decodes the request body into dst. Bodies sent as MessagePack or CBOR are converted to
+// JSON first, so they are held to the same rules and get the same errors with the format's name.
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
-	maxBytes := 1_048_576
+	maxBytes := maxJSONBodyBytes
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
-	dec := json.NewDecoder(r.Body)
+
+	var maxBytesError *http.MaxBytesError
+
+	name, body := "JSON", io.Reader(r.Body)
+	if format := requestFormat(r.Header.Get("Content-Type")); format != nil {
+		name = format.name
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			if errors.As(err, &maxBytesError) {
+				return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			}
+			return err
+		}
+		if len(data) == 0 {
+			return errors.New("body must not be empty")
+		}
+
+		v, err := format.unmarshal(data)
+		if err != nil {
+			if offset, ok := binarySyntaxOffset(err); ok {
+				return fmt.Errorf("body contains badly-formed %s (at byte %d)", name, offset)
+			}
+			return err
+		}
+		js, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(js)
+	}
+
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 
 	err := dec.Decode(dst)
@@ -57,7 +151,6 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
-		var maxBytesError *http.MaxBytesError
 
 		switch {
 		case errors.As(err, &syntaxError):
@@ -68,9 +161,12 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON types for field %q", unmarshalTypeError.Field)
+				return fmt.Errorf("body contains incorrect %s types for field %q", name, unmarshalTypeError.Field)
 			}
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+			if name != "JSON" {
+				return fmt.Errorf("body contains incorrect %s types", name)
+			}
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", unmarshalTypeError.Offset)
 
 		case errors.Is(err, io.EOF):
 			return errors.New("body must not be empty")
@@ -91,7 +187,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	}
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must only contain a single JSON value")
+		return fmt.Errorf("body must only contain a single %s value", name)
 	}
 	return nil
 }
@@ -127,12 +223,91 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddError(key, validator.CodeInvalidType, "must be an integer value")
 		return defaultValue
 	}
 	return i
 }
 
+// reads bool value from the query key in the URL parameter
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, validator.CodeInvalidType, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, validator.CodeInvalidType, "must be a number")
+		return defaultValue
+	}
+	return f
+}
+
+// reads a timestamp from the query key in the URL parameter, either in RFC 3339 format or as a plain date
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t
+	}
+	v.AddError(key, validator.CodeInvalidFormat, "must be a date (2006-01-02) or an RFC 3339 timestamp")
+	return time.Time{}
+}
+
+// reads a "min,max" pair of integers from the query key in the URL parameter. Either side may be
+// left empty, e.g. "90," for at least 90, which comes back as 0.
+func (app *application) readIntRange(qs url.Values, key string, v *validator.Validator) (int, int) {
+	s := qs.Get(key)
+
+	if s == "" {
+		return 0, 0
+	}
+
+	lower, upper, found := strings.Cut(s, ",")
+	if !found {
+		v.AddError(key, validator.CodeInvalidFormat, "must be two comma-separated integers, e.g. 90,120")
+		return 0, 0
+	}
+
+	var bounds [2]int
+	for i, part := range []string{lower, upper} {
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			v.AddError(key, validator.CodeInvalidFormat, "must be two comma-separated integers, e.g. 90,120")
+			return 0, 0
+		}
+		bounds[i] = n
+	}
+	return bounds[0], bounds[1]
+}
+
 // launches a background go routine from the code block in the function and recovers from panic from that go routine
 func (app *application) background(fn func()) {
 
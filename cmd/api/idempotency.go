@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+	// the body is read up front to fingerprint the request, so keyed requests are held to the size
+	// readJSON allows. Large uploads such as imports cannot be made idempotent this way.
+	maxIdempotentBodyBytes = 1_048_576
+)
+
+// the headers stored and replayed with a response, the others are set again on every request
+var idempotentHeaders = []string{"Content-Type", "Location", "Content-Location", "ETag"}
+
+// recordingResponseWriter passes a response through while keeping a copy of it to store
+type recordingResponseWriter struct {
+	wrapper       http.ResponseWriter
+	statusCode    int
+	headerWritten bool
+	body          bytes.Buffer
+}
+
+func (rw *recordingResponseWriter) Header() http.Header {
+	return rw.wrapper.Header()
+}
+
+func (rw *recordingResponseWriter) WriteHeader(statusCode int) {
+	rw.wrapper.WriteHeader(statusCode)
+
+	if !rw.headerWritten {
+		rw.statusCode = statusCode
+		rw.headerWritten = true
+	}
+}
+
+func (rw *recordingResponseWriter) Write(b []byte) (int, error) {
+	if !rw.headerWritten {
+		rw.statusCode = http.StatusOK
+		rw.headerWritten = true
+	}
+	rw.body.Write(b)
+
+	return rw.wrapper.Write(b)
+}
+
+func (rw *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.wrapper
+}
+
+// requestFingerprint identifies what a request asks for, so that a key sent again with a
+// different request can be told apart from a retry. The negotiated headers are part of it since
+// the stored body is in the format and language the first request was answered in.
+func requestFingerprint(r *http.Request, body []byte) []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.RawQuery)
+	fmt.Fprintf(h, "Accept: %s\nAccept-Language: %s\n", r.Header.Get("Accept"), r.Header.Get("Accept-Language"))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// idempotency makes unsafe requests that carry an Idempotency-Key safe to retry. The first
+// request with a key runs and its response is stored, and retries by the same user with the
+// same request get that response back until the retention window ends. Server errors are not
+// stored, so that a retry runs the request again. Keys are scoped to the user. Anonymous requests,
+// such as registrations, share a scope and are further narrowed down to the request, so a client
+// sending another client's key with a different request runs it afresh rather than being told the
+// key is taken, and only the very same request is ever replayed.
+func (app *application) idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch && r.Method != http.MethodDelete) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user := app.contextGetUser(r)
+		if len(key) > maxIdempotencyKeyLength {
+			app.badRequestResponse(w, r, fmt.Errorf("the %s header must not be more than %d bytes long", idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesError):
+				message := fmt.Sprintf("requests with an %s header must not have a body larger than %d bytes", idempotencyKeyHeader, maxBytesError.Limit)
+				app.errorResponse(w, r, http.StatusRequestEntityTooLarge, "body_too_large", message)
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := requestFingerprint(r, body)
+		if user.IsAnonymous() {
+			key += "/" + hex.EncodeToString(fingerprint)
+		}
+
+		stored, err := app.store.Idempotency.Begin(r.Context(), user.ID, key, fingerprint, app.config.idempotency.retention)
+		switch {
+		case errors.Is(err, store.ErrIdempotencyKeyInUse):
+			app.errorResponse(w, r, http.StatusConflict, "idempotency_key_in_use", "a request with this idempotency key is still being processed, please retry later")
+			return
+		case errors.Is(err, store.ErrIdempotencyKeyReused):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, "idempotency_key_reused", "the idempotency key has already been used for a different request")
+			return
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return
+		case stored != nil:
+			for name, values := range stored.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		completed := false
+		// a panic or a server error gives the key up, on a fresh context since the client may be gone
+		defer func() {
+			if completed {
+				return
+			}
+			if err := app.store.Idempotency.Release(context.Background(), user.ID, key); err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		rw := &recordingResponseWriter{wrapper: w}
+		next.ServeHTTP(rw, r)
+
+		if rw.statusCode == 0 {
+			rw.statusCode = http.StatusOK
+		}
+		if rw.statusCode >= http.StatusInternalServerError {
+			return
+		}
+
+		resp := &store.IdempotentResponse{
+			Status: rw.statusCode,
+			Header: make(map[string][]string),
+			Body:   rw.body.Bytes(),
+		}
+		for _, name := range idempotentHeaders {
+			if values := rw.Header().Values(name); len(values) > 0 {
+				resp.Header[name] = values
+			}
+		}
+
+		if err := app.store.Idempotency.Complete(context.Background(), user.ID, key, resp); err != nil {
+			app.logError(r, err)
+			return
+		}
+		completed = true
+	})
+}
+
+// removes the idempotency keys that have outlived the retention window. Every replica runs
+// the job, which is harmless since a delete of expired rows can run any number of times.
+func (app *application) purgeIdempotencyKeys() {
+	for range time.Tick(time.Hour) {
+		n, err := app.store.Idempotency.DeleteExpired(context.Background(), app.config.idempotency.retention)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"task": "idempotency key purge"})
+			continue
+		}
+		app.logger.PrintInfo("expired idempotency keys purged", map[string]string{"keys": strconv.FormatInt(n, 10)})
+	}
+}
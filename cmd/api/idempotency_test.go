@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+// memoryIdempotencyStore keeps keys the way IdempotencyStore does, without expiry
+type memoryIdempotencyStore struct {
+	mu   sync.Mutex
+	keys map[string]*memoryIdempotencyKey
+}
+
+type memoryIdempotencyKey struct {
+	fingerprint []byte
+	resp        *store.IdempotentResponse
+}
+
+func (s *memoryIdempotencyStore) id(userID int64, key string) string {
+	return fmt.Sprintf("%d/%s", userID, key)
+}
+
+func (s *memoryIdempotencyStore) Begin(ctx context.Context, userID int64, key string, fingerprint []byte, retention time.Duration) (*store.IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.keys[s.id(userID, key)]
+	switch {
+	case !ok:
+		s.keys[s.id(userID, key)] = &memoryIdempotencyKey{fingerprint: fingerprint}
+		return nil, nil
+	case !bytes.Equal(stored.fingerprint, fingerprint):
+		return nil, store.ErrIdempotencyKeyReused
+	case stored.resp == nil:
+		return nil, store.ErrIdempotencyKeyInUse
+	}
+	return stored.resp, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, userID int64, key string, resp *store.IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[s.id(userID, key)].resp = resp
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, userID int64, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, s.id(userID, key))
+	return nil
+}
+
+func (s *memoryIdempotencyStore) DeleteExpired(ctx context.Context, retention time.Duration) (int64, error) {
+	return 0, nil
+}
+
+// idempotencyTest runs requests through the idempotency middleware in front of a handler that
+// counts its calls, answering with status. The requests carry header on top of the key.
+type idempotencyTest struct {
+	app    *application
+	calls  int
+	status int
+	header http.Header
+}
+
+func newIdempotencyTest() *idempotencyTest {
+	app := &application{store: store.Storage{Idempotency: &memoryIdempotencyStore{keys: make(map[string]*memoryIdempotencyKey)}}}
+	app.config.idempotency.retention = time.Hour
+
+	return &idempotencyTest{app: app, status: http.StatusCreated}
+}
+
+func (tt *idempotencyTest) do(user *store.User, key, body string) *httptest.ResponseRecorder {
+	handler := tt.app.idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tt.calls++
+		w.Header().Set("Location", fmt.Sprintf("/v1/movies/%d", tt.calls))
+		tt.app.writeJSON(w, r, tt.status, envelope{"call": tt.calls}, nil)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies", bytes.NewBufferString(body))
+	for name, values := range tt.header {
+		r.Header[name] = values
+	}
+	if key != "" {
+		r.Header.Set(idempotencyKeyHeader, key)
+	}
+	r = tt.app.contextSetUser(r, user)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func problemCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var problem struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("response is not a problem: %v", err)
+	}
+	return problem.Code
+}
+
+func TestIdempotencyReplaysTheFirstResponse(t *testing.T) {
+	tt := newIdempotencyTest()
+	user := &store.User{ID: 1}
+
+	first := tt.do(user, "key-1", `{"title":"Moana"}`)
+	second := tt.do(user, "key-1", `{"title":"Moana"}`)
+
+	if tt.calls != 1 {
+		t.Fatalf("the handler ran %d times, want 1", tt.calls)
+	}
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Errorf("replayed %d %s, want %d %s", second.Code, second.Body, first.Code, first.Body)
+	}
+	if got, want := second.Header().Get("Location"), first.Header().Get("Location"); got != want {
+		t.Errorf("replayed Location %q, want %q", got, want)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("the replay is missing Idempotent-Replayed")
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("the first response is marked as replayed")
+	}
+}
+
+func TestIdempotencyRejectsAKeyReusedForAnotherRequest(t *testing.T) {
+	tt := newIdempotencyTest()
+	user := &store.User{ID: 1}
+
+	tt.do(user, "key-1", `{"title":"Moana"}`)
+	w := tt.do(user, "key-1", `{"title":"Black Panther"}`)
+
+	if tt.calls != 1 {
+		t.Fatalf("the handler ran %d times, want 1", tt.calls)
+	}
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+	if code := problemCode(t, w); code != "idempotency_key_reused" {
+		t.Errorf("got code %q, want idempotency_key_reused", code)
+	}
+}
+
+func TestIdempotencyRejectsAKeyInUse(t *testing.T) {
+	tt := newIdempotencyTest()
+	user := &store.User{ID: 1}
+
+	// claimed by a request that has not completed
+	fingerprint := requestFingerprint(httptest.NewRequest(http.MethodPost, "/v1/movies", nil), []byte(`{}`))
+	tt.app.store.Idempotency.Begin(context.Background(), user.ID, "key-1", fingerprint, time.Hour)
+
+	w := tt.do(user, "key-1", `{}`)
+	if tt.calls != 0 {
+		t.Fatalf("the handler ran %d times, want 0", tt.calls)
+	}
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if code := problemCode(t, w); code != "idempotency_key_in_use" {
+		t.Errorf("got code %q, want idempotency_key_in_use", code)
+	}
+}
+
+func TestIdempotencyScopesKeysToTheUser(t *testing.T) {
+	tt := newIdempotencyTest()
+
+	tt.do(&store.User{ID: 1}, "key-1", `{"title":"Moana"}`)
+	w := tt.do(&store.User{ID: 2}, "key-1", `{"title":"Moana"}`)
+
+	if tt.calls != 2 {
+		t.Fatalf("the handler ran %d times, want 2", tt.calls)
+	}
+	if w.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("another user's response was replayed")
+	}
+}
+
+func TestIdempotencyRetriesServerErrors(t *testing.T) {
+	tt := newIdempotencyTest()
+	user := &store.User{ID: 1}
+
+	tt.status = http.StatusInternalServerError
+	tt.do(user, "key-1", `{"title":"Moana"}`)
+	tt.status = http.StatusCreated
+	w := tt.do(user, "key-1", `{"title":"Moana"}`)
+
+	if tt.calls != 2 {
+		t.Fatalf("the handler ran %d times, want 2", tt.calls)
+	}
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusCreated)
+	}
+}
+
+func TestIdempotencyFingerprintsTheAcceptHeader(t *testing.T) {
+	tt := newIdempotencyTest()
+	user := &store.User{ID: 1}
+
+	tt.do(user, "key-1", `{"title":"Moana"}`)
+	// the stored response is JSON, which a retry asking for CBOR must not be given
+	tt.header = http.Header{"Accept": {"application/cbor"}}
+	w := tt.do(user, "key-1", `{"title":"Moana"}`)
+
+	if tt.calls != 1 {
+		t.Fatalf("the handler ran %d times, want 1", tt.calls)
+	}
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotencyReplaysAnonymousRequests(t *testing.T) {
+	tt := newIdempotencyTest()
+
+	first := tt.do(store.AnonymousUser, "key-1", `{"email":"alice@example.com"}`)
+	second := tt.do(store.AnonymousUser, "key-1", `{"email":"alice@example.com"}`)
+
+	if tt.calls != 1 {
+		t.Fatalf("the handler ran %d times, want 1", tt.calls)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" || second.Body.String() != first.Body.String() {
+		t.Errorf("replayed %d %s, want %d %s", second.Code, second.Body, first.Code, first.Body)
+	}
+}
+
+func TestIdempotencyKeepsAnonymousRequestsApart(t *testing.T) {
+	tt := newIdempotencyTest()
+
+	tt.do(store.AnonymousUser, "key-1", `{"email":"alice@example.com"}`)
+	// another client picking the same key neither gets the first response nor learns the key is taken
+	w := tt.do(store.AnonymousUser, "key-1", `{"email":"bob@example.com"}`)
+
+	if tt.calls != 2 {
+		t.Fatalf("the handler ran %d times, want 2", tt.calls)
+	}
+	if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("got status %d replayed %q, want a fresh %d", w.Code, w.Header().Get("Idempotent-Replayed"), http.StatusCreated)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"call":2`)) {
+		t.Errorf("got %s, want the response of the second call", w.Body)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/importer"
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+// picks the import format from the Content-Type header when the format query parameter is not set
+func importFormatFromContentType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "text/csv":
+		return store.ImportFormatCSV
+	case "application/x-ndjson", "application/jsonl":
+		return store.ImportFormatNDJSON
+	default:
+		return ""
+	}
+}
+
+func (app *application) createImportHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	qs := r.URL.Query()
+	job := &store.ImportJob{
+		CreatedBy: &user.ID,
+		Format:    app.readString(qs, "format", importFormatFromContentType(r)),
+		Mode:      app.readString(qs, "mode", store.ImportModeSkip),
+	}
+
+	v := validator.New()
+	if store.ValidateImportJob(v, job); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// large files take longer to upload than the server's ReadTimeout allows
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Now().Add(app.config.imports.uploadTimeout)); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the body is spooled to disk because the import keeps running after the response is sent
+	file, err := os.CreateTemp("", "greenlight-import-*")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, app.config.imports.maxBytes)
+	n, err := io.Copy(file, body)
+	if err == nil && n == 0 {
+		err = errors.New("body must not be empty")
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.store.Imports.CreateJob(r.Context(), job)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the background import keeps updating job, so the response is built from a copy
+	response := *job
+
+	app.background(func() {
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		im := importer.New(app.store, app.config.imports.batchSize)
+		if err := im.Run(context.Background(), job, file); err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"import_job": fmt.Sprintf("%d", job.ID),
+			})
+		}
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/imports/movies/%d", response.ID))
+
+	if err := app.writeJSON(w, r, http.StatusAccepted, envelope{"import": response}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showImportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.store.Imports.GetJob(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"import": job}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
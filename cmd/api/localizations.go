@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+// the number of language ranges read from an Accept-Language header
+const maxAcceptLanguages = 10
+
+// acceptedLocales returns the locales to look titles up by for an Accept-Language header, the
+// preferred first. Each language range is followed by its shorter prefixes, so that fr-CA falls
+// back to fr before the next range is tried.
+func acceptedLocales(header string) []string {
+	type languageRange struct {
+		tag string
+		q   float64
+	}
+
+	var ranges []languageRange
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+
+		ranges = append(ranges, languageRange{tag: tag, q: q})
+		if len(ranges) == maxAcceptLanguages {
+			break
+		}
+	}
+
+	slices.SortStableFunc(ranges, func(a, b languageRange) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	var locales []string
+	for _, r := range ranges {
+		for tag := r.tag; tag != ""; {
+			if validator.Matches(tag, store.LocaleRX) && !slices.Contains(locales, tag) {
+				locales = append(locales, tag)
+			}
+			i := strings.LastIndex(tag, "-")
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return locales
+}
+
+// localizeMovies replaces the titles of the movies with the best localized title for the
+// request's Accept-Language header, keeping the original in OriginalTitle. The movies must
+// only be used for the response afterwards.
+func (app *application) localizeMovies(w http.ResponseWriter, r *http.Request, movies ...*store.Movie) error {
+	w.Header().Add("Vary", "Accept-Language")
+
+	locales := acceptedLocales(r.Header.Get("Accept-Language"))
+	if len(locales) == 0 || len(movies) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(movies))
+	for _, movie := range movies {
+		ids = append(ids, movie.ID)
+	}
+
+	titles, err := app.store.Movies.GetTitles(r.Context(), ids, locales)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		for _, locale := range locales {
+			if title, ok := titles[movie.ID][locale]; ok {
+				movie.OriginalTitle = movie.Title
+				movie.Title = title
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (app *application) showMovieLocalizationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// the movie is read first so that a missing movie is told apart from one without localizations
+	movie, err := app.store.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	localizations, err := app.store.Movies.GetLocalizations(r.Context(), movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.writeRepresentation(w, r, movieETag(movie), envelope{"localizations": localizations}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type updateMovieLocalizationsPayload struct {
+	Titles   map[string]string    `json:"titles"`
+	Releases []store.MovieRelease `json:"releases"`
+}
+
+// updateMovieLocalizationsHandler replaces the alternate titles, the releases or both, leaving
+// out whichever the body does not mention. PUT is not available for the path, httprouter would
+// not let it sit next to /v1/movies/by-external.
+func (app *application) updateMovieLocalizationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.store.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.preconditionsMet(w, r, movieETag(movie), int64(movie.Version)) {
+		return
+	}
+
+	var payload updateMovieLocalizationsPayload
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	localizations, err := app.store.Movies.GetLocalizations(r.Context(), movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// the titles and releases are replaced below, never modified, so a shallow copy keeps them
+	previous := *localizations
+
+	// locales are matched in lower case and countries in upper case
+	if payload.Titles != nil {
+		localizations.Titles = make(map[string]string, len(payload.Titles))
+		for locale, title := range payload.Titles {
+			localizations.Titles[strings.ToLower(locale)] = title
+		}
+	}
+	if payload.Releases != nil {
+		localizations.Releases = payload.Releases
+		for i := range localizations.Releases {
+			localizations.Releases[i].Country = strings.ToUpper(localizations.Releases[i].Country)
+		}
+	}
+
+	v := validator.New()
+	if store.ValidateLocalizations(v, localizations); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	before := *movie
+
+	err = app.store.Tx.InTx(r.Context(), func(tx store.TxStorage) error {
+		if err := tx.Movies.ReplaceLocalizations(r.Context(), movie, localizations); err != nil {
+			return err
+		}
+
+		rev := store.NewMovieRevision(store.RevisionActionLocalize, app.contextGetUser(r).ID, &before, movie)
+		if !maps.Equal(previous.Titles, localizations.Titles) {
+			rev.Changes["titles"] = store.FieldChange{From: previous.Titles, To: localizations.Titles}
+		}
+		if !slices.Equal(previous.Releases, localizations.Releases) {
+			rev.Changes["releases"] = store.FieldChange{From: previous.Releases, To: localizations.Releases}
+		}
+		return tx.Revisions.Create(r.Context(), rev)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", movieETag(movie))
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie, "localizations": localizations}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
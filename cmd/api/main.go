@@ -3,20 +3,26 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"expvar"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/AmiyoKm/green_light/internal/auth"
 	"github.com/AmiyoKm/green_light/internal/env"
+	"github.com/AmiyoKm/green_light/internal/graphql"
+	"github.com/AmiyoKm/green_light/internal/importer"
 	"github.com/AmiyoKm/green_light/internal/jsonlog"
 	"github.com/AmiyoKm/green_light/internal/mailer"
+	"github.com/AmiyoKm/green_light/internal/recommend"
 	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/suggest"
 	"github.com/AmiyoKm/green_light/internal/vcs"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -39,6 +45,14 @@ type config struct {
 		rps     float64
 		burst   int
 		enabled bool
+		export  struct {
+			rps   float64
+			burst int
+		}
+		suggest struct {
+			rps   float64
+			burst int
+		}
 	}
 	smtp struct {
 		host     string
@@ -55,6 +69,38 @@ type config struct {
 		exp    time.Duration
 		iss    string
 	}
+	export struct {
+		timeout time.Duration
+	}
+	search struct {
+		language string
+	}
+	suggest struct {
+		fallbackTimeout time.Duration
+		refreshInterval time.Duration
+	}
+	stats struct {
+		cacheTTL time.Duration
+	}
+	recommend struct {
+		refreshInterval time.Duration
+		perMovie        int
+	}
+	idempotency struct {
+		retention time.Duration
+	}
+	errors struct {
+		legacy bool
+	}
+	imports struct {
+		maxBytes      int64
+		batchSize     int
+		uploadTimeout time.Duration
+	}
+	graphql struct {
+		maxDepth      int
+		maxComplexity int
+	}
 }
 type application struct {
 	config        config
@@ -63,6 +109,12 @@ type application struct {
 	mailer        mailer.Mailer
 	wg            sync.WaitGroup
 	authenticator auth.Authenticator
+	suggest       *suggest.Index
+	statsCache    *statsCache
+	// openapi is the OpenAPI document of the routes, built along with them
+	openapi envelope
+	spec    *apiSpec
+	graphql *graphql.Schema
 }
 
 func main() {
@@ -72,6 +124,14 @@ func main() {
 		logger.PrintInfo("No .env file found, using environment variables", nil)
 	}
 
+	// subcommands are dispatched before the server flags are parsed
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImportCommand(logger, os.Args[2:]); err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+
 	var cfg config
 
 	flag.IntVar(&cfg.port, "port", 8080, "API server port")
@@ -85,6 +145,10 @@ func main() {
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limit requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limit burst size")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiting")
+	flag.Float64Var(&cfg.limiter.export.rps, "limiter-export-rps", 0.1, "Rate limit requests per second for catalogue exports")
+	flag.IntVar(&cfg.limiter.export.burst, "limiter-export-burst", 2, "Rate limit burst size for catalogue exports")
+	flag.Float64Var(&cfg.limiter.suggest.rps, "limiter-suggest-rps", 10, "Rate limit requests per second for title suggestions")
+	flag.IntVar(&cfg.limiter.suggest.burst, "limiter-suggest-burst", 20, "Rate limit burst size for title suggestions")
 
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
@@ -98,7 +162,32 @@ func main() {
 	})
 	flag.StringVar(&cfg.jwt.secret, "jwt-secret", env.GetString("JWT_SECRET", ""), "JWT SECRET")
 	flag.StringVar(&cfg.jwt.iss, "jwt-iss", env.GetString("JWT_ISS", "greenlight"), "JWT SECRET")
+
+	flag.StringVar(&cfg.search.language, "search-language", env.GetString("SEARCH_LANGUAGE", "english"), "Text search configuration used to stem q= searches ("+strings.Join(store.SearchLanguages, "|")+")")
+
+	flag.DurationVar(&cfg.suggest.fallbackTimeout, "suggest-fallback-timeout", 150*time.Millisecond, "Maximum time spent on the trigram fallback for title suggestions")
+	flag.DurationVar(&cfg.suggest.refreshInterval, "suggest-refresh-interval", 5*time.Minute, "Interval between full reloads of the title suggestion index")
+
+	flag.DurationVar(&cfg.export.timeout, "export-timeout", 30*time.Minute, "Maximum time allowed to stream a catalogue export")
+
+	flag.DurationVar(&cfg.stats.cacheTTL, "stats-cache-ttl", 5*time.Minute, "Time catalogue statistics are cached for")
+
+	flag.DurationVar(&cfg.recommend.refreshInterval, "recommend-refresh-interval", time.Hour, "Interval between rebuilds of the movie similarity table")
+	flag.IntVar(&cfg.recommend.perMovie, "recommend-per-movie", recommend.DefaultPerMovie, "Number of similar movies kept per movie")
+
+	flag.DurationVar(&cfg.idempotency.retention, "idempotency-retention", 24*time.Hour, "Time the response to a request with an Idempotency-Key is replayed for")
+
+	flag.BoolVar(&cfg.errors.legacy, "legacy-errors", false, `Respond to errors with the {"error": ...} shape instead of application/problem+json`)
+
+	flag.Int64Var(&cfg.imports.maxBytes, "import-max-bytes", 1<<30, "Maximum size of an uploaded import file in bytes")
+	flag.IntVar(&cfg.imports.batchSize, "import-batch-size", importer.DefaultBatchSize, "Number of rows written per import batch")
+	flag.DurationVar(&cfg.imports.uploadTimeout, "import-upload-timeout", 10*time.Minute, "Maximum time allowed to upload an import file")
+
+	flag.IntVar(&cfg.graphql.maxDepth, "graphql-max-depth", 10, "Maximum depth of GraphQL queries (0 for no limit)")
+	flag.IntVar(&cfg.graphql.maxComplexity, "graphql-max-complexity", 1000, "Maximum complexity of GraphQL queries, counting each field selected on each item of a page (0 for no limit)")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
+	openAPIPath := flag.String("openapi", "", "Write the OpenAPI document to the file and exit")
 
 	flag.Parse()
 
@@ -109,6 +198,24 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *openAPIPath != "" {
+		app := &application{config: cfg}
+		app.routeTable()
+
+		js, err := json.MarshalIndent(app.openapi, "", "\t")
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		if err := os.WriteFile(*openAPIPath, append(js, '\n'), 0o644); err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		os.Exit(0)
+	}
+
+	if !slices.Contains(store.SearchLanguages, cfg.search.language) {
+		logger.PrintFatal(fmt.Errorf("unsupported search language %q", cfg.search.language), nil)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -116,7 +223,8 @@ func main() {
 	defer db.Close()
 	logger.PrintInfo("Database connection pool established", nil)
 
-	storage := store.NewStorage(db)
+	suggestIndex := suggest.New()
+	storage := store.NewStorage(db, suggestIndex)
 
 	expvar.NewString(version).Set(version)
 
@@ -138,8 +246,14 @@ func main() {
 		store:         storage,
 		authenticator: auth.NewJWTAuthenticator(cfg.jwt.secret, cfg.jwt.iss, cfg.jwt.iss),
 		mailer:        mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		suggest:       suggestIndex,
+		statsCache:    newStatsCache(cfg.stats.cacheTTL),
 	}
 
+	go app.refreshSuggestIndex()
+	go app.refreshRecommendations()
+	go app.purgeIdempotencyKeys()
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+func (app *application) listDuplicateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Threshold float64
+		store.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	input.Threshold = app.readFloat(qs, "threshold", 0.6, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// candidates are always listed best match first
+	input.Filters.SortSafeList = []string{"-score"}
+	input.Filters.Sort = "-score"
+
+	v.Check(input.Threshold >= 0.3, "threshold", validator.CodeTooSmall, "must be at least 0.3")
+	v.Check(input.Threshold <= 1, "threshold", validator.CodeTooLarge, "must be at most 1")
+
+	if store.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	candidates, metadata, err := app.store.Movies.GetDuplicateCandidates(r.Context(), input.Threshold, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"duplicates": candidates, "metadata": metadata}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type mergeMoviePayload struct {
+	Into int64 `json:"into"`
+}
+
+// mergeMovieHandler folds the movie in the URL into the movie given as "into", which survives.
+// If-Match is checked against the survivor.
+func (app *application) mergeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var payload mergeMoviePayload
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.Into > 0, "into", validator.CodeRequired, "must be provided")
+	v.Check(payload.Into != id, "into", validator.CodeInvalid, "must not be the movie being merged")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	duplicate, err := app.store.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	survivor, err := app.store.Movies.Get(r.Context(), payload.Into)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			v.AddError("into", validator.CodeNotFound, "must be the id of an existing movie")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.preconditionsMet(w, r, movieETag(survivor), int64(survivor.Version)) {
+		return
+	}
+
+	before := *survivor
+
+	err = app.store.Movies.Merge(r.Context(), duplicate.ID, survivor)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound), errors.Is(err, store.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the merge shows up in the history of both movies
+	user := app.contextGetUser(r)
+
+	rev := store.NewMovieRevision(store.RevisionActionMerge, user.ID, duplicate, nil)
+	rev.Changes["merged_into"] = store.FieldChange{From: nil, To: survivor.ID}
+	if err := app.store.Revisions.Create(r.Context(), rev); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	rev = store.NewMovieRevision(store.RevisionActionMerge, user.ID, &before, survivor)
+	rev.Changes["merged_from"] = store.FieldChange{From: nil, To: duplicate.ID}
+	if err := app.store.Revisions.Create(r.Context(), rev); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", movieETag(survivor))
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"movie": survivor}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
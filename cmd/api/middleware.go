@@ -1,9 +1,12 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"expvar"
 	"fmt"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,6 +17,28 @@ import (
 	"golang.org/x/time/rate"
 )
 
+const requestIDHeader = "X-Request-ID"
+
+// the request IDs taken from clients, anything else is replaced to keep logs and headers clean
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestID gives every request an ID, sent back in the X-Request-ID header and in error
+// responses so that a client report can be matched with the logs. An ID set by the client or a
+// proxy in front of the API is kept.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
 // Set a custom header and a error response to the client when the server
 // recovers from an error
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -28,15 +53,25 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+// rateLimiter keeps a token bucket per client IP
+type rateLimiter struct {
+	rps     float64
+	burst   int
+	mu      sync.Mutex
+	clients map[string]*rateLimitClient
+}
+
+type rateLimitClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	l := &rateLimiter{
+		rps:     rps,
+		burst:   burst,
+		clients: make(map[string]*rateLimitClient),
 	}
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
 
 	// creates a background goroutine that runs alongside the main go routine and
 	// deletes ip addresses from the client hashmap that has not made a request in
@@ -45,40 +80,59 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		for {
 			time.Sleep(time.Minute)
 
-			mu.Lock()
-			for ip, client := range clients {
+			l.mu.Lock()
+			for ip, client := range l.clients {
 				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
+					delete(l.clients, ip)
 				}
 			}
-			mu.Unlock()
+			l.mu.Unlock()
 
 		}
 	}()
+	return l
+}
+
+func (l *rateLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, found := l.clients[ip]; !found {
+		// if client ip not found in the clients HashMap then make a new Limiter instance
+		// ip as the key
+		// limiter as the value
+		l.clients[ip] = &rateLimitClient{
+			limiter: rate.NewLimiter(rate.Limit(l.rps), l.burst),
+		}
+	}
+	// update the lastSeen with every new request made
+	l.clients[ip].lastSeen = time.Now()
+
+	return l.clients[ip].limiter.Allow()
+}
+
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	defaultLimiter := newRateLimiter(app.config.limiter.rps, app.config.limiter.burst)
+
+	// paths with their own bucket, so that they neither use up nor get limited by the default one
+	pathLimiters := map[string]*rateLimiter{
+		"/v1/movies/export":  newRateLimiter(app.config.limiter.export.rps, app.config.limiter.export.burst),
+		"/v1/movies/suggest": newRateLimiter(app.config.limiter.suggest.rps, app.config.limiter.suggest.burst),
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if app.config.limiter.enabled {
 			ip := realip.FromRequest(r)
 
-			mu.Lock()
-
-			if _, found := clients[ip]; !found {
-				// if client ip not found in the clients HashMap then make a new Limiter instance
-				// ip as the key
-				// limiter as the value
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
+			limiter, found := pathLimiters[r.URL.Path]
+			if !found {
+				limiter = defaultLimiter
 			}
-			// update the lastSeen with every new request made
-			clients[ip].lastSeen = time.Now()
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
+			if !limiter.allow(ip) {
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
-
-			mu.Unlock()
 		}
 		next.ServeHTTP(w, r)
 	})
@@ -146,53 +200,60 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) requiredAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-		if user.IsAnonymous() {
-			app.authenticationRequiredResponse(w, r)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
-func (app *application) requiredActivatedUser(next http.HandlerFunc) http.HandlerFunc {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-
-		if !user.Activated {
-			app.inactiveAccountResponse(w, r)
-			return
-		}
-
-		next.ServeHTTP(w, r)
+// checkAccess returns the code of the error a user without access to something guarded by access
+// gets, or "" when the user has access. access is accessActivated or a permission code, which
+// the user must hold on top of being activated.
+func (app *application) checkAccess(r *http.Request, access string) (string, error) {
+	user := app.contextGetUser(r)
+
+	switch {
+	case user.IsAnonymous():
+		return "authentication_required", nil
+	case !user.Activated:
+		return "inactive_account", nil
+	case access == accessActivated:
+		return "", nil
+	}
 
+	permissions, err := app.store.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return "", err
+	}
+	if !permissions.Include(access) {
+		return "not_permitted", nil
 	}
-	return app.requiredAuthenticatedUser(fn)
+	return "", nil
 }
 
-// Middleware that checks if the authenticated and activated user has the required permission.
-// This wraps requiredActivatedUser, which itself wraps requiredAuthenticatedUser.
-// The middleware chain is: requirePermission → requiredActivatedUser → requiredAuthenticatedUser.
-// Each middleware is executed in order when next.ServeHTTP() is called.
-func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-
-		permissions, err := app.store.Permissions.GetAllForUser(r.Context(), user.ID)
+// requireAccess turns away the users checkAccess says have no access, with the matching response
+func (app *application) requireAccess(access string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, err := app.checkAccess(r, access)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
-		if !permissions.Include(code) {
+		switch code {
+		case "authentication_required":
+			app.authenticationRequiredResponse(w, r)
+		case "inactive_account":
+			app.inactiveAccountResponse(w, r)
+		case "not_permitted":
 			app.notPermittedResponse(w, r)
-			return
+		default:
+			next.ServeHTTP(w, r)
 		}
-		next.ServeHTTP(w, r)
 	}
-	return app.requiredActivatedUser(fn)
+}
+
+func (app *application) requiredActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return app.requireAccess(accessActivated, next)
+}
+
+// Middleware that checks if the authenticated and activated user has the required permission.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	return app.requireAccess(code, next)
 }
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
@@ -209,13 +270,14 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 				if origin == app.config.cors.trustedOrigins[i] {
 
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Expose-Headers", "ETag, Idempotent-Replayed, X-Request-ID")
 
 					// checks if it is a preflight request by checking method OPTIONS and Header
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 
 						// Adds Access-Control-Allow Headers for response to the preflight request
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, Idempotency-Key, X-Request-ID")
 
 						// Access-Control-Allow-Methods and Access-Control-Allow-Headers cached for 15 seconds.
 						// means no need for again sending the OPTIONS preflight request for 15s.
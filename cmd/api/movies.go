@@ -3,20 +3,24 @@ package main
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
-	"strconv"
+	"slices"
+	"strings"
 
 	"github.com/AmiyoKm/green_light/internal/store"
 	"github.com/AmiyoKm/green_light/internal/validator"
 )
 
+type createMoviePayload struct {
+	Title   string        `json:"title"`
+	Year    int32         `json:"year"`
+	Runtime store.Runtime `json:"runtime"`
+	Genres  []string      `json:"genres"`
+}
+
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Title   string        `json:"title"`
-		Year    int32         `json:"year"`
-		Runtime store.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
+	var payload createMoviePayload
 
 	err := app.readJSON(w, r, &payload)
 	if err != nil {
@@ -32,12 +36,22 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	v := validator.New()
+	movie.Genres, err = app.normalizeGenres(r.Context(), v, "genres", movie.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	if store.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.store.Movies.Create(r.Context(), movie)
+	err = app.store.Tx.InTx(r.Context(), func(tx store.TxStorage) error {
+		if err := tx.Movies.Create(r.Context(), movie); err != nil {
+			return err
+		}
+		return app.recordMovieRevision(r, tx, store.RevisionActionCreate, nil, movie)
+	})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -45,7 +59,8 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
-	if err := app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers); err != nil {
+	headers.Set("ETag", movieETag(movie))
+	if err := app.writeJSON(w, r, http.StatusCreated, envelope{"movie": movie}, headers); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
@@ -56,22 +71,89 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 		return
 	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	fields := app.readCSV(qs, "fields", []string{})
+	includes := app.readIncludes(qs, movieIncludes, v)
+	if store.ValidateMovieFields(v, fields); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
 	movie, err := app.store.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, store.ErrorNotFound):
-			app.notFoundResponse(w, r)
+			app.redirectMergedMovie(w, r, id)
 			return
 		default:
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 	}
-	if err := app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil); err != nil {
+
+	if err := app.localizeMovies(w, r, movie); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	collections, err := app.store.Collections.GetForMovie(r.Context(), movie.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	externalIDs, err := app.store.Movies.GetExternalIDs(r.Context(), movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	body, err := app.movieRepresentation(r, []*store.Movie{movie}, fields, includes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var representation any = movie
+	if objects, ok := body.([]map[string]any); ok {
+		representation = objects[0]
+	}
+
+	headers := make(http.Header)
+	headers.Set("Accept-Patch", strings.Join([]string{"application/json", mergePatchMediaType, jsonPatchMediaType}, ", "))
+	if err := app.writeRepresentation(w, r, movieETag(movie), envelope{"movie": representation, "collections": collections, "external_ids": externalIDs}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// redirectMergedMovie sends a permanent redirect to the movie that the missing movie was merged into
+func (app *application) redirectMergedMovie(w http.ResponseWriter, r *http.Request, id int64) {
+	survivorID, err := app.store.Movies.GetRedirect(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", survivorID))
+	env := envelope{"message": fmt.Sprintf("the movie was merged into movie %d", survivorID)}
+	if err := app.writeJSON(w, r, http.StatusMovedPermanently, env, headers); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+type updateMoviePayload struct {
+	Title   *string        `json:"title"`
+	Year    *int32         `json:"year"`
+	Runtime *store.Runtime `json:"runtime"`
+	Genres  []string       `json:"genres"`
+}
+
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -90,47 +172,61 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 			return
 		}
 	}
-	if r.Header.Get("X-Expected-Version") != "" {
-		if strconv.FormatInt(int64(movie.Version), 32) != r.Header.Get("X-Expected-Version") {
-			app.editConflictResponse(w, r)
-			return
-		}
-	}
-	var payload struct {
-		Title   *string        `json:"title"`
-		Year    *int32         `json:"year"`
-		Runtime *store.Runtime `json:"runtime"`
-		Genres  []string       `json:"genres"`
-	}
-	err = app.readJSON(w, r, &payload)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	if !app.preconditionsMet(w, r, movieETag(movie), int64(movie.Version)) {
 		return
 	}
-	if payload.Title != nil {
-		movie.Title = *payload.Title
-	}
-	if payload.Year != nil {
-		movie.Year = *payload.Year
-	}
-	if payload.Runtime != nil {
-		movie.Runtime = *payload.Runtime
-	}
-	if payload.Genres != nil {
-		movie.Genres = payload.Genres
+	before := *movie
+
+	// merge and JSON patches are picked by Content-Type, anything else is the partial movie body
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchMediaType, jsonPatchMediaType:
+		if !app.patchMovie(w, r, mediaType, movie) {
+			return
+		}
+	default:
+		var payload updateMoviePayload
+		err = app.readJSON(w, r, &payload)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if payload.Title != nil {
+			movie.Title = *payload.Title
+		}
+		if payload.Year != nil {
+			movie.Year = *payload.Year
+		}
+		if payload.Runtime != nil {
+			movie.Runtime = *payload.Runtime
+		}
+		if payload.Genres != nil {
+			movie.Genres = payload.Genres
+		}
 	}
 
 	v := validator.New()
+	if movie.Genres != nil && !slices.Equal(movie.Genres, before.Genres) {
+		movie.Genres, err = app.normalizeGenres(r.Context(), v, "genres", movie.Genres)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 	if store.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
-	ctx := r.Context()
-	err = app.store.Movies.Update(ctx, movie)
-
+	err = app.store.Tx.InTx(r.Context(), func(tx store.TxStorage) error {
+		if err := tx.Movies.Update(r.Context(), movie); err != nil {
+			return err
+		}
+		return app.recordMovieRevision(r, tx, store.RevisionActionUpdate, &before, movie)
+	})
 	if err != nil {
-		switch err {
-		case store.ErrEditConflict:
+		switch {
+		case errors.Is(err, store.ErrEditConflict):
 			app.editConflictResponse(w, r)
 			return
 		default:
@@ -138,7 +234,9 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 			return
 		}
 	}
-	if err := app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil); err != nil {
+
+	w.Header().Set("ETag", movieETag(movie))
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -151,10 +249,10 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.store.Movies.Delete(r.Context(), id)
+	movie, err := app.store.Movies.Get(r.Context(), id)
 	if err != nil {
-		switch err {
-		case store.ErrorNotFound:
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
 			app.notFoundResponse(w, r)
 			return
 		default:
@@ -162,44 +260,143 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 			return
 		}
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	if !app.preconditionsMet(w, r, movieETag(movie), int64(movie.Version)) {
+		return
+	}
+
+	// the delete only goes through if the movie is still the version read above, which is the
+	// one the preconditions were checked against and the revision records
+	err = app.store.Tx.InTx(r.Context(), func(tx store.TxStorage) error {
+		if err := tx.Movies.Delete(r.Context(), movie); err != nil {
+			return err
+		}
+		return app.recordMovieRevision(r, tx, store.RevisionActionDelete, movie, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrEditConflict):
+			app.editConflictResponse(w, r)
+			return
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 }
 
+// allow sorting movies by these features, several of them separated by commas, and a q= search
+// also by relevance. "-" prefix means DESC
+var movieSortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		store.MovieFilter
 		store.Filters
+		Facets   []string
+		Includes []string
 	}
 
 	v := validator.New()
 
 	qs := r.URL.Query()
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.MovieFilter.Title = app.readString(qs, "title", "")
+	input.MovieFilter.Genres = app.readCSV(qs, "genres", []string{})
+	input.MovieFilter.GenresMatch = app.readString(qs, "genres_match", store.GenresMatchAll)
+	input.MovieFilter.GenresNot = app.readCSV(qs, "genres_not", []string{})
+	input.MovieFilter.YearGTE = int32(app.readInt(qs, "year_gte", 0, v))
+	input.MovieFilter.YearLTE = int32(app.readInt(qs, "year_lte", 0, v))
+	runtimeMin, runtimeMax := app.readIntRange(qs, "runtime_between", v)
+	input.MovieFilter.RuntimeMin = int32(runtimeMin)
+	input.MovieFilter.RuntimeMax = int32(runtimeMax)
+	input.MovieFilter.CreatedAfter = app.readTime(qs, "created_after", v)
+	input.MovieFilter.Search = app.readString(qs, "q", "")
+	input.MovieFilter.Language = app.config.search.language
+	input.MovieFilter.Fields = app.readCSV(qs, "fields", []string{})
+	input.Includes = app.readIncludes(qs, movieIncludes, v)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
-	// allow sorting by these features
-	// "-" prefix means DESC
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafeList = movieSortSafeList
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 
+	// a q= search is sorted by relevance unless another sort is asked for
+	if input.MovieFilter.Search != "" {
+		input.Filters.SortSafeList = append(slices.Clone(input.Filters.SortSafeList), "relevance")
+		input.Filters.Sort = app.readString(qs, "sort", "relevance")
+	}
+
+	// the presence of the cursor parameter, even empty, selects keyset pagination
+	input.Filters.UseCursor = qs.Has("cursor")
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.IncludeTotal = app.readBool(qs, "include_total", false, v)
+
+	input.Facets = app.readCSV(qs, "facets", []string{})
+	for _, facet := range input.Facets {
+		v.Check(validator.PermittedValue(facet, store.FacetSafeList...), "facets", validator.CodeNotPermitted, "must be a comma-separated list of genres, decade and runtime")
+	}
+	v.Check(validator.Unique(input.Facets), "facets", validator.CodeDuplicate, "must not contain duplicate values")
+
+	var err error
+	input.MovieFilter.Genres, err = app.resolveGenreFilter(r.Context(), input.MovieFilter.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	input.MovieFilter.GenresNot, err = app.resolveGenreFilter(r.Context(), input.MovieFilter.GenresNot)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	store.ValidateMovieFilter(v, input.MovieFilter)
+	v.Check(!input.Filters.UseCursor || !strings.Contains(input.Filters.Sort, "relevance"), "cursor", validator.CodeNotAllowed, "cannot be used when sorting by relevance")
+
 	if store.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	movies, metadata, err := app.store.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Filters)
+	if len(input.Facets) > 0 {
+		movies, metadata, facets, err := app.store.Movies.GetAllWithFacets(r.Context(), input.MovieFilter, input.Filters, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if err := app.localizeMovies(w, r, movies...); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		body, err := app.movieRepresentation(r, movies, input.MovieFilter.Fields, input.Includes)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if err := app.writeJSON(w, r, http.StatusOK, envelope{"movies": body, "metadata": metadata, "facets": facets}, nil); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movies, metadata, err := app.store.Movies.GetAll(r.Context(), input.MovieFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.localizeMovies(w, r, movies...); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	body, err := app.movieRepresentation(r, movies, input.MovieFilter.Fields, input.Includes)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	if err := app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil); err != nil {
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"movies": body, "metadata": metadata}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -0,0 +1,783 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/AmiyoKm/green_light/internal/graphql"
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+// docsPage browses the OpenAPI document, with nothing to load but the document itself
+//
+//go:embed docs.html
+var docsPage []byte
+
+// jsonSchema is a JSON Schema, as OpenAPI 3.1 uses them
+type jsonSchema = map[string]any
+
+// content is a request or response body by media type. Each value is either a Go value whose type
+// the schema is generated from, or a jsonSchema used as it is.
+type content map[string]any
+
+// fields is an object holding the given members, such as a response envelope. Members are
+// required unless they are wrapped in optional.
+type fields map[string]any
+
+// optional is a member of fields that may be left out
+type optional struct {
+	value any
+}
+
+type queryParam struct {
+	name        string
+	description string
+	schema      jsonSchema
+	required    bool
+}
+
+// operationDoc describes what the route table cannot tell about an operation
+type operationDoc struct {
+	summary     string
+	description string
+	query       []queryParam
+	body        content
+	// status is the status of a successful response, 200 when it is zero
+	status int
+	// response is the body of a successful response, fields or a Go value for JSON, a content for
+	// anything else, or nil for none
+	response any
+	// responses are the bodies of any other statuses that are not problems, in the same forms
+	responses map[int]any
+}
+
+// schemaOverrides are the types whose JSON encoding their Go type does not show
+var schemaOverrides = map[reflect.Type]jsonSchema{
+	reflect.TypeFor[store.Runtime](): {
+		"type":        "string",
+		"pattern":     `^[0-9]+ mins$`,
+		"description": `runtime in minutes, written as "<minutes> mins"`,
+		"examples":    []any{"102 mins"},
+	},
+	reflect.TypeFor[time.Time]():       {"type": "string", "format": "date-time"},
+	reflect.TypeFor[json.RawMessage](): {},
+}
+
+// schemaExtensions are members a type's JSON may have besides its fields
+var schemaExtensions = map[reflect.Type]map[string]reflect.Type{
+	// the related resources include= embeds, see movieIncludes
+	reflect.TypeFor[store.Movie](): {
+		"collections":   reflect.TypeFor[[]store.MovieCollection](),
+		"external_ids":  reflect.TypeFor[[]store.ExternalID](),
+		"localizations": reflect.TypeFor[*store.Localizations](),
+	},
+}
+
+// pathParams are the schemas of the wildcard segments of the routes
+var pathParams = map[string]jsonSchema{
+	"id":          {"type": "integer", "format": "int64", "minimum": 1},
+	"movie_id":    {"type": "integer", "format": "int64", "minimum": 1},
+	"version":     {"type": "integer", "format": "int32", "minimum": 1},
+	"slug":        {"type": "string"},
+	"source":      {"type": "string"},
+	"external_id": {"type": "string"},
+}
+
+func stringParam(name, description string) queryParam {
+	return queryParam{name: name, description: description, schema: jsonSchema{"type": "string"}}
+}
+
+func intParam(name, description string, minimum, maximum int) queryParam {
+	return queryParam{name: name, description: description, schema: jsonSchema{"type": "integer", "minimum": minimum, "maximum": maximum}}
+}
+
+func enumParam(name, description string, values ...string) queryParam {
+	return queryParam{name: name, description: description, schema: jsonSchema{"type": "string", "enum": values}}
+}
+
+// listParam takes a comma-separated list of the values, or of any strings when values is empty
+func listParam(name, description string, values ...string) queryParam {
+	item := `[^,]+`
+	if len(values) > 0 {
+		quoted := make([]string, len(values))
+		for i, value := range values {
+			quoted[i] = regexp.QuoteMeta(value)
+		}
+		item = "(" + strings.Join(quoted, "|") + ")"
+	}
+	return queryParam{name: name, description: description, schema: jsonSchema{"type": "string", "pattern": "^" + item + "(," + item + ")*$"}}
+}
+
+func pageParams() []queryParam {
+	// the bounds of store.ValidateFilters
+	return []queryParam{
+		intParam("page", "page to return, from 1", 1, 9_999_999),
+		intParam("page_size", "number of results per page", 1, 9_999_999),
+	}
+}
+
+func sortParam(values []string) queryParam {
+	return listParam("sort", "comma-separated fields to sort by, a - prefix sorting in descending order", values...)
+}
+
+func listMeta(key string, item any) fields {
+	return fields{key: item, "metadata": store.Metadata{}}
+}
+
+func message(example string) fields {
+	return fields{"message": jsonSchema{"type": "string", "examples": []any{example}}}
+}
+
+var movieBody = fields{"movie": store.Movie{}}
+
+// operationDocs documents the operations by method and route path
+var operationDocs = map[string]operationDoc{
+	"GET /v1/healthcheck": {
+		summary:  "Report the status of the service",
+		response: fields{"status": "", "system_info": map[string]string{}},
+	},
+	"GET /v1/openapi.json": {
+		summary:  "Get this OpenAPI document",
+		response: content{"application/json": jsonSchema{"type": "object"}},
+	},
+	"GET /v1/docs": {
+		summary:  "Browse the API documentation",
+		response: content{"text/html": jsonSchema{"type": "string"}},
+	},
+	"POST /v1/graphql": {
+		summary:     "Run a GraphQL query",
+		description: "Queries movie, movies and me, whose fields need the same access as GET /v1/movies/{id}, GET /v1/movies and GET /v1/users/me. Errors in the query, access errors and invalid arguments are reported in errors with a 200, with the problem code in their extensions. Queries are limited in depth and complexity, where the fields of each movie count once per movie of the page size. Send `{ __schema { types { name } } }` to introspect the schema.",
+		body:        content{"application/json": graphqlRequestPayload{}},
+		response:    fields{"data": optional{jsonSchema{}}, "errors": optional{[]graphql.Error{}}},
+	},
+
+	"GET /v1/movies": {
+		summary:     "List movies",
+		description: "Pages by page number, or by keyset when cursor is present. fields= limits the fields of each movie and include= embeds related resources.",
+		query: append([]queryParam{
+			stringParam("title", "full-text match on the title"),
+			listParam("genres", "genres, or their aliases, the movies must have"),
+			enumParam("genres_match", "whether movies must have all of genres or any of them", store.GenresMatchAll, store.GenresMatchAny),
+			listParam("genres_not", "genres the movies must not have"),
+			intParam("year_gte", "earliest release year", 0, 9999),
+			intParam("year_lte", "latest release year", 0, 9999),
+			{name: "runtime_between", description: "runtime range in minutes as min,max, either side may be empty", schema: jsonSchema{"type": "string", "pattern": `^[0-9]*,[0-9]*$`}},
+			{name: "created_after", description: "date (2006-01-02) or RFC 3339 timestamp the movies were added after", schema: jsonSchema{"type": "string"}},
+			stringParam("q", "search terms, sorting by relevance unless sort is set"),
+			listParam("fields", "fields to return for each movie", store.MovieFields...),
+			listParam("include", "related resources to embed in each movie", movieIncludes...),
+			sortParam(append(slices.Clone(movieSortSafeList), "relevance")),
+			stringParam("cursor", "cursor returned as next_cursor by the previous page, empty for the first page"),
+			{name: "include_total", description: "whether a keyset page counts the total number of movies", schema: jsonSchema{"type": "boolean"}},
+			listParam("facets", "facets to count the matching movies by", store.FacetSafeList...),
+		}, pageParams()...),
+		response: fields{"movies": []store.Movie{}, "metadata": store.Metadata{}, "facets": optional{store.Facets{}}},
+	},
+	"POST /v1/movies": {
+		summary:  "Create a movie",
+		body:     content{"application/json": createMoviePayload{}},
+		status:   http.StatusCreated,
+		response: movieBody,
+	},
+	"GET /v1/movies/export": {
+		summary: "Export the catalogue",
+		query: []queryParam{
+			stringParam("title", "full-text match on the title"),
+			listParam("genres", "genres, or their aliases, the movies must have"),
+			enumParam("format", "format to stream the movies in", exportFormatNDJSON, exportFormatCSV, exportFormatColumnar),
+		},
+		response: content{
+			"application/x-ndjson":     jsonSchema{"type": "string"},
+			"text/csv":                 jsonSchema{"type": "string"},
+			"application/octet-stream": jsonSchema{"type": "string", "contentEncoding": "binary"},
+		},
+	},
+	"GET /v1/movies/suggest": {
+		summary: "Suggest titles and genres as the user types",
+		query: []queryParam{
+			{name: "q", description: "prefix typed so far", schema: jsonSchema{"type": "string", "minLength": 1, "maxLength": 100}, required: true},
+			intParam("limit", "maximum number of suggestions of each kind", 1, 25),
+		},
+		response: fields{"suggestions": fields{"titles": []any{}, "genres": []string{}}},
+	},
+	"GET /v1/movies/lookup": {
+		summary: "Find a movie by the id another catalogue knows it by",
+		query: []queryParam{
+			{name: "source", description: "catalogue the id comes from", schema: jsonSchema{"type": "string"}, required: true},
+			{name: "id", description: "id of the movie in that catalogue", schema: jsonSchema{"type": "string"}, required: true},
+		},
+		response: movieBody,
+	},
+	"GET /v1/movies/:id": {
+		summary: "Get a movie",
+		query: []queryParam{
+			listParam("fields", "fields of the movie to return", store.MovieFields...),
+			listParam("include", "related resources to embed in the movie", movieIncludes...),
+		},
+		response: fields{"movie": store.Movie{}, "collections": []store.MovieCollection{}, "external_ids": []store.ExternalID{}},
+		// a movie merged into another redirects to it
+		responses: map[int]any{http.StatusMovedPermanently: message("the movie was merged into movie 42")},
+	},
+	"POST /v1/movies/batch": {
+		summary:     "Create, update and delete many movies",
+		description: "In atomic mode the first failed operation rolls back the batch, in best_effort mode every operation is committed on its own and a 207 tells that some failed.",
+		body:        content{"application/json": batchMoviesPayload{}},
+		response:    fields{"mode": "", "results": []batchResult{}},
+		// an atomic batch responds with the status of the operation that failed
+		responses: map[int]any{
+			http.StatusMultiStatus:         fields{"mode": "", "results": []batchResult{}},
+			http.StatusNotFound:            fields{"mode": "", "results": []batchResult{}},
+			http.StatusPreconditionFailed:  fields{"mode": "", "results": []batchResult{}},
+			http.StatusUnprocessableEntity: fields{"mode": "", "results": []batchResult{}},
+			http.StatusInternalServerError: fields{"mode": "", "results": []batchResult{}},
+		},
+	},
+	"PUT /v1/movies/by-external/:source/:external_id": {
+		summary:   "Create or update a movie by the id another catalogue knows it by",
+		body:      content{"application/json": upsertMovieByExternalIDPayload{}},
+		response:  movieBody,
+		responses: map[int]any{http.StatusCreated: movieBody},
+	},
+	"PATCH /v1/movies/:id": {
+		summary: "Update a movie",
+		body: content{
+			"application/json":  updateMoviePayload{},
+			mergePatchMediaType: updateMoviePayload{},
+			jsonPatchMediaType:  jsonPatchSchema,
+		},
+		response: movieBody,
+	},
+	"DELETE /v1/movies/:id": {
+		summary:  "Delete a movie",
+		response: message("movie successfully deleted"),
+	},
+	"GET /v1/movies/:id/localizations": {
+		summary:  "Get the localized titles and releases of a movie",
+		response: fields{"localizations": store.Localizations{}},
+	},
+	"PATCH /v1/movies/:id/localizations": {
+		summary:  "Replace the localized titles and releases of a movie",
+		body:     content{"application/json": updateMovieLocalizationsPayload{}},
+		response: fields{"movie": store.Movie{}, "localizations": store.Localizations{}},
+	},
+	"GET /v1/movies/:id/similar": {
+		summary:  "List movies similar to a movie",
+		query:    pageParams(),
+		response: listMeta("similar", []store.SimilarMovie{}),
+	},
+	"GET /v1/movies/:id/revisions": {
+		summary:  "List the revisions of a movie",
+		query:    pageParams(),
+		response: listMeta("revisions", []store.MovieRevision{}),
+	},
+	"GET /v1/movies/:id/revisions/:version": {
+		summary:  "Get a revision of a movie",
+		response: fields{"revision": store.MovieRevision{}},
+	},
+	"POST /v1/movies/:id/revisions/:version/revert": {
+		summary:  "Revert a movie to a revision",
+		response: movieBody,
+	},
+
+	"GET /v1/admin/movies/duplicates": {
+		summary: "List movies that may be duplicates of each other",
+		query: append([]queryParam{
+			{name: "threshold", description: "minimum similarity score", schema: jsonSchema{"type": "number", "minimum": 0.3, "maximum": 1}},
+		}, pageParams()...),
+		response: listMeta("duplicates", []store.DuplicateCandidate{}),
+	},
+	"POST /v1/admin/movies/:id/merge": {
+		summary:  "Merge a duplicate movie into another",
+		body:     content{"application/json": mergeMoviePayload{}},
+		response: movieBody,
+	},
+
+	"GET /v1/collections": {
+		summary: "List the public collections and those of the user",
+		query: append([]queryParam{
+			enumParam("kind", "kind of collections to list", store.CollectionKindFranchise, store.CollectionKindList),
+			sortParam(collectionSortSafeList),
+		}, pageParams()...),
+		response: listMeta("collections", []store.Collection{}),
+	},
+	"POST /v1/collections": {
+		summary:  "Create a collection",
+		body:     content{"application/json": createCollectionPayload{}},
+		status:   http.StatusCreated,
+		response: fields{"collection": store.Collection{}},
+	},
+	"GET /v1/collections/:id": {
+		summary:  "Get a collection and its movies",
+		response: fields{"collection": store.Collection{}},
+	},
+	"PATCH /v1/collections/:id": {
+		summary:  "Update a collection",
+		body:     content{"application/json": updateCollectionPayload{}},
+		response: fields{"collection": store.Collection{}},
+	},
+	"DELETE /v1/collections/:id": {
+		summary:  "Delete a collection",
+		response: message("collection successfully deleted"),
+	},
+	"POST /v1/collections/:id/movies": {
+		summary:  "Add a movie to a collection",
+		body:     content{"application/json": addCollectionMoviePayload{}},
+		response: fields{"collection": store.Collection{}},
+	},
+	"PUT /v1/collections/:id/movies": {
+		summary:  "Reorder the movies of a collection",
+		body:     content{"application/json": reorderCollectionMoviesPayload{}},
+		response: fields{"collection": store.Collection{}},
+	},
+	"DELETE /v1/collections/:id/movies/:movie_id": {
+		summary:  "Remove a movie from a collection",
+		response: fields{"collection": store.Collection{}},
+	},
+
+	"GET /v1/genres": {
+		summary:  "List genres",
+		response: fields{"genres": []store.Genre{}},
+	},
+	"POST /v1/genres": {
+		summary:  "Create a genre",
+		body:     content{"application/json": createGenrePayload{}},
+		status:   http.StatusCreated,
+		response: fields{"genre": store.Genre{}},
+	},
+	"GET /v1/genres/:slug": {
+		summary:  "Get a genre",
+		response: fields{"genre": store.Genre{}},
+	},
+	"PATCH /v1/genres/:slug": {
+		summary:  "Update a genre",
+		body:     content{"application/json": updateGenrePayload{}},
+		response: fields{"genre": store.Genre{}},
+	},
+	"DELETE /v1/genres/:slug": {
+		summary:  "Delete a genre no movie uses",
+		response: message("genre successfully deleted"),
+	},
+
+	"GET /v1/stats/movies": {
+		summary: "Summarise the catalogue",
+		query: []queryParam{
+			listParam("genres", "genres the summarised movies must all have"),
+			intParam("weeks", "number of weeks to count added movies for", 1, 520),
+		},
+		response: fields{"stats": store.MovieStats{}},
+	},
+
+	"POST /v1/imports/movies": {
+		summary:     "Import movies from a file",
+		description: "The file is imported in the background, poll the import for its progress.",
+		query: []queryParam{
+			enumParam("format", "format of the file, taken from the Content-Type when missing", store.ImportFormatCSV, store.ImportFormatNDJSON),
+			enumParam("mode", "what to do with movies that already exist", store.ImportModeSkip, store.ImportModeUpsert, store.ImportModeDryRun),
+		},
+		body: content{
+			"text/csv":             jsonSchema{"type": "string"},
+			"application/x-ndjson": jsonSchema{"type": "string"},
+		},
+		status:   http.StatusAccepted,
+		response: fields{"import": store.ImportJob{}},
+	},
+	"GET /v1/imports/movies/:id": {
+		summary:  "Get the progress of an import",
+		response: fields{"import": store.ImportJob{}},
+	},
+
+	"POST /v1/users": {
+		summary:     "Register a user",
+		description: "An activation token is emailed to the user.",
+		body:        content{"application/json": registerUserPayload{}},
+		status:      http.StatusAccepted,
+		response:    fields{"user": store.User{}},
+	},
+	"GET /v1/users/me": {
+		summary:  "Get the authenticated user",
+		response: fields{"user": store.User{}},
+	},
+	"GET /v1/users/me/recommendations": {
+		summary:  "Recommend movies to the authenticated user",
+		query:    pageParams(),
+		response: listMeta("recommendations", []store.SimilarMovie{}),
+	},
+	"PUT /v1/users/activated": {
+		summary:  "Activate a user with the emailed token",
+		body:     content{"application/json": activateUserPayload{}},
+		response: fields{"user": store.User{}},
+	},
+	"PUT /v1/users/password": {
+		summary:  "Reset a password with the emailed token",
+		body:     content{"application/json": updatePasswordPayload{}},
+		response: message("your password was successfully reset"),
+	},
+	"POST /v1/activation/email/send": {
+		summary:  "Email a new activation token",
+		body:     content{"application/json": activationEmailPayload{}},
+		status:   http.StatusAccepted,
+		response: message("email sent"),
+	},
+
+	"POST /v1/tokens/authentication": {
+		summary:  "Sign in for a bearer token",
+		body:     content{"application/json": authenticationTokenPayload{}},
+		response: fields{"authentication_token": ""},
+	},
+	"POST /v1/tokens/password-reset": {
+		summary:  "Email a password reset token",
+		body:     content{"application/json": passwordResetTokenPayload{}},
+		status:   http.StatusAccepted,
+		response: message("an email will be sent to you containing password reset instruction"),
+	},
+}
+
+const apiDescription = `Every JSON body may also be sent and read as MessagePack or CBOR, picked by the Content-Type and Accept headers, and list responses as CSV. JSON responses are compact unless the pretty query parameter is set.
+
+Errors are RFC 9457 problem details with a stable code. Requests are checked against this document before they are handled, and every field that does not match it is listed in the errors of a validation_failed problem. Versioned resources carry an ETag for If-Match and If-None-Match, and unsafe requests with an Idempotency-Key header are answered with the first response when retried.`
+
+// jsonPatchSchema is an RFC 6902 JSON Patch, as jsonpatch.DecodePatch reads it
+var jsonPatchSchema = jsonSchema{
+	"type": "array",
+	"items": jsonSchema{
+		"type": "object",
+		"properties": jsonSchema{
+			"op":    jsonSchema{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+			"path":  jsonSchema{"type": "string", "format": "json-pointer"},
+			"from":  jsonSchema{"type": "string", "format": "json-pointer"},
+			"value": jsonSchema{},
+		},
+		"required": []string{"op", "path"},
+	},
+}
+
+// problemSchema is the body of every error response
+var problemSchema = jsonSchema{
+	"type": "object",
+	"properties": jsonSchema{
+		"type":       jsonSchema{"type": "string", "format": "uri"},
+		"title":      jsonSchema{"type": "string"},
+		"status":     jsonSchema{"type": "integer"},
+		"detail":     jsonSchema{"type": "string"},
+		"instance":   jsonSchema{"type": "string"},
+		"code":       jsonSchema{"type": "string"},
+		"request_id": jsonSchema{"type": "string"},
+		"errors": jsonSchema{
+			"type": "array",
+			"items": jsonSchema{
+				"type": "object",
+				"properties": jsonSchema{
+					"field":  jsonSchema{"type": "string"},
+					"code":   jsonSchema{"type": "string"},
+					"detail": jsonSchema{"type": "string"},
+				},
+			},
+		},
+	},
+	"required": []string{"type", "title", "status", "code"},
+}
+
+// openAPIPath turns an httprouter path into an OpenAPI one, e.g. /v1/movies/:id into /v1/movies/{id}
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// openAPIDocument builds the OpenAPI document of the routes, taking the rest from operationDocs
+func openAPIDocument(routes []apiRoute) envelope {
+	b := &schemaBuilder{components: make(map[string]any), names: make(map[reflect.Type]string)}
+	paths := make(map[string]any)
+
+	for _, route := range routes {
+		doc := operationDocs[route.method+" "+route.path]
+		path, names := openAPIPath(route.path)
+
+		var params []any
+		for _, name := range names {
+			params = append(params, jsonSchema{"name": name, "in": "path", "required": true, "schema": pathParams[name]})
+		}
+		for _, q := range doc.query {
+			param := jsonSchema{"name": q.name, "in": "query", "schema": q.schema}
+			if q.description != "" {
+				param["description"] = q.description
+			}
+			if q.required {
+				param["required"] = true
+			}
+			params = append(params, param)
+		}
+
+		op := jsonSchema{
+			"operationId": strings.TrimSuffix(route.handler, "Handler"),
+			"summary":     doc.summary,
+			"tags":        []string{strings.Split(route.path, "/")[2]},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+
+		description := doc.description
+		switch route.access {
+		case accessPublic:
+			op["security"] = []any{}
+		case accessActivated:
+			op["x-permission"] = route.access
+			description = strings.TrimSpace(description + "\n\nRequires an activated user.")
+		default:
+			op["x-permission"] = route.access
+			description = strings.TrimSpace(description + fmt.Sprintf("\n\nRequires the `%s` permission.", route.access))
+		}
+		if description != "" {
+			op["description"] = description
+		}
+
+		if doc.body != nil {
+			op["requestBody"] = jsonSchema{"required": true, "content": b.content(doc.body)}
+		}
+
+		status := doc.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		problem := jsonSchema{"$ref": "#/components/responses/Problem"}
+		responses := jsonSchema{fmt.Sprint(status): b.response(status, doc.response), "default": problem}
+		for status, body := range doc.responses {
+			responses[fmt.Sprint(status)] = b.response(status, body)
+		}
+		if route.access != accessPublic {
+			responses["401"] = problem
+			responses["403"] = problem
+		}
+		op["responses"] = responses
+
+		item, ok := paths[path].(jsonSchema)
+		if !ok {
+			item = make(jsonSchema)
+			paths[path] = item
+		}
+		item[strings.ToLower(route.method)] = op
+	}
+
+	b.components["Problem"] = problemSchema
+
+	return envelope{
+		"openapi": "3.1.0",
+		"info": jsonSchema{
+			"title":       "Greenlight API",
+			"version":     "1.0.0",
+			"description": apiDescription,
+		},
+		"paths":    paths,
+		"security": []any{jsonSchema{"bearerAuth": []string{}}},
+		"components": jsonSchema{
+			"schemas": b.components,
+			"responses": jsonSchema{
+				"Problem": jsonSchema{
+					"description": "Problem details of the error",
+					"content": jsonSchema{
+						problemMediaType: jsonSchema{"schema": jsonSchema{"$ref": "#/components/schemas/Problem"}},
+					},
+				},
+			},
+			"securitySchemes": jsonSchema{
+				"bearerAuth": jsonSchema{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+// schemaBuilder generates JSON Schemas for Go types from their JSON encoding. Named structs become
+// components referred to by $ref, and a schema is never emitted for the same name twice.
+type schemaBuilder struct {
+	components map[string]any
+	names      map[reflect.Type]string
+}
+
+func (b *schemaBuilder) response(status int, body any) jsonSchema {
+	response := jsonSchema{"description": http.StatusText(status)}
+	switch body := body.(type) {
+	case nil:
+	case content:
+		response["content"] = b.content(body)
+	default:
+		response["content"] = b.content(content{"application/json": body})
+	}
+	return response
+}
+
+func (b *schemaBuilder) content(c content) jsonSchema {
+	result := make(jsonSchema, len(c))
+	for mediaType, v := range c {
+		result[mediaType] = jsonSchema{"schema": b.value(v)}
+	}
+	return result
+}
+
+func (b *schemaBuilder) value(v any) jsonSchema {
+	switch v := v.(type) {
+	case jsonSchema:
+		return v
+	case fields:
+		properties := make(jsonSchema, len(v))
+		var required []string
+		for name, field := range v {
+			if opt, ok := field.(optional); ok {
+				properties[name] = b.value(opt.value)
+				continue
+			}
+			properties[name] = b.value(field)
+			required = append(required, name)
+		}
+		schema := jsonSchema{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			slices.Sort(required)
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return b.schema(reflect.TypeOf(v))
+	}
+}
+
+// componentName names the schema of a named type, prefixing those outside of the store and main
+// packages with their package, e.g. SuggestTitle
+func componentName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+
+	pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+	if pkg == "store" || pkg == "main" || pkg == "api" {
+		return string(name)
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + string(name)
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) jsonSchema {
+	if t == nil {
+		return jsonSchema{}
+	}
+	if s, ok := schemaOverrides[t]; ok {
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(b.schema(t.Elem()))
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16:
+		return jsonSchema{"type": "integer"}
+	case reflect.Int32, reflect.Uint32:
+		return jsonSchema{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return jsonSchema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return jsonSchema{"type": "string", "contentEncoding": "base64"}
+		}
+		if t.Kind() == reflect.Array {
+			return jsonSchema{"type": "array", "items": b.schema(t.Elem())}
+		}
+		// encoding/json writes nil slices and maps as null
+		return jsonSchema{"type": []string{"array", "null"}, "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": []string{"object", "null"}, "additionalProperties": b.schema(t.Elem())}
+	case reflect.Interface:
+		return jsonSchema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if name, ok := b.names[t]; ok {
+			return jsonSchema{"$ref": "#/components/schemas/" + name}
+		}
+		name := componentName(t)
+		if _, taken := b.components[name]; taken {
+			panic(fmt.Sprintf("openapi: two types are named %s", name))
+		}
+		b.names[t] = name
+		b.components[name] = b.object(t)
+		return jsonSchema{"$ref": "#/components/schemas/" + name}
+	default:
+		panic(fmt.Sprintf("openapi: no schema for %s", t))
+	}
+}
+
+// object lists the fields of a struct as encoding/json writes them. Unknown fields are not
+// allowed, as readJSON rejects them.
+func (b *schemaBuilder) object(t reflect.Type) jsonSchema {
+	properties := make(jsonSchema)
+	b.addFields(properties, t)
+	for name, extension := range schemaExtensions[t] {
+		properties[name] = b.schema(extension)
+	}
+	return jsonSchema{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+func (b *schemaBuilder) addFields(properties jsonSchema, t reflect.Type) {
+	for field := range slices.Values(reflect.VisibleFields(t)) {
+		if !field.IsExported() || len(field.Index) > 1 {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		// embedded structs without a name have their fields promoted
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(properties, field.Type)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+	}
+}
+
+// nullable allows null besides what the schema allows
+func nullable(s jsonSchema) jsonSchema {
+	switch typ := s["type"].(type) {
+	case string:
+		copied := make(jsonSchema, len(s))
+		for k, v := range s {
+			copied[k] = v
+		}
+		copied["type"] = []string{typ, "null"}
+		return copied
+	default:
+		return jsonSchema{"anyOf": []any{s, jsonSchema{"type": "null"}}}
+	}
+}
+
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.writeJSON(w, r, http.StatusOK, app.openapi, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	app := &application{}
+	routes := app.routeTable().routes
+
+	paths := app.openapi["paths"].(map[string]any)
+	registered := make(map[string]bool)
+
+	for _, route := range routes {
+		key := route.method + " " + route.path
+		registered[key] = true
+
+		if doc, ok := operationDocs[key]; !ok || doc.summary == "" {
+			t.Errorf("%s (%s) is missing from operationDocs", key, route.handler)
+		}
+
+		path, _ := openAPIPath(route.path)
+		item, ok := paths[path].(jsonSchema)
+		if !ok {
+			t.Errorf("%s is missing from the document", path)
+			continue
+		}
+		if _, ok := item[strings.ToLower(route.method)]; !ok {
+			t.Errorf("%s is missing from the document", key)
+		}
+	}
+
+	for key := range operationDocs {
+		if !registered[key] {
+			t.Errorf("operationDocs documents %s, which is not a route", key)
+		}
+	}
+}
+
+func TestOpenAPIDocumentIsCurrent(t *testing.T) {
+	committed, err := os.ReadFile("../../docs/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{}
+	app.routeTable()
+	js, err := json.MarshalIndent(app.openapi, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(committed, append(js, '\n')) {
+		t.Error("docs/openapi.json is out of date, run make docs/gen")
+	}
+}
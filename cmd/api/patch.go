@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/AmiyoKm/green_light/internal/jsonpatch"
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// movieDocument is the movie as merge and JSON patches see it. id and version may be tested
+// but not changed, and a removed or null field comes out as its zero value for ValidateMovie.
+type movieDocument struct {
+	ID      int64          `json:"id"`
+	Title   string         `json:"title"`
+	Year    int32          `json:"year"`
+	Runtime *store.Runtime `json:"runtime"`
+	Genres  []string       `json:"genres"`
+	Version int32          `json:"version"`
+}
+
+// patchMovie applies a merge patch or a JSON patch from the request body to movie, writing the
+// error response and returning false when the patch is malformed or cannot be applied
+func (app *application) patchMovie(w http.ResponseWriter, r *http.Request, mediaType string, movie *store.Movie) bool {
+	var patch json.RawMessage
+	if err := app.readJSON(w, r, &patch); err != nil {
+		app.badRequestResponse(w, r, err)
+		return false
+	}
+
+	doc, err := json.Marshal(movieDocument{
+		ID:      movie.ID,
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: &movie.Runtime,
+		Genres:  movie.Genres,
+		Version: movie.Version,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	switch mediaType {
+	case mergePatchMediaType:
+		doc, err = jsonpatch.MergePatch(doc, patch)
+	default:
+		var ops []jsonpatch.Operation
+		ops, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			doc, err = jsonpatch.Apply(doc, ops)
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, jsonpatch.ErrInvalidPatch):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.errorResponse(w, r, http.StatusConflict, "patch_test_failed", err.Error())
+		case errors.Is(err, jsonpatch.ErrPath):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, "patch_path_invalid", err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	var patched movieDocument
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patched); err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &unmarshalTypeError):
+			err = fmt.Errorf("the patched movie has an incorrect JSON type for field %q", unmarshalTypeError.Field)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			err = fmt.Errorf("the patched movie contains unknown key %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.Is(err, store.ErrInvalidRuntimeFormat):
+			err = fmt.Errorf("the patched movie has an invalid runtime format")
+		default:
+			err = fmt.Errorf("the patched movie must be a JSON object")
+		}
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "patch_result_invalid", err.Error())
+		return false
+	}
+
+	v := validator.New()
+	v.Check(patched.ID == movie.ID, "id", validator.CodeImmutable, "must not be changed")
+	v.Check(patched.Version == movie.Version, "version", validator.CodeImmutable, "must not be changed")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return false
+	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = 0
+	if patched.Runtime != nil {
+		movie.Runtime = *patched.Runtime
+	}
+	movie.Genres = patched.Genres
+	return true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/recommend"
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+// rebuilds the movie similarity table on an interval. Every replica runs the job, which is
+// harmless since each rebuild replaces the whole table in one transaction.
+func (app *application) refreshRecommendations() {
+	rebuild := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), store.SimilarityRebuildTimeDuration)
+		defer cancel()
+
+		start := time.Now()
+		n, err := recommend.Rebuild(ctx, app.store, app.config.recommend.perMovie)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"task": "similarity rebuild"})
+			return
+		}
+		app.logger.PrintInfo("similarity table rebuilt", map[string]string{
+			"similarities": strconv.Itoa(n),
+			"duration":     time.Since(start).String(),
+		})
+	}
+
+	rebuild()
+	for range time.Tick(app.config.recommend.refreshInterval) {
+		rebuild()
+	}
+}
+
+// readSimilarityFilters reads the pagination of a similarity listing, which is always best first
+func (app *application) readSimilarityFilters(r *http.Request, v *validator.Validator) store.Filters {
+	var filters store.Filters
+
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.SortSafeList = []string{"-score"}
+	filters.Sort = "-score"
+
+	store.ValidateFilters(v, filters)
+	return filters
+}
+
+func (app *application) similarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	filters := app.readSimilarityFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// the movie is read first so that a missing movie is told apart from one without similar movies
+	if _, err := app.store.Movies.Get(r.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	similar, metadata, err := app.store.Similarities.GetSimilar(r.Context(), id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"similar": similar, "metadata": metadata}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// recommendationsHandler picks movies for the user from the movies in their own collections.
+// A user without collections gets an empty list.
+func (app *application) recommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readSimilarityFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	recommendations, metadata, err := app.store.Similarities.GetRecommendations(r.Context(), user.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"recommendations": recommendations, "metadata": metadata}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
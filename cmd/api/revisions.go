@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+// stores a revision of the movie authored by the user making the request, in the transaction
+// that wrote the movie so that one is never kept without the other
+func (app *application) recordMovieRevision(r *http.Request, tx store.TxStorage, action string, before, after *store.Movie) error {
+	user := app.contextGetUser(r)
+
+	rev := store.NewMovieRevision(action, user.ID, before, after)
+	return tx.Revisions.Create(r.Context(), rev)
+}
+
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var filters store.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// revisions are always listed newest first
+	filters.SortSafeList = []string{"-version"}
+	filters.Sort = "-version"
+
+	if store.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	revisions, metadata, err := app.store.Revisions.GetAllForMovie(r.Context(), id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(revisions) == 0 && filters.Page == 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	rev, err := app.store.Revisions.Get(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"revision": rev}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restores the fields of an earlier revision as a new revision of the movie
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.store.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.preconditionsMet(w, r, movieETag(movie), int64(movie.Version)) {
+		return
+	}
+
+	rev, err := app.store.Revisions.Get(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	before := *movie
+
+	movie.Title = rev.Movie.Title
+	movie.Year = rev.Movie.Year
+	movie.Runtime = rev.Movie.Runtime
+
+	// a revision may predate the genre taxonomy or a change to it
+	v := validator.New()
+	movie.Genres, err = app.normalizeGenres(r.Context(), v, "genres", rev.Movie.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if store.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.store.Tx.InTx(r.Context(), func(tx store.TxStorage) error {
+		if err := tx.Movies.Update(r.Context(), movie); err != nil {
+			return err
+		}
+		return app.recordMovieRevision(r, tx, store.RevisionActionRevert, &before, movie)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", movieETag(movie))
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/julienschmidt/httprouter"
+)
+
+// memoryCatalog keeps movies and their revisions in memory. InTx works on a copy that only
+// replaces the catalog when fn succeeds, so a failed transaction leaves nothing behind.
+type memoryCatalog struct {
+	movies    map[int64]store.Movie
+	revisions []store.MovieRevision
+	lastID    int64
+}
+
+func (c *memoryCatalog) InTx(ctx context.Context, fn func(tx store.TxStorage) error) error {
+	tx := &memoryCatalog{movies: maps.Clone(c.movies), revisions: slices.Clone(c.revisions), lastID: c.lastID}
+	if err := fn(store.TxStorage{Movies: &catalogMovies{catalog: tx}, Revisions: &catalogRevisions{catalog: tx}}); err != nil {
+		return err
+	}
+	*c = *tx
+	return nil
+}
+
+// storage returns the stores of the catalog, with the genres of newGenreTestApp
+func (c *memoryCatalog) storage() store.Storage {
+	return store.Storage{
+		Movies:    &catalogMovies{catalog: c},
+		Revisions: &catalogRevisions{catalog: c},
+		Tx:        c,
+		Genres:    newGenreTestApp().store.Genres,
+	}
+}
+
+// catalogMovies checks versions the way MovieStore does, the rest of the store is not used by
+// these tests
+type catalogMovies struct {
+	*store.MovieStore
+	catalog *memoryCatalog
+}
+
+func (m *catalogMovies) Get(ctx context.Context, id int64) (*store.Movie, error) {
+	movie, ok := m.catalog.movies[id]
+	if !ok {
+		return nil, store.ErrorNotFound
+	}
+	movie.Genres = slices.Clone(movie.Genres)
+	return &movie, nil
+}
+
+func (m *catalogMovies) Create(ctx context.Context, movie *store.Movie) error {
+	m.catalog.lastID++
+	movie.ID, movie.Version = m.catalog.lastID, 1
+	m.catalog.movies[movie.ID] = *movie
+	return nil
+}
+
+func (m *catalogMovies) Update(ctx context.Context, movie *store.Movie) error {
+	if current, ok := m.catalog.movies[movie.ID]; !ok || current.Version != movie.Version {
+		return store.ErrEditConflict
+	}
+	movie.Version++
+	m.catalog.movies[movie.ID] = *movie
+	return nil
+}
+
+func (m *catalogMovies) Delete(ctx context.Context, movie *store.Movie) error {
+	if current, ok := m.catalog.movies[movie.ID]; !ok || current.Version != movie.Version {
+		return store.ErrEditConflict
+	}
+	delete(m.catalog.movies, movie.ID)
+	return nil
+}
+
+type catalogRevisions struct {
+	*store.RevisionStore
+	catalog *memoryCatalog
+}
+
+func (s *catalogRevisions) Create(ctx context.Context, rev *store.MovieRevision) error {
+	s.catalog.revisions = append(s.catalog.revisions, *rev)
+	return nil
+}
+
+func (s *catalogRevisions) Get(ctx context.Context, movieID int64, version int32) (*store.MovieRevision, error) {
+	for _, rev := range s.catalog.revisions {
+		if rev.MovieID == movieID && rev.Version == version {
+			return &rev, nil
+		}
+	}
+	return nil, store.ErrorNotFound
+}
+
+func TestRevertMovie(t *testing.T) {
+	newCatalog := func() *memoryCatalog {
+		return &memoryCatalog{
+			movies: map[int64]store.Movie{
+				1: {ID: 1, Title: "Moana 2", Year: 2024, Runtime: 100, Genres: []string{"animation"}, Version: 2},
+			},
+			revisions: []store.MovieRevision{
+				// the first version spelled its genre before the taxonomy did
+				{MovieID: 1, Version: 1, Action: store.RevisionActionCreate, Movie: store.Movie{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"Animation"}, Version: 1}},
+				{MovieID: 1, Version: 2, Action: store.RevisionActionUpdate, Movie: store.Movie{ID: 1, Title: "Moana 2", Year: 2024, Runtime: 100, Genres: []string{"animation"}, Version: 2}},
+				{MovieID: 1, Version: 3, Action: store.RevisionActionUpdate, Movie: store.Movie{ID: 1, Title: "Moana", Genres: []string{"western"}, Version: 3}},
+			},
+			lastID: 1,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		version int
+		ifMatch string
+		status  int
+		movie   store.Movie
+	}{
+		{"earlier version", 1, "", http.StatusOK, store.Movie{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 3}},
+		{"matching tag", 1, `"movie-1-v2"`, http.StatusOK, store.Movie{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 3}},
+		{"stale tag", 1, `"movie-1-v1"`, http.StatusPreconditionFailed, store.Movie{}},
+		{"unknown version", 9, "", http.StatusNotFound, store.Movie{}},
+		{"genre gone from the taxonomy", 3, "", http.StatusUnprocessableEntity, store.Movie{}},
+	}
+
+	for _, tt := range tests {
+		catalog := newCatalog()
+		app := &application{store: catalog.storage()}
+
+		r := httptest.NewRequest(http.MethodPost, "/v1/movies/1/revisions/"+strconv.Itoa(tt.version)+"/revert", nil)
+		r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{
+			{Key: "id", Value: "1"},
+			{Key: "version", Value: strconv.Itoa(tt.version)},
+		}))
+		r = app.contextSetUser(r, &store.User{ID: 3, Activated: true})
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+
+		w := httptest.NewRecorder()
+		app.revertMovieHandler(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+			continue
+		}
+
+		if tt.status != http.StatusOK {
+			if len(catalog.revisions) != 3 || catalog.movies[1].Version != 2 {
+				t.Errorf("%s: got the movie written", tt.name)
+			}
+			continue
+		}
+
+		var body struct {
+			Movie store.Movie `json:"movie"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(body.Movie, tt.movie) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, body.Movie, tt.movie)
+		}
+		if stored := catalog.movies[1]; !reflect.DeepEqual(stored, tt.movie) {
+			t.Errorf("%s: stored %+v, want %+v", tt.name, stored, tt.movie)
+		}
+
+		// the revert is a revision of its own, changing what the reverted one had different
+		rev := catalog.revisions[len(catalog.revisions)-1]
+		if rev.Action != store.RevisionActionRevert || rev.Version != 3 || rev.AuthorID == nil || *rev.AuthorID != 3 {
+			t.Errorf("%s: got revision %+v", tt.name, rev)
+		}
+		changed := slices.Sorted(maps.Keys(rev.Changes))
+		if want := []string{"runtime", "title", "year"}; !slices.Equal(changed, want) {
+			t.Errorf("%s: got changes to %v, want %v", tt.name, changed, want)
+		}
+	}
+}
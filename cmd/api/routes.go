@@ -2,34 +2,193 @@ package main
 
 import (
 	"expvar"
+	"maps"
 	"net/http"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// httprouter panics when a static segment is registered in the same position as a wildcard,
+// so sub-resources such as /v1/movies/export are registered through the wildcard route and
+// picked out here by the value of the parameter before falling back to the wildcard handler.
+func (app *application) staticParam(param string, routes map[string]http.HandlerFunc, fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := httprouter.ParamsFromContext(r.Context()).ByName(param)
+
+		if handler, ok := routes[value]; ok {
+			handler(w, r)
+			return
+		}
+		fallback(w, r)
+	}
+}
+
+const (
+	// accessPublic routes are open to anyone and accessActivated ones to any activated user, any
+	// other access is the permission code a route requires
+	accessPublic    = ""
+	accessActivated = "activated"
+)
+
+// apiRoute is a route as it was registered, which the OpenAPI document is built from
+type apiRoute struct {
+	method  string
+	path    string
+	access  string
+	handler string
+}
+
+// routeTable registers routes behind the checks their access calls for and keeps a record of them
+type routeTable struct {
+	app    *application
+	router *httprouter.Router
+	routes []apiRoute
+}
+
+func (t *routeTable) guard(access string, handler http.HandlerFunc) http.HandlerFunc {
+	switch access {
+	case accessPublic:
+		return handler
+	case accessActivated:
+		return t.app.requiredActivatedUser(handler)
+	default:
+		return t.app.requirePermission(access, handler)
+	}
+}
+
+// wrap puts the handler of a route behind the checks of its access and of the OpenAPI document
+func (t *routeTable) wrap(route apiRoute, handler http.HandlerFunc) http.HandlerFunc {
+	return t.guard(route.access, t.app.conformToSpec(route, handler))
+}
+
+func (t *routeTable) record(method, path, access string, handler http.HandlerFunc) apiRoute {
+	// e.g. main.(*application).listMoviesHandler-fm
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = strings.TrimSuffix(name[strings.LastIndex(name, ".")+1:], "-fm")
+
+	route := apiRoute{method: method, path: path, access: access, handler: name}
+	t.routes = append(t.routes, route)
+	return route
+}
+
+func (t *routeTable) handle(method, path, access string, handler http.HandlerFunc) {
+	route := t.record(method, path, access, handler)
+	t.router.HandlerFunc(method, path, t.wrap(route, handler))
+}
+
+// staticRoute is a route dispatched by staticParam, see handleStatic
+type staticRoute struct {
+	access  string
+	handler http.HandlerFunc
+}
+
+// handleStatic registers routes whose static segment sits where other routes of the method have
+// the wildcard param, such as /v1/movies/export next to /v1/movies/:id. Requests to path are
+// dispatched on the value of param, and the rest go to fallback, or get a 405 when it is nil.
+func (t *routeTable) handleStatic(method, path, param string, routes map[string]staticRoute, fallback *staticRoute) {
+	handlers := make(map[string]http.HandlerFunc, len(routes))
+	for _, value := range slices.Sorted(maps.Keys(routes)) {
+		static := routes[value]
+		route := t.record(method, strings.Replace(path, ":"+param, value, 1), static.access, static.handler)
+		handlers[value] = t.wrap(route, static.handler)
+	}
+
+	fallbackHandler := t.app.methodNotAllowedResponse
+	if fallback != nil {
+		route := t.record(method, path, fallback.access, fallback.handler)
+		fallbackHandler = t.wrap(route, fallback.handler)
+	}
+	t.router.HandlerFunc(method, path, t.app.staticParam(param, handlers, fallbackHandler))
+}
+
 func (app *application) routes() http.Handler {
+	t := app.routeTable()
+
+	return app.requestID(app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.idempotency(t.router)))))))
+}
+
+func (app *application) routeTable() *routeTable {
 	router := httprouter.New()
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	t := &routeTable{app: app, router: router}
+
+	t.handle(http.MethodGet, "/v1/healthcheck", accessPublic, app.healthcheckHandler)
+	t.handle(http.MethodGet, "/v1/openapi.json", accessPublic, app.openAPIHandler)
+	t.handle(http.MethodGet, "/v1/docs", accessPublic, app.docsHandler)
+
+	// GraphQL checks access field by field, the same way the routes below do
+	app.graphql = app.graphQLSchema()
+	t.handle(http.MethodPost, "/v1/graphql", accessPublic, app.graphqlHandler)
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	t.handle(http.MethodGet, "/v1/movies", "movies:read", app.listMoviesHandler)
+	t.handle(http.MethodPost, "/v1/movies", "movies:write", app.createMovieHandler)
+	t.handleStatic(http.MethodGet, "/v1/movies/:id", "id", map[string]staticRoute{
+		"export":  {"movies:export", app.exportMoviesHandler},
+		"suggest": {"movies:read", app.suggestMoviesHandler},
+		"lookup":  {"movies:read", app.lookupMovieHandler},
+	}, &staticRoute{"movies:read", app.showMovieHandler})
+	// there is no POST for a single movie, so anything but batch is answered like the router would
+	t.handleStatic(http.MethodPost, "/v1/movies/:id", "id", map[string]staticRoute{
+		"batch": {"movies:write", app.batchMoviesHandler},
+	}, nil)
+	t.handle(http.MethodPut, "/v1/movies/by-external/:source/:external_id", "movies:write", app.upsertMovieByExternalIDHandler)
+	t.handle(http.MethodPatch, "/v1/movies/:id", "movies:write", app.updateMovieHandler)
+	t.handle(http.MethodDelete, "/v1/movies/:id", "movies:write", app.deleteMovieHandler)
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updatePasswordHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/activation/email/send", app.sendActivationEmail)
+	t.handle(http.MethodGet, "/v1/movies/:id/localizations", "movies:read", app.showMovieLocalizationsHandler)
+	t.handle(http.MethodPatch, "/v1/movies/:id/localizations", "movies:write", app.updateMovieLocalizationsHandler)
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordHandler)
+	t.handle(http.MethodGet, "/v1/movies/:id/similar", "movies:read", app.similarMoviesHandler)
 
+	t.handle(http.MethodGet, "/v1/movies/:id/revisions", "movies:read", app.listMovieRevisionsHandler)
+	t.handle(http.MethodGet, "/v1/movies/:id/revisions/:version", "movies:read", app.showMovieRevisionHandler)
+	t.handle(http.MethodPost, "/v1/movies/:id/revisions/:version/revert", "movies:write", app.revertMovieHandler)
+
+	t.handle(http.MethodGet, "/v1/admin/movies/duplicates", "movies:admin", app.listDuplicateMoviesHandler)
+	t.handle(http.MethodPost, "/v1/admin/movies/:id/merge", "movies:admin", app.mergeMovieHandler)
+
+	t.handle(http.MethodGet, "/v1/collections", "movies:read", app.listCollectionsHandler)
+	t.handle(http.MethodPost, "/v1/collections", "movies:write", app.createCollectionHandler)
+	t.handle(http.MethodGet, "/v1/collections/:id", "movies:read", app.showCollectionHandler)
+	t.handle(http.MethodPatch, "/v1/collections/:id", "movies:write", app.updateCollectionHandler)
+	t.handle(http.MethodDelete, "/v1/collections/:id", "movies:write", app.deleteCollectionHandler)
+	t.handle(http.MethodPost, "/v1/collections/:id/movies", "movies:write", app.addCollectionMovieHandler)
+	t.handle(http.MethodPut, "/v1/collections/:id/movies", "movies:write", app.reorderCollectionMoviesHandler)
+	t.handle(http.MethodDelete, "/v1/collections/:id/movies/:movie_id", "movies:write", app.removeCollectionMovieHandler)
+
+	t.handle(http.MethodGet, "/v1/genres", "movies:read", app.listGenresHandler)
+	t.handle(http.MethodPost, "/v1/genres", "genres:write", app.createGenreHandler)
+	t.handle(http.MethodGet, "/v1/genres/:slug", "movies:read", app.showGenreHandler)
+	t.handle(http.MethodPatch, "/v1/genres/:slug", "genres:write", app.updateGenreHandler)
+	t.handle(http.MethodDelete, "/v1/genres/:slug", "genres:write", app.deleteGenreHandler)
+
+	t.handle(http.MethodGet, "/v1/stats/movies", "stats:read", app.movieStatsHandler)
+
+	t.handle(http.MethodPost, "/v1/imports/movies", "movies:write", app.createImportHandler)
+	t.handle(http.MethodGet, "/v1/imports/movies/:id", "movies:write", app.showImportHandler)
+
+	t.handle(http.MethodPost, "/v1/users", accessPublic, app.registerUserHandler)
+	t.handle(http.MethodGet, "/v1/users/me", accessActivated, app.showCurrentUserHandler)
+	t.handle(http.MethodGet, "/v1/users/me/recommendations", "movies:read", app.recommendationsHandler)
+	t.handle(http.MethodPut, "/v1/users/activated", accessPublic, app.activateUserHandler)
+	t.handle(http.MethodPut, "/v1/users/password", accessPublic, app.updatePasswordHandler)
+	t.handle(http.MethodPost, "/v1/activation/email/send", accessPublic, app.sendActivationEmail)
+
+	t.handle(http.MethodPost, "/v1/tokens/authentication", accessPublic, app.createAuthenticationTokenHandler)
+	t.handle(http.MethodPost, "/v1/tokens/password-reset", accessPublic, app.createPasswordHandler)
+
+	// operational endpoints are not part of the API and are left out of its document
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	app.openapi = openAPIDocument(t.routes)
+	app.spec = mustCompileSpec(app.openapi, t.routes)
+
+	return t
 }
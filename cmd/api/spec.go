@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/AmiyoKm/green_light/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// apiSpec checks requests and responses against the OpenAPI document. It works on the document
+// as encoding/json decodes it, and knows the parts of JSON Schema that openAPIDocument writes.
+type apiSpec struct {
+	root       map[string]any
+	operations map[string]*specOperation
+
+	mu       sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+// specOperation is an operation of the document, by method and route path
+type specOperation struct {
+	params    []specParam
+	body      map[string]any
+	responses map[string]any
+}
+
+type specParam struct {
+	name     string
+	in       string
+	required bool
+	schema   map[string]any
+}
+
+// mustCompileSpec prepares the document of the routes for checking requests. It panics when the
+// document does not have the shape openAPIDocument gives it.
+func mustCompileSpec(doc envelope, routes []apiRoute) *apiSpec {
+	generic, err := toGeneric(doc)
+	if err != nil {
+		panic(err)
+	}
+
+	spec := &apiSpec{
+		root:       generic.(map[string]any),
+		operations: make(map[string]*specOperation, len(routes)),
+		patterns:   make(map[string]*regexp.Regexp),
+	}
+	paths := spec.root["paths"].(map[string]any)
+
+	for _, route := range routes {
+		path, _ := openAPIPath(route.path)
+		op := paths[path].(map[string]any)[strings.ToLower(route.method)].(map[string]any)
+
+		compiled := &specOperation{responses: op["responses"].(map[string]any)}
+		params, _ := op["parameters"].([]any)
+		for _, p := range params {
+			p := p.(map[string]any)
+			required, _ := p["required"].(bool)
+			compiled.params = append(compiled.params, specParam{
+				name:     p["name"].(string),
+				in:       p["in"].(string),
+				required: required,
+				schema:   p["schema"].(map[string]any),
+			})
+		}
+		if body, ok := op["requestBody"].(map[string]any); ok {
+			compiled.body = body["content"].(map[string]any)
+		}
+
+		spec.operations[route.method+" "+route.path] = compiled
+	}
+	return spec
+}
+
+// resolve follows a $ref within the document
+func (s *apiSpec) resolve(ref string) map[string]any {
+	var node any = s.root
+	for _, name := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		node = node.(map[string]any)[name]
+	}
+	return node.(map[string]any)
+}
+
+func (s *apiSpec) pattern(expr string) *regexp.Regexp {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rx, ok := s.patterns[expr]
+	if !ok {
+		rx = regexp.MustCompile(expr)
+		s.patterns[expr] = rx
+	}
+	return rx
+}
+
+// fieldKey names a member of the value key names, e.g. movie.title, with the root being the body
+func fieldKey(key, member string) string {
+	if key == "" {
+		return member
+	}
+	return key + "." + member
+}
+
+// jsonType is the JSON Schema type of a generic value
+func jsonType(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// types lists the types a schema allows, none meaning any
+func types(schema map[string]any) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []any:
+		names := make([]string, len(t))
+		for i, name := range t {
+			names[i] = name.(string)
+		}
+		return names
+	default:
+		return nil
+	}
+}
+
+func typeAllowed(allowed []string, value any) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	t := jsonType(value)
+	return slices.Contains(allowed, t) || (t == "integer" && slices.Contains(allowed, "number"))
+}
+
+func number(value any) float64 {
+	f, _ := value.(json.Number).Float64()
+	return f
+}
+
+// validate checks a generic value against a schema, adding an error for the first thing wrong
+// with each field
+func (s *apiSpec) validate(v *validator.Validator, key string, schema map[string]any, value any) {
+	field := key
+	if field == "" {
+		field = "body"
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		s.validate(v, key, s.resolve(ref), value)
+		return
+	}
+
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		var allowed []string
+		for _, branch := range anyOf {
+			branch := branch.(map[string]any)
+			if ref, ok := branch["$ref"].(string); ok {
+				branch = s.resolve(ref)
+			}
+			if typeAllowed(types(branch), value) {
+				s.validate(v, key, branch, value)
+				return
+			}
+			allowed = append(allowed, types(branch)...)
+		}
+		v.AddError(field, validator.CodeInvalidType, "must be of type "+strings.Join(allowed, " or "))
+		return
+	}
+
+	if allowed := types(schema); !typeAllowed(allowed, value) {
+		v.AddError(field, validator.CodeInvalidType, "must be of type "+strings.Join(allowed, " or "))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, value) {
+		values := make([]string, len(enum))
+		for i, e := range enum {
+			values[i] = fmt.Sprint(e)
+		}
+		v.AddError(field, validator.CodeNotPermitted, "must be one of "+strings.Join(values, ", "))
+		return
+	}
+
+	switch value := value.(type) {
+	case string:
+		if n, ok := schema["minLength"].(json.Number); ok && int64(utf8.RuneCountInString(value)) < mustInt(n) {
+			v.AddError(field, validator.CodeInvalidLength, fmt.Sprintf("must be at least %s characters long", n))
+		}
+		if n, ok := schema["maxLength"].(json.Number); ok && int64(utf8.RuneCountInString(value)) > mustInt(n) {
+			v.AddError(field, validator.CodeInvalidLength, fmt.Sprintf("must not be more than %s characters long", n))
+		}
+		if expr, ok := schema["pattern"].(string); ok && !s.pattern(expr).MatchString(value) {
+			v.AddError(field, validator.CodeInvalidFormat, "must match the pattern "+expr)
+		}
+
+	case json.Number:
+		if schema["format"] == "int32" && (number(value) < math.MinInt32 || number(value) > math.MaxInt32) {
+			v.AddError(field, validator.CodeTooLarge, fmt.Sprintf("must be at most %d", math.MaxInt32))
+		}
+		if minimum, ok := schema["minimum"].(json.Number); ok && number(value) < number(minimum) {
+			v.AddError(field, validator.CodeTooSmall, fmt.Sprintf("must be at least %s", minimum))
+		}
+		if maximum, ok := schema["maximum"].(json.Number); ok && number(value) > number(maximum) {
+			v.AddError(field, validator.CodeTooLarge, fmt.Sprintf("must be at most %s", maximum))
+		}
+
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				s.validate(v, fmt.Sprintf("%s[%d]", field, i), items, item)
+			}
+		}
+
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := value[name.(string)]; !ok {
+				v.AddError(fieldKey(key, name.(string)), validator.CodeRequired, "must be provided")
+			}
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(value)) {
+			if property, ok := properties[name]; ok {
+				s.validate(v, fieldKey(key, name), property.(map[string]any), value[name])
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					v.AddError(fieldKey(key, name), validator.CodeNotAllowed, "must not be provided")
+				}
+			case map[string]any:
+				s.validate(v, fieldKey(key, name), additional, value[name])
+			}
+		}
+	}
+}
+
+func mustInt(n json.Number) int64 {
+	i, _ := n.Int64()
+	return i
+}
+
+// checkParams checks the path and query parameters of a request. Query parameters given an empty
+// value count as missing, as they do for readString and the other query helpers.
+func (s *apiSpec) checkParams(v *validator.Validator, r *http.Request, op *specOperation) {
+	pathParams := httprouter.ParamsFromContext(r.Context())
+	qs := r.URL.Query()
+
+	for _, p := range op.params {
+		raw := qs.Get(p.name)
+		if p.in == "path" {
+			raw = pathParams.ByName(p.name)
+		}
+		if raw == "" {
+			if p.required {
+				v.AddError(p.name, validator.CodeRequired, "must be provided")
+			}
+			continue
+		}
+
+		var value any = raw
+		switch types(p.schema)[0] {
+		case "integer":
+			if _, err := strconv.ParseInt(raw, 10, 64); err != nil {
+				v.AddError(p.name, validator.CodeInvalidType, "must be an integer value")
+				continue
+			}
+			value = json.Number(raw)
+		case "number":
+			if _, err := strconv.ParseFloat(raw, 64); err != nil {
+				v.AddError(p.name, validator.CodeInvalidType, "must be a number")
+				continue
+			}
+			value = json.Number(raw)
+		case "boolean":
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				v.AddError(p.name, validator.CodeInvalidType, "must be a boolean value")
+				continue
+			}
+			value = b
+		}
+		s.validate(v, p.name, p.schema, value)
+	}
+}
+
+// requestBody reads the body of a request for checking, putting back what it read. It returns
+// false when the body is not one the document describes as JSON, or is empty, too large or
+// malformed, all of which are left for readJSON to report.
+func (s *apiSpec) requestBody(r *http.Request, op *specOperation) (any, map[string]any, bool) {
+	if op.body == nil {
+		return nil, nil, false
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	format := requestFormat(contentType)
+
+	var schema any
+	switch {
+	case format != nil:
+		schema = op.body["application/json"]
+	case mediaType == "":
+		schema = op.body["application/json"]
+	case strings.HasSuffix(mediaType, "json"):
+		schema = op.body[mediaType]
+	}
+	if schema == nil {
+		return nil, nil, false
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxJSONBodyBytes+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil || len(data) == 0 || len(data) > maxJSONBodyBytes {
+		return nil, nil, false
+	}
+
+	var value any
+	if format != nil {
+		if value, err = format.unmarshal(data); err != nil {
+			return nil, nil, false
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&value); err != nil || dec.Decode(&struct{}{}) != io.EOF {
+			return nil, nil, false
+		}
+	}
+	return value, schema.(map[string]any)["schema"].(map[string]any), true
+}
+
+// checkResponses reports whether responses are checked against the document as well as requests,
+// which costs a copy of every JSON response and is only done in development and tests
+func (app *application) checkResponses() bool {
+	return app.config.env == "development" || testing.Testing()
+}
+
+// conformToSpec rejects requests that do not match the route's operation in the OpenAPI document
+// with an error for each field, before the handler runs. The handler still validates what it
+// reads, such as with store.ValidateMovie, since the document cannot tell everything. Responses
+// that do not match the document are logged where checkResponses is true.
+func (app *application) conformToSpec(route apiRoute, next http.HandlerFunc) http.HandlerFunc {
+	key := route.method + " " + route.path
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the spec is compiled once the routes are all registered
+		op := app.spec.operations[key]
+
+		v := validator.New()
+		app.spec.checkParams(v, r, op)
+		if body, schema, ok := app.spec.requestBody(r, op); ok {
+			app.spec.validate(v, "", schema, body)
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+
+		if !app.checkResponses() {
+			next(w, r)
+			return
+		}
+
+		rw := &specResponseWriter{wrapper: w}
+		next(rw, r)
+
+		if err := app.spec.checkResponse(op, rw, app.config.errors.legacy); err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"request_method": r.Method,
+				"request_url":    r.URL.String(),
+				"request_id":     app.contextGetRequestID(r),
+			})
+		}
+	}
+}
+
+// specResponseWriter passes a response through while keeping a copy of it when it is JSON
+type specResponseWriter struct {
+	wrapper       http.ResponseWriter
+	statusCode    int
+	headerWritten bool
+	mediaType     string
+	body          bytes.Buffer
+}
+
+func (rw *specResponseWriter) Header() http.Header {
+	return rw.wrapper.Header()
+}
+
+func (rw *specResponseWriter) WriteHeader(statusCode int) {
+	if !rw.headerWritten {
+		rw.statusCode = statusCode
+		rw.headerWritten = true
+		rw.mediaType, _, _ = mime.ParseMediaType(rw.Header().Get("Content-Type"))
+	}
+
+	rw.wrapper.WriteHeader(statusCode)
+}
+
+func (rw *specResponseWriter) Write(b []byte) (int, error) {
+	if !rw.headerWritten {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.mediaType == "application/json" || rw.mediaType == problemMediaType {
+		rw.body.Write(b)
+	}
+
+	return rw.wrapper.Write(b)
+}
+
+func (rw *specResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.wrapper
+}
+
+var errResponseDrift = errors.New("response does not match the OpenAPI document")
+
+// checkResponse checks a JSON response against the operation's response for its status, or the
+// problem every operation may respond with. Legacy error responses are not problems and are not
+// checked.
+func (s *apiSpec) checkResponse(op *specOperation, rw *specResponseWriter, legacyErrors bool) error {
+	if rw.body.Len() == 0 || (legacyErrors && rw.statusCode >= http.StatusBadRequest) {
+		return nil
+	}
+
+	response, ok := op.responses[strconv.Itoa(rw.statusCode)].(map[string]any)
+	if !ok || rw.mediaType == problemMediaType {
+		response = op.responses["default"].(map[string]any)
+	}
+	if ref, ok := response["$ref"].(string); ok {
+		response = s.resolve(ref)
+	}
+
+	contents, _ := response["content"].(map[string]any)
+	content, ok := contents[rw.mediaType].(map[string]any)
+	if !ok {
+		return fmt.Errorf("%w: no %s response is documented for status %d", errResponseDrift, rw.mediaType, rw.statusCode)
+	}
+
+	dec := json.NewDecoder(&rw.body)
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return fmt.Errorf("%w: %v", errResponseDrift, err)
+	}
+
+	v := validator.New()
+	s.validate(v, "", content["schema"].(map[string]any), value)
+	if !v.Valid() {
+		fields := fieldErrors(v)
+		details := make([]string, len(fields))
+		for i, f := range fields {
+			details[i] = f.Field + " " + f.Detail
+		}
+		return fmt.Errorf("%w: status %d: %s", errResponseDrift, rw.statusCode, strings.Join(details, "; "))
+	}
+	return nil
+}
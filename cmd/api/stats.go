@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+// the number of distinct genre and week combinations kept in the stats cache
+const statsCacheSize = 256
+
+// statsCache keeps computed catalogue statistics for a configurable time, since every
+// request would otherwise aggregate over the whole movies table
+type statsCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]statsCacheEntry
+}
+
+type statsCacheEntry struct {
+	stats   *store.MovieStats
+	expires time.Time
+}
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{
+		ttl:     ttl,
+		entries: make(map[string]statsCacheEntry),
+	}
+}
+
+// get returns the cached statistics for key, computing and storing them with compute when they
+// are missing or expired. Concurrent misses on the same key may each compute the statistics.
+func (c *statsCache) get(key string, compute func() (*store.MovieStats, error)) (*store.MovieStats, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if ok && now.Before(entry.expires) {
+		return entry.stats, nil
+	}
+
+	stats, err := compute()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= statsCacheSize {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= statsCacheSize {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = statsCacheEntry{stats: stats, expires: now.Add(c.ttl)}
+
+	return stats, nil
+}
+
+func (app *application) movieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Genres []string
+		Weeks  int
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Weeks = app.readInt(qs, "weeks", 12, v)
+
+	v.Check(len(input.Genres) <= 20, "genres", validator.CodeTooMany, "must not contain more than 20 genres")
+	v.Check(validator.Unique(input.Genres), "genres", validator.CodeDuplicate, "must not contain duplicate values")
+	v.Check(input.Weeks > 0, "weeks", validator.CodeTooSmall, "must be greater than zero")
+	v.Check(input.Weeks <= 520, "weeks", validator.CodeTooLarge, "must be a maximum of 520")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	genres, err := app.resolveGenreFilter(r.Context(), input.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	input.Genres = genres
+
+	// the same genres in any order share a cache entry
+	slices.Sort(input.Genres)
+	key := strings.Join(input.Genres, ",") + "|" + strconv.Itoa(input.Weeks)
+
+	stats, err := app.statsCache.get(key, func() (*store.MovieStats, error) {
+		// the result is shared with other requests, so a client going away must not abort it
+		return app.store.Stats.Movies(context.WithoutCancel(r.Context()), input.Genres, input.Weeks)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "private, max-age="+strconv.Itoa(int(app.config.stats.cacheTTL.Seconds())))
+	if err := app.writeJSON(w, r, http.StatusOK, envelope{"stats": stats}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
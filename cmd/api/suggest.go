@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/suggest"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+// reloads the suggestion index from the database on an interval, so that it picks up
+// writes made by the other replicas and by imports run from the command line
+func (app *application) refreshSuggestIndex() {
+	load := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		if err := app.suggest.Load(ctx, app.store); err != nil {
+			app.logger.PrintError(err, map[string]string{"task": "suggest index refresh"})
+		}
+	}
+
+	load()
+	for range time.Tick(app.config.suggest.refreshInterval) {
+		load()
+	}
+}
+
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query string
+		Limit int
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	input.Query = app.readString(qs, "q", "")
+	input.Limit = app.readInt(qs, "limit", 10, v)
+
+	v.Check(input.Query != "", "q", validator.CodeRequired, "must be provided")
+	v.Check(len(input.Query) <= 100, "q", validator.CodeInvalidLength, "must not be more than 100 bytes long")
+	v.Check(input.Limit > 0, "limit", validator.CodeTooSmall, "must be greater than zero")
+	v.Check(input.Limit <= 25, "limit", validator.CodeTooLarge, "must be a maximum of 25")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	titles := []suggest.Title{}
+	if app.suggest.Ready() {
+		titles = app.suggest.Titles(input.Query, input.Limit)
+	}
+
+	// a misspelled or not yet indexed title falls back to a trigram query, which is given
+	// a short deadline so that the endpoint stays fast while the user types
+	if len(titles) < input.Limit {
+		ctx, cancel := context.WithTimeout(r.Context(), app.config.suggest.fallbackTimeout)
+		defer cancel()
+
+		movies, err := app.store.Movies.SuggestTitles(ctx, input.Query, input.Limit)
+		switch {
+		case err == nil:
+			seen := make(map[int64]bool, len(titles))
+			for _, t := range titles {
+				seen[t.ID] = true
+			}
+			for _, movie := range movies {
+				if len(titles) == input.Limit {
+					break
+				}
+				if !seen[movie.ID] {
+					titles = append(titles, suggest.Title{ID: movie.ID, Title: movie.Title, Year: movie.Year})
+				}
+			}
+		case ctx.Err() != nil && r.Context().Err() == nil:
+			// the fallback ran out of time, the index results are still worth returning
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{
+		"suggestions": envelope{
+			"titles": titles,
+			"genres": app.suggest.Genres(input.Query, input.Limit),
+		},
+	}
+	if err := app.writeJSON(w, r, http.StatusOK, env, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -11,10 +11,12 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+type activationEmailPayload struct {
+	Email string `json:"email"`
+}
+
 func (app *application) sendActivationEmail(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Email string `json:"email"`
-	}
+	var payload activationEmailPayload
 
 	if err := app.readJSON(w, r, &payload); err != nil {
 		app.badRequestResponse(w, r, err)
@@ -22,9 +24,9 @@ func (app *application) sendActivationEmail(w http.ResponseWriter, r *http.Reque
 	}
 
 	v := validator.New()
-	v.Check(validator.Matches(payload.Email, validator.EmailRX), "email", "must be valid email address")
+	v.Check(validator.Matches(payload.Email, validator.EmailRX), "email", validator.CodeInvalidFormat, "must be valid email address")
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -40,8 +42,8 @@ func (app *application) sendActivationEmail(w http.ResponseWriter, r *http.Reque
 		}
 	}
 	if user.Activated {
-		v.AddError("email", "user has already been activated")
-		app.failedValidationResponse(w, r, v.Errors)
+		v.AddError("email", validator.CodeConflict, "user has already been activated")
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -63,17 +65,19 @@ func (app *application) sendActivationEmail(w http.ResponseWriter, r *http.Reque
 		}
 	})
 
-	if err := app.writeJSON(w, http.StatusAccepted, envelope{"message": "email sent"}, nil); err != nil {
+	if err := app.writeJSON(w, r, http.StatusAccepted, envelope{"message": "email sent"}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 }
 
+type authenticationTokenPayload struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var payload authenticationTokenPayload
 
 	err := app.readJSON(w, r, &payload)
 	if err != nil {
@@ -86,7 +90,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	store.ValidatePasswordPlaintext(v, payload.Password)
 
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -133,17 +137,19 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"authentication_token": token}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 }
 
+type passwordResetTokenPayload struct {
+	Email string `json:"email"`
+}
+
 func (app *application) createPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Email string `json:"email"`
-	}
+	var payload passwordResetTokenPayload
 
 	if err := app.readJSON(w, r, &payload); err != nil {
 		app.badRequestResponse(w, r, err)
@@ -154,7 +160,7 @@ func (app *application) createPasswordHandler(w http.ResponseWriter, r *http.Req
 
 	store.ValidateEmail(v, payload.Email)
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -162,8 +168,8 @@ func (app *application) createPasswordHandler(w http.ResponseWriter, r *http.Req
 	if err != nil {
 		switch err {
 		case store.ErrorNotFound:
-			v.AddError("email", "no matching email address found")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddError("email", validator.CodeNotFound, "no matching email address found")
+			app.failedValidationResponse(w, r, v)
 			return
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -172,8 +178,8 @@ func (app *application) createPasswordHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	if !user.Activated {
-		v.AddError("email", "user account must be activated")
-		app.failedValidationResponse(w, r, v.Errors)
+		v.AddError("email", validator.CodeInvalid, "user account must be activated")
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -195,16 +201,18 @@ func (app *application) createPasswordHandler(w http.ResponseWriter, r *http.Req
 	})
 
 	env := envelope{"message": "an email will be sent to you containing password reset instruction"}
-	if err := app.writeJSON(w, http.StatusAccepted, env, nil); err != nil {
+	if err := app.writeJSON(w, r, http.StatusAccepted, env, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+type updatePasswordPayload struct {
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
 func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Password string `json:"password"`
-		Token    string `json:"token"`
-	}
+	var payload updatePasswordPayload
 
 	err := app.readJSON(w, r, &payload)
 	if err != nil {
@@ -217,7 +225,7 @@ func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Req
 	store.ValidateTokenPlaintext(v, payload.Token)
 
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -225,8 +233,8 @@ func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Req
 	if err != nil {
 		switch err {
 		case store.ErrorNotFound:
-			v.AddError("token", "invalid or expired password reset token")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddError("token", validator.CodeInvalidToken, "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v)
 			return
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -234,6 +242,10 @@ func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Req
 		}
 	}
 
+	if !app.preconditionsMet(w, r, userETag(user), int64(user.Version)) {
+		return
+	}
+
 	err = user.Password.Set(payload.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -258,7 +270,7 @@ func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 	env := envelope{"message": "your password was successfully reset"}
-	if err := app.writeJSON(w, http.StatusOK, env, nil); err != nil {
+	if err := app.writeJSON(w, r, http.StatusOK, env, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
@@ -9,12 +9,14 @@ import (
 	"github.com/AmiyoKm/green_light/internal/validator"
 )
 
+type registerUserPayload struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var payload registerUserPayload
 
 	err := app.readJSON(w, r, &payload)
 	if err != nil {
@@ -36,7 +38,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	v := validator.New()
 
 	if store.ValidateUser(v, user); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -44,8 +46,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		switch {
 		case errors.Is(err, store.ErrDuplicateEmail):
-			v.AddError("email", "a user with this email address already exists")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddError("email", validator.CodeConflict, "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v)
 			return
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -76,16 +78,20 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 	})
 
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	// the tag is what activating the account is conditional on
+	w.Header().Set("ETag", userETag(user))
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+type activateUserPayload struct {
+	TokenPlainText string `json:"token"`
+}
+
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		TokenPlainText string `json:"token"`
-	}
+	var payload activateUserPayload
 	err := app.readJSON(w, r, &payload)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
@@ -94,7 +100,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 	if store.ValidateTokenPlaintext(v, payload.TokenPlainText); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -102,8 +108,8 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		switch {
 		case errors.Is(err, store.ErrorNotFound):
-			v.AddError("token", "invalid or expired activation token")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddError("token", validator.CodeInvalidToken, "invalid or expired activation token")
+			app.failedValidationResponse(w, r, v)
 			return
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -111,6 +117,10 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	if !app.preconditionsMet(w, r, userETag(user), int64(user.Version)) {
+		return
+	}
+
 	user.Activated = true
 
 	err = app.store.Users.Update(r.Context(), user)
@@ -131,9 +141,20 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	w.Header().Set("ETag", userETag(user))
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 }
+
+// showCurrentUserHandler returns the authenticated user, with the ETag that changing the
+// password is conditional on
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	if err := app.writeRepresentation(w, r, userETag(user), envelope{"user": user}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
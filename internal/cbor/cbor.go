@@ -0,0 +1,354 @@
+// Package cbor converts between CBOR (RFC 8949) and the generic values that encoding/json decodes
+// into with UseNumber: nil, bool, json.Number, string, []any and map[string]any. Going through
+// those values keeps the field names and formats of the JSON representation.
+package cbor
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// maxDepth bounds the nesting of decoded arrays, maps and tags
+const maxDepth = 64
+
+const (
+	majorUnsigned = 0
+	majorNegative = 1
+	majorBytes    = 2
+	majorText     = 3
+	majorArray    = 4
+	majorMap      = 5
+	majorTag      = 6
+	majorSimple   = 7
+
+	// additional information marking an indefinite length, and the byte ending such an item
+	infoIndefinite = 31
+	breakByte      = 0xff
+)
+
+// SyntaxError reports malformed CBOR and the byte offset it was found at
+type SyntaxError struct {
+	msg    string
+	Offset int64
+}
+
+func (e *SyntaxError) Error() string {
+	return e.msg
+}
+
+// Marshal encodes a generic JSON value as CBOR, using definite lengths and sorted map keys
+func Marshal(v any) ([]byte, error) {
+	return appendValue(nil, v)
+}
+
+func appendHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major<<5|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major<<5|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major<<5|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major<<5|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major<<5|27), n)
+	}
+}
+
+func appendValue(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if v {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if i < 0 {
+				return appendHead(b, majorNegative, uint64(-1-i)), nil
+			}
+			return appendHead(b, majorUnsigned, uint64(i)), nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return appendHead(b, majorUnsigned, u), nil
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("cbor: invalid number %q", v)
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(f)), nil
+	case string:
+		return append(appendHead(b, majorText, uint64(len(v))), v...), nil
+	case []any:
+		b = appendHead(b, majorArray, uint64(len(v)))
+		for _, item := range v {
+			var err error
+			if b, err = appendValue(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		b = appendHead(b, majorMap, uint64(len(v)))
+		for _, key := range keys {
+			b = append(appendHead(b, majorText, uint64(len(key))), key...)
+			var err error
+			if b, err = appendValue(b, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("cbor: unsupported type %T", v)
+	}
+}
+
+// Unmarshal decodes a single CBOR data item into a generic JSON value. Byte strings become
+// strings, tags are dropped in favour of the item they wrap, undefined becomes null, and map
+// keys must be text strings since JSON allows no others.
+func Unmarshal(data []byte) (any, error) {
+	d := &decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(d.data) {
+		return nil, d.errorf("unexpected data after the top-level data item")
+	}
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+	off  int
+}
+
+func (d *decoder) errorf(format string, args ...any) error {
+	return &SyntaxError{msg: "cbor: " + fmt.Sprintf(format, args...), Offset: int64(d.off)}
+}
+
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if uint64(len(d.data)-d.off) < n {
+		return nil, d.errorf("unexpected end of data")
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+// head reads the initial byte of a data item and the argument that follows it
+func (d *decoder) head() (major byte, info byte, arg uint64, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		b, err := d.next(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for _, c := range b {
+			arg = arg<<8 | uint64(c)
+		}
+		return major, info, arg, nil
+	case info == infoIndefinite && major >= majorBytes && major <= majorMap:
+		return major, info, 0, nil
+	default:
+		d.off--
+		return 0, 0, 0, d.errorf("invalid initial byte 0x%02x", b[0])
+	}
+}
+
+func (d *decoder) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, d.errorf("exceeded max depth of %d", maxDepth)
+	}
+	start := d.off
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case majorUnsigned:
+		return json.Number(strconv.FormatUint(arg, 10)), nil
+	case majorNegative:
+		if arg > math.MaxInt64 {
+			d.off = start
+			return nil, d.errorf("negative integer out of range")
+		}
+		return json.Number(strconv.FormatInt(-1-int64(arg), 10)), nil
+	case majorBytes, majorText:
+		if info == infoIndefinite {
+			return d.chunks(major)
+		}
+		b, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case majorArray:
+		return d.array(info == infoIndefinite, arg, depth)
+	case majorMap:
+		return d.object(info == infoIndefinite, arg, depth)
+	case majorTag:
+		return d.value(depth + 1)
+	}
+
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return d.float(halfToFloat(uint16(arg)), start)
+	case 26:
+		return d.float(float64(math.Float32frombits(uint32(arg))), start)
+	case 27:
+		return d.float(math.Float64frombits(arg), start)
+	default:
+		d.off = start
+		return nil, d.errorf("unsupported simple value %d", arg)
+	}
+}
+
+func (d *decoder) float(f float64, start int) (any, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		d.off = start
+		return nil, d.errorf("%v cannot be represented in JSON", f)
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// halfToFloat widens an IEEE 754 half-precision float
+func halfToFloat(h uint16) float64 {
+	exp, mant := int(h>>10&0x1f), float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+// chunks reads an indefinite-length string, made of definite-length strings of the same type
+func (d *decoder) chunks(major byte) (any, error) {
+	var s []byte
+	for {
+		if d.off < len(d.data) && d.data[d.off] == breakByte {
+			d.off++
+			return string(s), nil
+		}
+		start := d.off
+		m, info, arg, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if m != major || info == infoIndefinite {
+			d.off = start
+			return nil, d.errorf("invalid chunk in indefinite-length string")
+		}
+		b, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, b...)
+	}
+}
+
+// more reports whether another entry of an array or map follows, consuming the break that ends an
+// indefinite-length one
+func (d *decoder) more(indefinite bool, n, i uint64) bool {
+	if !indefinite {
+		return i < n
+	}
+	if d.off < len(d.data) && d.data[d.off] == breakByte {
+		d.off++
+		return false
+	}
+	return true
+}
+
+func (d *decoder) array(indefinite bool, n uint64, depth int) (any, error) {
+	// every item takes at least one byte, which bounds what a forged length can allocate
+	if n > uint64(len(d.data)-d.off) {
+		return nil, d.errorf("unexpected end of data")
+	}
+	items := make([]any, 0, n)
+	for i := uint64(0); d.more(indefinite, n, i); i++ {
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (d *decoder) object(indefinite bool, n uint64, depth int) (any, error) {
+	if n > uint64(len(d.data)-d.off)/2 {
+		return nil, d.errorf("unexpected end of data")
+	}
+	members := make(map[string]any, n)
+	for i := uint64(0); d.more(indefinite, n, i); i++ {
+		start := d.off
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok || !d.text(start) {
+			d.off = start
+			return nil, d.errorf("map keys must be text strings")
+		}
+		value, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		members[name] = value
+	}
+	return members, nil
+}
+
+// text reports whether the data item at off, once past any tags, is a text string and not a byte
+// string, both of which decode to strings
+func (d *decoder) text(off int) bool {
+	k := &decoder{data: d.data, off: off}
+	for {
+		major, _, _, _ := k.head()
+		if major != majorTag {
+			return major == majorText
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	members := map[string]any{}
+	for _, key := range strings.Split("abcdefghijklmnopqrstuvwxyz", "") {
+		members[key] = json.Number("1")
+	}
+
+	tests := []any{
+		nil,
+		true,
+		false,
+		json.Number("0"),
+		json.Number("23"),
+		json.Number("24"),
+		json.Number("255"),
+		json.Number("256"),
+		json.Number("65536"),
+		json.Number("4294967296"),
+		json.Number("18446744073709551615"),
+		json.Number("-1"),
+		json.Number("-25"),
+		json.Number("-9223372036854775808"),
+		json.Number("1.5"),
+		json.Number("-0.25"),
+		json.Number("1e+100"),
+		"",
+		"café",
+		strings.Repeat("a", 300),
+		strings.Repeat("b", 70000),
+		[]any{},
+		[]any{json.Number("1"), "two", []any{nil, false}},
+		map[string]any{},
+		map[string]any{"title": "Moana", "genres": []any{"animation"}, "year": json.Number("2016"), "runtime": map[string]any{"mins": json.Number("107")}},
+		members,
+	}
+
+	for _, v := range tests {
+		data, err := Marshal(v)
+		if err != nil {
+			t.Errorf("%v: %v", v, err)
+			continue
+		}
+		got, err := Unmarshal(data)
+		if err != nil {
+			t.Errorf("%v: %v", v, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("got %#v, want %#v", got, v)
+		}
+	}
+}
+
+func TestMarshalSortsKeys(t *testing.T) {
+	data, err := Marshal(map[string]any{"b": json.Number("2"), "a": json.Number("1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := decodeHex(t, "a2 6161 01 6162 02"); !bytes.Equal(data, want) {
+		t.Errorf("got %x, want %x", data, want)
+	}
+}
+
+func TestMarshalRejectsUnsupportedValues(t *testing.T) {
+	for _, v := range []any{json.Number("one"), 1, map[string]string{}} {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("%#v: got no error", v)
+		}
+	}
+}
+
+// the examples of RFC 8949 appendix A that have a JSON value
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"1bffffffffffffffff", `18446744073709551615`},
+		{"3b7fffffffffffffff", `-9223372036854775808`},
+		{"f93c00", `1`},
+		{"f90001", `5.960464477539063e-08`},
+		{"fbc010666666666666", `-4.1`},
+		{"f7", `null`},
+		{"c11a514b67b0", `1363896240`},
+		{"d74401020304", `"\u0001\u0002\u0003\u0004"`},
+		{"62225c", `"\"\\"`},
+		{"5f42010243030405ff", `"\u0001\u0002\u0003\u0004\u0005"`},
+		{"7f657374726561646d696e67ff", `"streaming"`},
+		{"9fff", `[]`},
+		{"9f018202039f0405ffff", `[1,[2,3],[4,5]]`},
+		{"83018202039f0405ff", `[1,[2,3],[4,5]]`},
+		{"bf61610161629f0203ffff", `{"a":1,"b":[2,3]}`},
+		{"a26161016162820203", `{"a":1,"b":[2,3]}`},
+	}
+
+	for _, tt := range tests {
+		v, err := Unmarshal(decodeHex(t, tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.data, err)
+			continue
+		}
+		got, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		msg    string
+		offset int64
+	}{
+		{"empty", "", "unexpected end of data", 0},
+		{"truncated argument", "19 01", "unexpected end of data", 1},
+		{"truncated string", "63 6162", "unexpected end of data", 1},
+		{"truncated array", "83 01 02", "unexpected end of data", 1},
+		{"unterminated indefinite array", "9f 01", "unexpected end of data", 2},
+		{"oversized string", "7b ffffffffffffffff 61", "unexpected end of data", 9},
+		{"oversized array", "9b 00000000ffffffff 01", "unexpected end of data", 9},
+		{"oversized map", "bb 00000000ffffffff 01", "unexpected end of data", 9},
+		{"integer map key", "a1 01 02", "map keys must be text strings", 1},
+		{"byte string map key", "a1 4161 01", "map keys must be text strings", 1},
+		{"tagged byte string map key", "a1 c0 4161 01", "map keys must be text strings", 1},
+		{"indefinite byte string map key", "a1 5f 4161 ff 01", "map keys must be text strings", 1},
+		{"array map key", "a1 80 01", "map keys must be text strings", 1},
+		{"invalid initial byte", "1c", "invalid initial byte 0x1c", 0},
+		{"stray break", "ff", "invalid initial byte 0xff", 0},
+		{"break in a definite array", "81 ff", "invalid initial byte 0xff", 1},
+		{"mixed chunks", "7f 4161 ff", "invalid chunk in indefinite-length string", 1},
+		{"nested indefinite chunk", "5f 5f ff ff", "invalid chunk in indefinite-length string", 1},
+		{"negative integer out of range", "3b ffffffffffffffff", "negative integer out of range", 0},
+		{"NaN", "f9 7e00", "NaN cannot be represented in JSON", 0},
+		{"infinity", "fa 7f800000", "+Inf cannot be represented in JSON", 0},
+		{"simple value", "f0", "unsupported simple value 16", 0},
+		{"trailing data", "01 02", "unexpected data after the top-level data item", 1},
+		{"too deep", strings.Repeat("81", 65) + "80", "exceeded max depth of 64", 65},
+		{"too deep in tags", strings.Repeat("c0", 65) + "01", "exceeded max depth of 64", 65},
+	}
+
+	for _, tt := range tests {
+		_, err := Unmarshal(decodeHex(t, tt.data))
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: got %v, want a syntax error", tt.name, err)
+			continue
+		}
+		if syntaxErr.Error() != "cbor: "+tt.msg || syntaxErr.Offset != tt.offset {
+			t.Errorf("%s: got %q at %d, want %q at %d", tt.name, syntaxErr, syntaxErr.Offset, tt.msg, tt.offset)
+		}
+	}
+}
+
+func TestUnmarshalMaxDepth(t *testing.T) {
+	data := append(bytes.Repeat([]byte{0x81}, maxDepth), 0x80)
+	if _, err := Unmarshal(data); err != nil {
+		t.Errorf("got %v for %d levels of nesting", err, maxDepth)
+	}
+
+	// a deep document stops at the limit rather than running out of stack
+	if _, err := Unmarshal(bytes.Repeat([]byte{0x81}, 1<<20)); err == nil {
+		t.Error("got no error for a million levels of nesting")
+	}
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	data, err := Marshal(map[string]any{"title": "Moana", "genres": []any{"animation", "adventure"}, "year": json.Number("2016"), "rating": json.Number("7.6")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := range len(data) {
+		if _, err := Unmarshal(data[:n]); err == nil {
+			t.Errorf("got no error for the first %d of %d bytes", n, len(data))
+		}
+	}
+}
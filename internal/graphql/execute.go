@@ -0,0 +1,503 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Request is a query as clients send it
+type Request struct {
+	Query         string
+	OperationName string
+	Variables     map[string]any
+}
+
+// Options limit the queries Execute runs. A limit of 0 is no limit.
+type Options struct {
+	// MaxDepth is the deepest fields may be nested, counting the fields of Query as depth 1
+	MaxDepth int
+	// MaxComplexity is the most a query may cost, adding up the complexity of its fields
+	MaxComplexity int
+	// FormatError turns an error returned by a resolver into the error reported for the field.
+	// Errors are reported with their message when it is nil.
+	FormatError func(ctx context.Context, err error) *Error
+}
+
+// Error is an error reported in a response
+type Error struct {
+	Message    string         `json:"message"`
+	Locations  []Location     `json:"locations,omitempty"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Response is the result of a query. Data is nil when the query could not be run, in which case
+// Errors says why, and otherwise a *Map, which is itself nil when a non-null field of Query is.
+type Response struct {
+	Data   any      `json:"data,omitempty"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// Map is an object in a response, which keeps its members in the order they were selected
+type Map struct {
+	keys   []string
+	values map[string]any
+}
+
+func newMap() *Map {
+	return &Map{values: make(map[string]any)}
+}
+
+func (m *Map) set(key string, value any) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Get returns the member with the key
+func (m *Map) Get(key string) any {
+	return m.values[key]
+}
+
+func (m *Map) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Execute parses, validates and runs the query. Errors in the query are reported without running
+// any resolver. Errors returned by resolvers make their field null, along with the nearest
+// nullable field above a non-null one, and are reported with the field's path.
+func Execute(ctx context.Context, schema *Schema, req Request, opts Options) *Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return &Response{Errors: []*Error{{Message: syntaxErr.Error(), Locations: []Location{syntaxErr.Loc}}}}
+		}
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	e := &executor{schema: schema, doc: doc, opts: opts}
+	if !e.prepare(req) {
+		return &Response{Errors: e.errors}
+	}
+
+	data := e.executeSelections(ctx, schema.Query, e.operation.SelectionSet, []any{nil}, [][]any{nil})[0]
+	return &Response{Data: data, Errors: e.errors}
+}
+
+type executor struct {
+	schema    *Schema
+	doc       *Document
+	opts      Options
+	operation *Operation
+	variables map[string]any
+	errors    []*Error
+
+	// for validation
+	varTypes  map[string]Type
+	validated map[string]bool
+}
+
+func (e *executor) report(message string, locs ...Location) {
+	e.errors = append(e.errors, &Error{Message: message, Locations: locs})
+}
+
+// fieldGroup is the fields selected under one response key, which are resolved together
+type fieldGroup struct {
+	key    string
+	fields []*Field
+}
+
+// collectFields groups the selected fields by response key, in the order they are first selected,
+// expanding fragments and leaving out what @skip and @include exclude.
+func (e *executor) collectFields(t *Object, selections []Selection, groups []*fieldGroup, visited map[string]bool) []*fieldGroup {
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *Field:
+			if !e.included(selection.Directives) {
+				continue
+			}
+			key := selection.ResponseKey()
+			i := 0
+			for i < len(groups) && groups[i].key != key {
+				i++
+			}
+			if i == len(groups) {
+				groups = append(groups, &fieldGroup{key: key})
+			}
+			groups[i].fields = append(groups[i].fields, selection)
+		case *FragmentSpread:
+			if visited[selection.Name] || !e.included(selection.Directives) {
+				continue
+			}
+			visited[selection.Name] = true
+			fragment := e.doc.Fragments[selection.Name]
+			if fragment == nil || fragment.TypeCondition != t.Name {
+				continue
+			}
+			groups = e.collectFields(t, fragment.SelectionSet, groups, visited)
+		case *InlineFragment:
+			if !e.included(selection.Directives) {
+				continue
+			}
+			if selection.TypeCondition != "" && selection.TypeCondition != t.Name {
+				continue
+			}
+			groups = e.collectFields(t, selection.SelectionSet, groups, visited)
+		}
+	}
+	return groups
+}
+
+// included applies @skip and @include
+func (e *executor) included(directives []*Directive) bool {
+	for _, directive := range directives {
+		if directive.Name != "skip" && directive.Name != "include" {
+			continue
+		}
+		var condition bool
+		for _, arg := range directive.Arguments {
+			if arg.Name == "if" {
+				value, _ := coerceLiteral(&NonNull{Of: Boolean}, arg.Value, e.variables)
+				condition, _ = value.(bool)
+			}
+		}
+		if condition == (directive.Name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldDef returns the definition of the field, including the introspection fields
+func (e *executor) fieldDef(t *Object, name string) *FieldDef {
+	switch {
+	case name == "__typename":
+		return typenameField
+	case t == e.schema.Query && name == "__schema":
+		return e.schema.schemaField
+	case t == e.schema.Query && name == "__type":
+		return e.schema.typeField
+	}
+	return t.Field(name)
+}
+
+// arguments coerces the field's arguments, filling in defaults. They have been validated.
+func (e *executor) arguments(def *FieldDef, field *Field) map[string]any {
+	args := make(map[string]any, len(def.Args))
+	for _, argDef := range def.Args {
+		given := false
+		for _, arg := range field.Arguments {
+			if arg.Name != argDef.Name {
+				continue
+			}
+			if arg.Value.Kind == VariableValue {
+				if _, ok := e.variables[arg.Value.Raw]; !ok {
+					break
+				}
+			}
+			value, err := coerceLiteral(argDef.Type, arg.Value, e.variables)
+			if err == nil {
+				args[argDef.Name] = value
+				given = true
+			}
+		}
+		if !given && argDef.Default != nil {
+			args[argDef.Name] = argDef.Default
+		}
+	}
+	return args
+}
+
+func appendPath(path []any, elem any) []any {
+	return append(path[:len(path):len(path)], elem)
+}
+
+// executeSelections runs the selections on every source, which are values of type t. Each field
+// is resolved for all the sources before any Thunk is forced, and the values of each field are
+// completed together, so every level of the query batches the loads of all its objects. An
+// object is nil when a non-null field of it is null.
+func (e *executor) executeSelections(ctx context.Context, t *Object, selections []Selection, sources []any, paths [][]any) []*Map {
+	groups := e.collectFields(t, selections, nil, make(map[string]bool))
+
+	type resolved struct {
+		def    *FieldDef
+		values []any
+		errs   []error
+	}
+	fields := make([]resolved, len(groups))
+
+	for i, group := range groups {
+		def := e.fieldDef(t, group.fields[0].Name)
+		args := e.arguments(def, group.fields[0])
+		fields[i] = resolved{def: def, values: make([]any, len(sources)), errs: make([]error, len(sources))}
+
+		for j, source := range sources {
+			if def == typenameField {
+				fields[i].values[j] = t.Name
+				continue
+			}
+			fields[i].values[j], fields[i].errs[j] = resolve(ctx, def.Resolve, source, args)
+		}
+	}
+
+	for _, field := range fields {
+		for j, value := range field.values {
+			if thunk, ok := value.(Thunk); ok && field.errs[j] == nil {
+				field.values[j], field.errs[j] = force(thunk)
+			}
+		}
+	}
+
+	results := make([]*Map, len(sources))
+	for j := range results {
+		results[j] = newMap()
+	}
+
+	for i, group := range groups {
+		fieldPaths := make([][]any, len(sources))
+		for j := range sources {
+			fieldPaths[j] = appendPath(paths[j], group.key)
+		}
+
+		completed := e.completeValues(ctx, fields[i].def.Type, group.fields, fields[i].values, fields[i].errs, fieldPaths)
+		for j, c := range completed {
+			if results[j] == nil {
+				continue
+			}
+			if c.propagate {
+				results[j] = nil
+				continue
+			}
+			results[j].set(group.key, c.value)
+		}
+	}
+	return results
+}
+
+func resolve(ctx context.Context, fn ResolveFunc, source any, args map[string]any) (value any, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("graphql: resolver panicked: %v", rec)
+		}
+	}()
+	if fn == nil {
+		return nil, errors.New("graphql: the field has no resolver")
+	}
+	return fn(ctx, source, args)
+}
+
+func force(thunk Thunk) (value any, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("graphql: resolver panicked: %v", rec)
+		}
+	}()
+	return thunk()
+}
+
+// completion is a completed value. A null that an error has been reported for is errored, and one
+// that must null its parent, because its field is non-null, propagates.
+type completion struct {
+	value     any
+	errored   bool
+	propagate bool
+}
+
+func (e *executor) fieldError(ctx context.Context, err error, fields []*Field, path []any) {
+	var gqlErr *Error
+	if !errors.As(err, &gqlErr) {
+		if e.opts.FormatError != nil {
+			gqlErr = e.opts.FormatError(ctx, err)
+		} else {
+			gqlErr = &Error{Message: err.Error()}
+		}
+	}
+
+	reported := *gqlErr
+	reported.Locations = []Location{fields[0].Loc}
+	reported.Path = path
+	e.errors = append(e.errors, &reported)
+}
+
+// isNil reports whether v is nil, including nil pointers, slices and maps in an interface
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func:
+		return value.IsNil()
+	}
+	return false
+}
+
+// completeValues turns the resolved values of a field into response values of its type
+func (e *executor) completeValues(ctx context.Context, t Type, fields []*Field, values []any, errs []error, paths [][]any) []completion {
+	completed := make([]completion, len(values))
+
+	if nonNull, ok := t.(*NonNull); ok {
+		completed = e.completeValues(ctx, nonNull.Of, fields, values, errs, paths)
+		for i, c := range completed {
+			if c.value != nil {
+				continue
+			}
+			if !c.errored {
+				e.fieldError(ctx, fmt.Errorf("cannot return null for the non-null field %s", fields[0].Name), fields, paths[i])
+			}
+			completed[i] = completion{errored: true, propagate: true}
+		}
+		return completed
+	}
+
+	// the values left to complete, which are not null or errors
+	var pending []int
+	for i, value := range values {
+		switch {
+		case errs[i] != nil:
+			e.fieldError(ctx, errs[i], fields, paths[i])
+			completed[i].errored = true
+		case !isNil(value):
+			pending = append(pending, i)
+		}
+	}
+
+	switch t := t.(type) {
+	case *Scalar, *Enum:
+		for _, i := range pending {
+			var value any
+			var err error
+			if scalar, ok := t.(*Scalar); ok {
+				value, err = scalar.Serialize(values[i])
+			} else {
+				value, err = serializeEnum(t.(*Enum), values[i])
+			}
+			if err != nil {
+				e.fieldError(ctx, err, fields, paths[i])
+				completed[i].errored = true
+				continue
+			}
+			completed[i].value = value
+		}
+
+	case *List:
+		// the items of every list are completed together, in one batch
+		var items []any
+		var itemPaths [][]any
+		var lengths []int
+		for _, i := range pending {
+			value := reflect.ValueOf(values[i])
+			if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+				e.fieldError(ctx, fmt.Errorf("graphql: expected a list for %s, got %T", fields[0].Name, values[i]), fields, paths[i])
+				completed[i].errored = true
+				lengths = append(lengths, -1)
+				continue
+			}
+			for j := range value.Len() {
+				items = append(items, value.Index(j).Interface())
+				itemPaths = append(itemPaths, appendPath(paths[i], j))
+			}
+			lengths = append(lengths, value.Len())
+		}
+
+		itemErrs := make([]error, len(items))
+		for j, item := range items {
+			if thunk, ok := item.(Thunk); ok {
+				items[j], itemErrs[j] = force(thunk)
+			}
+		}
+		completedItems := e.completeValues(ctx, t.Of, fields, items, itemErrs, itemPaths)
+
+		offset := 0
+		for k, i := range pending {
+			if lengths[k] < 0 {
+				continue
+			}
+			list := make([]any, lengths[k])
+			nulled := false
+			for j := range list {
+				c := completedItems[offset+j]
+				nulled = nulled || c.propagate
+				list[j] = c.value
+			}
+			offset += lengths[k]
+
+			if nulled {
+				completed[i].errored = true
+				continue
+			}
+			completed[i].value = list
+		}
+
+	case *Object:
+		sources := make([]any, len(pending))
+		sourcePaths := make([][]any, len(pending))
+		for k, i := range pending {
+			sources[k] = values[i]
+			sourcePaths[k] = paths[i]
+		}
+
+		var selections []Selection
+		for _, field := range fields {
+			selections = append(selections, field.SelectionSet...)
+		}
+
+		for k, result := range e.executeSelections(ctx, t, selections, sources, sourcePaths) {
+			i := pending[k]
+			if result == nil {
+				completed[i].errored = true
+				continue
+			}
+			completed[i].value = result
+		}
+	}
+	return completed
+}
+
+func serializeEnum(t *Enum, v any) (any, error) {
+	s, ok := v.(string)
+	if !ok {
+		if stringer, isStringer := v.(fmt.Stringer); isStringer {
+			s, ok = stringer.String(), true
+		}
+	}
+	for _, value := range t.Values {
+		if ok && value == s {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%s cannot represent %s", t, describeValue(v))
+}
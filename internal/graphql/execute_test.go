@@ -0,0 +1,208 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testPerson struct {
+	name    string
+	friends []*testPerson
+}
+
+// testSchema has people whose friends can be selected to any depth, a paginated list whose
+// complexity grows with its page size and an echo field to coerce arguments through
+func testSchema(t *testing.T) *Schema {
+	t.Helper()
+
+	alice := &testPerson{name: "Alice"}
+	bob := &testPerson{name: "Bob", friends: []*testPerson{alice}}
+	alice.friends = []*testPerson{bob}
+
+	person := &Object{Name: "Person"}
+	person.Fields = []*FieldDef{
+		{
+			Name: "name",
+			Type: &NonNull{Of: String},
+			Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+				return source.(*testPerson).name, nil
+			},
+		},
+		{
+			Name: "friends",
+			Type: &List{Of: &NonNull{Of: person}},
+			Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+				return source.(*testPerson).friends, nil
+			},
+		},
+	}
+
+	query := &Object{
+		Name: "Query",
+		Fields: []*FieldDef{
+			{
+				Name: "person",
+				Type: person,
+				Args: []*ArgDef{{Name: "name", Type: &NonNull{Of: String}}},
+				Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+					for _, p := range []*testPerson{alice, bob} {
+						if p.name == args["name"] {
+							return p, nil
+						}
+					}
+					return nil, nil
+				},
+			},
+			{
+				Name: "people",
+				Type: &NonNull{Of: &List{Of: &NonNull{Of: person}}},
+				Args: []*ArgDef{{Name: "first", Type: Int, Default: 10}},
+				Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+					return []*testPerson{alice, bob}, nil
+				},
+				Complexity: func(args map[string]any, childComplexity int) int {
+					return 1 + args["first"].(int)*childComplexity
+				},
+			},
+			{
+				Name: "echo",
+				Type: String,
+				Args: []*ArgDef{
+					{Name: "n", Type: Int},
+					{Name: "f", Type: Float},
+					{Name: "ids", Type: &List{Of: &NonNull{Of: ID}}},
+				},
+				Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+					return fmt.Sprint(args["n"], args["f"], args["ids"]), nil
+				},
+			},
+		},
+	}
+
+	schema, err := NewSchema(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func errorMessages(resp *Response) string {
+	messages := make([]string, len(resp.Errors))
+	for i, err := range resp.Errors {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func TestExecute(t *testing.T) {
+	schema := testSchema(t)
+
+	resp := Execute(context.Background(), schema, Request{
+		Query:     `query ($name: String!) { p: person(name: $name) { name friends { name } } echo(n: 1, f: 2.5, ids: [3]) }`,
+		Variables: map[string]any{"name": "Alice"},
+	}, Options{})
+	if len(resp.Errors) > 0 {
+		t.Fatal(errorMessages(resp))
+	}
+
+	got, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"p":{"name":"Alice","friends":[{"name":"Bob"}]},"echo":"1 2.5 [3]"}`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestExecuteDepthLimit(t *testing.T) {
+	schema := testSchema(t)
+
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{`{ person(name: "Alice") { name } }`, ""},
+		{`{ person(name: "Alice") { friends { friends { name } } } }`, ""},
+		{`{ person(name: "Alice") { friends { friends { friends { name } } } } }`, "the query has a depth of 5, which exceeds the maximum of 4"},
+		// fragments count at the depth they are spread at
+		{`{ person(name: "Alice") { friends { ...deep } } } fragment deep on Person { friends { friends { name } } }`, "the query has a depth of 5, which exceeds the maximum of 4"},
+		// introspection does not count
+		{`{ __schema { types { fields { type { ofType { name } } } } } }`, ""},
+	}
+
+	for _, tt := range tests {
+		resp := Execute(context.Background(), schema, Request{Query: tt.query}, Options{MaxDepth: 4})
+		if got := errorMessages(resp); got != tt.err {
+			t.Errorf("%s: got errors %q, want %q", tt.query, got, tt.err)
+		}
+		if tt.err != "" && resp.Data != nil {
+			t.Errorf("%s: got data for a query over the limit", tt.query)
+		}
+	}
+}
+
+func TestExecuteComplexityLimit(t *testing.T) {
+	schema := testSchema(t)
+
+	tests := []struct {
+		query     string
+		variables map[string]any
+		err       string
+	}{
+		// 1 + the default page size of 10 × 1 for name
+		{`{ people { name } }`, nil, ""},
+		// 1 + 30 × (1 for name + 2 for friends { name }) is 91
+		{`{ people(first: 30) { name friends { name } } }`, nil, ""},
+		{`{ people(first: 40) { name friends { name } } }`, nil, "the query has a complexity of 121, which exceeds the maximum of 100"},
+		// the page size may come from a variable
+		{`query ($n: Int) { people(first: $n) { name } }`, map[string]any{"n": 200}, "the query has a complexity of 201, which exceeds the maximum of 100"},
+		// sibling fields add up
+		{`{ a: people(first: 50) { name } b: people(first: 50) { name } }`, nil, "the query has a complexity of 102, which exceeds the maximum of 100"},
+		{`{ person(name: "Alice") { name } }`, nil, ""},
+	}
+
+	for _, tt := range tests {
+		resp := Execute(context.Background(), schema, Request{Query: tt.query, Variables: tt.variables}, Options{MaxComplexity: 100})
+		if got := errorMessages(resp); got != tt.err {
+			t.Errorf("%s: got errors %q, want %q", tt.query, got, tt.err)
+		}
+	}
+}
+
+func TestExecuteVariableCoercion(t *testing.T) {
+	schema := testSchema(t)
+
+	tests := []struct {
+		query     string
+		variables map[string]any
+		err       string
+	}{
+		{`query ($n: Int) { echo(n: $n) }`, map[string]any{"n": 2.0}, ""},
+		{`query ($n: Int) { echo(n: $n) }`, map[string]any{"n": "2"}, `variable $n got an invalid value: Int cannot represent "2"`},
+		{`query ($n: Int) { echo(n: $n) }`, map[string]any{"n": 2.5}, `variable $n got an invalid value: Int cannot represent 2.5`},
+		{`query ($n: Int) { echo(n: $n) }`, map[string]any{"n": float64(1 << 31)}, `variable $n got an invalid value: Int cannot represent 2.147483648e+09`},
+		{`query ($f: Float) { echo(f: $f) }`, map[string]any{"f": true}, `variable $f got an invalid value: Float cannot represent true`},
+		{`query ($name: String!) { person(name: $name) { name } }`, nil, `variable $name of required type String! was not provided`},
+		{`query ($name: String!) { person(name: $name) { name } }`, map[string]any{"name": nil}, `variable $name got an invalid value: expected a value of type String!, found null`},
+		{`query ($ids: [ID!]) { echo(ids: $ids) }`, map[string]any{"ids": []any{"1", 2.0}}, ""},
+		{`query ($ids: [ID!]) { echo(ids: $ids) }`, map[string]any{"ids": "1"}, ""},
+		{`query ($ids: [ID!]) { echo(ids: $ids) }`, map[string]any{"ids": []any{"1", nil}}, `variable $ids got an invalid value: at index 1: expected a value of type ID!, found null`},
+		{`query ($ids: [ID!]) { echo(ids: $ids) }`, map[string]any{"ids": []any{true}}, `variable $ids got an invalid value: at index 0: ID cannot represent true`},
+		{`query ($n: Int = "one") { echo(n: $n) }`, nil, `variable $n has an invalid default value: Int cannot represent "one"`},
+		{`query ($p: Person) { echo(n: 1) }`, nil, `variable $p cannot be of the non-input type Person`},
+		{`query ($n: String) { echo(n: $n) }`, map[string]any{"n": "1"}, `variable $n of type String cannot be used for argument "n" of type Int`},
+	}
+
+	for _, tt := range tests {
+		resp := Execute(context.Background(), schema, Request{Query: tt.query, Variables: tt.variables}, Options{})
+		if got := errorMessages(resp); got != tt.err {
+			t.Errorf("%s %v: got errors %q, want %q", tt.query, tt.variables, got, tt.err)
+		}
+		if tt.err != "" && resp.Data != nil {
+			t.Errorf("%s %v: got data for invalid variables", tt.query, tt.variables)
+		}
+	}
+}
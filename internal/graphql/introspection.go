@@ -0,0 +1,303 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// the types of the introspection system (https://spec.graphql.org/October2021/#sec-Schema-Introspection)
+var (
+	introspectionSchema = &Object{Name: "__Schema", Description: "A GraphQL service's type system."}
+	introspectionType   = &Object{Name: "__Type", Description: "A type of the schema, or a list or non-null wrapper of one."}
+	introspectionField  = &Object{Name: "__Field", Description: "A field of an object type."}
+	introspectionInput  = &Object{Name: "__InputValue", Description: "An argument of a field or directive."}
+	introspectionEnum   = &Object{Name: "__EnumValue", Description: "A value of an enum type."}
+	introspectionDir    = &Object{Name: "__Directive", Description: "A directive that can be used in queries."}
+
+	typeKind = &Enum{
+		Name:        "__TypeKind",
+		Description: "The kinds of types.",
+		Values:      []string{"SCALAR", "OBJECT", "INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL"},
+	}
+	directiveLocation = &Enum{
+		Name:        "__DirectiveLocation",
+		Description: "The places in a query a directive can be used.",
+		Values: []string{
+			"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION",
+			"FRAGMENT_SPREAD", "INLINE_FRAGMENT", "VARIABLE_DEFINITION",
+		},
+	}
+)
+
+// typenameField is __typename, which every object type has
+var typenameField = &FieldDef{
+	Name:        "__typename",
+	Description: "The name of the object's type.",
+	Type:        &NonNull{Of: String},
+}
+
+// directive is one of the directives the executor supports
+type directive struct {
+	name        string
+	description string
+}
+
+var directives = []directive{
+	{"include", "Includes the selection only when the argument is true."},
+	{"skip", "Leaves out the selection when the argument is true."},
+}
+
+func nonNullList(t Type) Type {
+	return &NonNull{Of: &List{Of: &NonNull{Of: t}}}
+}
+
+var includeDeprecated = []*ArgDef{{Name: "includeDeprecated", Type: Boolean, Default: false}}
+
+func init() {
+	introspectionSchema.Fields = []*FieldDef{
+		{Name: "description", Type: String, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return nil, nil
+		}},
+		{Name: "types", Type: nonNullList(introspectionType), Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			s := source.(*Schema)
+			var types []any
+			for _, name := range slices.Sorted(maps.Keys(s.types)) {
+				types = append(types, s.types[name])
+			}
+			return types, nil
+		}},
+		{Name: "queryType", Type: &NonNull{Of: introspectionType}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return source.(*Schema).Query, nil
+		}},
+		{Name: "mutationType", Type: introspectionType, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return nil, nil
+		}},
+		{Name: "subscriptionType", Type: introspectionType, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return nil, nil
+		}},
+		{Name: "directives", Type: nonNullList(introspectionDir), Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return directives, nil
+		}},
+	}
+
+	introspectionType.Fields = []*FieldDef{
+		{Name: "kind", Type: &NonNull{Of: typeKind}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			switch t := source.(type) {
+			case *List:
+				return "LIST", nil
+			case *NonNull:
+				return "NON_NULL", nil
+			case namedType:
+				return t.kind(), nil
+			}
+			return nil, fmt.Errorf("graphql: unknown type %T", source)
+		}},
+		{Name: "name", Type: String, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			if t, ok := source.(namedType); ok {
+				return t.typeName(), nil
+			}
+			return nil, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			var description string
+			switch t := source.(type) {
+			case *Scalar:
+				description = t.Description
+			case *Enum:
+				description = t.Description
+			case *Object:
+				description = t.Description
+			}
+			return optionalString(description), nil
+		}},
+		{Name: "fields", Type: &List{Of: &NonNull{Of: introspectionField}}, Args: includeDeprecated, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			if t, ok := source.(*Object); ok {
+				return t.Fields, nil
+			}
+			return nil, nil
+		}},
+		{Name: "interfaces", Type: &List{Of: &NonNull{Of: introspectionType}}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			if _, ok := source.(*Object); ok {
+				return []any{}, nil
+			}
+			return nil, nil
+		}},
+		{Name: "possibleTypes", Type: &List{Of: &NonNull{Of: introspectionType}}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return nil, nil
+		}},
+		{Name: "enumValues", Type: &List{Of: &NonNull{Of: introspectionEnum}}, Args: includeDeprecated, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			if t, ok := source.(*Enum); ok {
+				return t.Values, nil
+			}
+			return nil, nil
+		}},
+		{Name: "inputFields", Type: &List{Of: &NonNull{Of: introspectionInput}}, Args: includeDeprecated, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return nil, nil
+		}},
+		{Name: "ofType", Type: introspectionType, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			switch t := source.(type) {
+			case *List:
+				return t.Of, nil
+			case *NonNull:
+				return t.Of, nil
+			}
+			return nil, nil
+		}},
+		{Name: "specifiedByURL", Type: String, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return nil, nil
+		}},
+	}
+
+	introspectionField.Fields = []*FieldDef{
+		{Name: "name", Type: &NonNull{Of: String}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return source.(*FieldDef).Name, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return optionalString(source.(*FieldDef).Description), nil
+		}},
+		{Name: "args", Type: nonNullList(introspectionInput), Args: includeDeprecated, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			if args := source.(*FieldDef).Args; args != nil {
+				return args, nil
+			}
+			return []*ArgDef{}, nil
+		}},
+		{Name: "type", Type: &NonNull{Of: introspectionType}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return source.(*FieldDef).Type, nil
+		}},
+		notDeprecated,
+		noDeprecationReason,
+	}
+
+	introspectionInput.Fields = []*FieldDef{
+		{Name: "name", Type: &NonNull{Of: String}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return source.(*ArgDef).Name, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return optionalString(source.(*ArgDef).Description), nil
+		}},
+		{Name: "type", Type: &NonNull{Of: introspectionType}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return source.(*ArgDef).Type, nil
+		}},
+		{Name: "defaultValue", Type: String, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			arg := source.(*ArgDef)
+			if arg.Default == nil {
+				return nil, nil
+			}
+			return formatValue(arg.Type, arg.Default), nil
+		}},
+		notDeprecated,
+		noDeprecationReason,
+	}
+
+	introspectionEnum.Fields = []*FieldDef{
+		{Name: "name", Type: &NonNull{Of: String}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return source, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return nil, nil
+		}},
+		notDeprecated,
+		noDeprecationReason,
+	}
+
+	introspectionDir.Fields = []*FieldDef{
+		{Name: "name", Type: &NonNull{Of: String}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return source.(directive).name, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return source.(directive).description, nil
+		}},
+		{Name: "locations", Type: nonNullList(directiveLocation), Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"}, nil
+		}},
+		{Name: "args", Type: nonNullList(introspectionInput), Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return directiveArgs, nil
+		}},
+		{Name: "isRepeatable", Type: &NonNull{Of: Boolean}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return false, nil
+		}},
+	}
+}
+
+// nothing in the schema is deprecated
+var (
+	notDeprecated = &FieldDef{Name: "isDeprecated", Type: &NonNull{Of: Boolean}, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+		return false, nil
+	}}
+	noDeprecationReason = &FieldDef{Name: "deprecationReason", Type: String, Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+		return nil, nil
+	}}
+)
+
+func optionalString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// formatValue writes an input value of the type as it would appear in a query
+func formatValue(t Type, v any) string {
+	if nonNull, ok := t.(*NonNull); ok {
+		t = nonNull.Of
+	}
+	if v == nil {
+		return "null"
+	}
+
+	switch t := t.(type) {
+	case *List:
+		var items []string
+		switch v := v.(type) {
+		case []any:
+			for _, item := range v {
+				items = append(items, formatValue(t.Of, item))
+			}
+		case []string:
+			for _, item := range v {
+				items = append(items, formatValue(t.Of, item))
+			}
+		default:
+			return formatValue(t.Of, v)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *Enum:
+		return fmt.Sprint(v)
+	}
+
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// introspectionFields adds __schema and __type to the query type of the schema
+func (s *Schema) introspectionFields() {
+	s.schemaField = &FieldDef{
+		Name:        "__schema",
+		Description: "The schema's type system.",
+		Type:        &NonNull{Of: introspectionSchema},
+		Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			return s, nil
+		},
+	}
+	s.typeField = &FieldDef{
+		Name:        "__type",
+		Description: "The type with the name.",
+		Type:        introspectionType,
+		Args:        []*ArgDef{{Name: "name", Type: &NonNull{Of: String}}},
+		Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+			t, ok := s.types[args["name"].(string)]
+			if !ok {
+				return nil, nil
+			}
+			return t, nil
+		},
+	}
+}
@@ -0,0 +1,82 @@
+package graphql
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Loader batches the lookups of many objects into one. Resolvers call Load and return the Thunk,
+// and the first Thunk to be forced fetches every key queued until then, so a field selected on
+// every item of a list is fetched once rather than once per item. Loaders cache what they fetch,
+// and are meant to live for a single request.
+type Loader[K comparable, V any] struct {
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	pending []K
+	results map[K]V
+	errs    map[K]error
+}
+
+// NewLoader returns a Loader that looks up keys with fetch. Keys fetch leaves out of its result
+// load as the zero value of V.
+func NewLoader[K comparable, V any](fetch func(ctx context.Context, keys []K) (map[K]V, error)) *Loader[K, V] {
+	return &Loader[K, V]{
+		fetch:   fetch,
+		results: make(map[K]V),
+		errs:    make(map[K]error),
+	}
+}
+
+// Load queues the key and returns a Thunk of its value
+func (l *Loader[K, V]) Load(ctx context.Context, key K) Thunk {
+	l.mu.Lock()
+	if !l.loaded(key) && !l.queued(key) {
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (any, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if !l.loaded(key) {
+			l.dispatch(ctx)
+		}
+		if err := l.errs[key]; err != nil {
+			return nil, err
+		}
+		return l.results[key], nil
+	}
+}
+
+// dispatch fetches the pending keys. The caller holds mu.
+func (l *Loader[K, V]) dispatch(ctx context.Context) {
+	keys := l.pending
+	l.pending = nil
+	if len(keys) == 0 {
+		return
+	}
+
+	results, err := l.fetch(ctx, keys)
+	for _, key := range keys {
+		if err != nil {
+			l.errs[key] = err
+			continue
+		}
+		l.results[key] = results[key]
+	}
+}
+
+func (l *Loader[K, V]) loaded(key K) bool {
+	_, ok := l.results[key]
+	if !ok {
+		_, ok = l.errs[key]
+	}
+	return ok
+}
+
+func (l *Loader[K, V]) queued(key K) bool {
+	return slices.Contains(l.pending, key)
+}
@@ -0,0 +1,669 @@
+// Package graphql runs GraphQL queries (https://spec.graphql.org/October2021/) against a schema
+// of Go resolvers. It covers what a read API needs: queries with variables, fragments, the @skip
+// and @include directives, introspection, limits on query depth and complexity, and a Loader that
+// batches the lookups of sibling fields.
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Location is a position in a query, counted from 1
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Document is a parsed query
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+type Operation struct {
+	// Type is query, mutation or subscription
+	Type         string
+	Name         string
+	Variables    []*VariableDefinition
+	Directives   []*Directive
+	SelectionSet []Selection
+	Loc          Location
+}
+
+type VariableDefinition struct {
+	Name    string
+	Type    *TypeRef
+	Default *Value
+	Loc     Location
+}
+
+// TypeRef names a type in a query, such as [String!]
+type TypeRef struct {
+	Name    string
+	Elem    *TypeRef
+	NonNull bool
+}
+
+func (t *TypeRef) String() string {
+	s := t.Name
+	if t.Elem != nil {
+		s = "[" + t.Elem.String() + "]"
+	}
+	if t.NonNull {
+		s += "!"
+	}
+	return s
+}
+
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+	Loc           Location
+}
+
+// Selection is a *Field, *FragmentSpread or *InlineFragment
+type Selection interface {
+	location() Location
+}
+
+type Field struct {
+	Alias        string
+	Name         string
+	Arguments    []*Argument
+	Directives   []*Directive
+	SelectionSet []Selection
+	Loc          Location
+}
+
+// ResponseKey is the name the field's result is written under
+func (f *Field) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+	Loc        Location
+}
+
+type InlineFragment struct {
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+	Loc           Location
+}
+
+func (f *Field) location() Location          { return f.Loc }
+func (f *FragmentSpread) location() Location { return f.Loc }
+func (f *InlineFragment) location() Location { return f.Loc }
+
+type Directive struct {
+	Name      string
+	Arguments []*Argument
+	Loc       Location
+}
+
+type Argument struct {
+	Name  string
+	Value *Value
+	Loc   Location
+}
+
+type ValueKind int
+
+const (
+	VariableValue ValueKind = iota
+	IntValue
+	FloatValue
+	StringValue
+	BooleanValue
+	NullValue
+	EnumValue
+	ListValue
+	ObjectValue
+)
+
+// Value is a literal or variable in a query. Raw holds the variable name, the number, string or
+// enum value, or true or false.
+type Value struct {
+	Kind   ValueKind
+	Raw    string
+	List   []*Value
+	Fields []*ObjectField
+	Loc    Location
+}
+
+type ObjectField struct {
+	Name  string
+	Value *Value
+}
+
+// SyntaxError reports a query that cannot be parsed
+type SyntaxError struct {
+	Message string
+	Loc     Location
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d:%d: %s", e.Loc.Line, e.Loc.Column, e.Message)
+}
+
+const byteOrderMark = "\uFEFF"
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	loc   Location
+}
+
+type parser struct {
+	src   string
+	pos   int
+	line  int
+	col   int
+	token token
+}
+
+// Parse parses an executable document
+func Parse(query string) (doc *Document, err error) {
+	p := &parser{src: query, line: 1, col: 1}
+
+	// the parser panics with a *SyntaxError to unwind, which is recovered here
+	defer func() {
+		if r := recover(); r != nil {
+			syntaxErr, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, syntaxErr
+		}
+	}()
+
+	p.next()
+	return p.document(), nil
+}
+
+func (p *parser) fail(loc Location, format string, args ...any) {
+	panic(&SyntaxError{Message: fmt.Sprintf(format, args...), Loc: loc})
+}
+
+func (p *parser) advance(n int) {
+	for _, r := range p.src[p.pos : p.pos+n] {
+		if r == '\n' {
+			p.line++
+			p.col = 1
+		} else {
+			p.col++
+		}
+	}
+	p.pos += n
+}
+
+// next reads the next token, skipping whitespace, commas and comments
+func (p *parser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.advance(1)
+		case c == '#':
+			end := strings.IndexAny(p.src[p.pos:], "\r\n")
+			if end < 0 {
+				end = len(p.src) - p.pos
+			}
+			p.advance(end)
+		case strings.HasPrefix(p.src[p.pos:], byteOrderMark):
+			p.advance(len(byteOrderMark))
+		default:
+			p.token = p.readToken()
+			return
+		}
+	}
+	p.token = token{kind: tokenEOF, loc: Location{p.line, p.col}}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (p *parser) readToken() token {
+	loc := Location{p.line, p.col}
+	rest := p.src[p.pos:]
+	c := rest[0]
+
+	switch {
+	case strings.HasPrefix(rest, "..."):
+		p.advance(3)
+		return token{kind: tokenPunctuator, value: "...", loc: loc}
+	case strings.IndexByte("!$&()+:=@[]{}|", c) >= 0:
+		p.advance(1)
+		return token{kind: tokenPunctuator, value: string(c), loc: loc}
+	case isNameStart(c):
+		n := 1
+		for n < len(rest) && (isNameStart(rest[n]) || isDigit(rest[n])) {
+			n++
+		}
+		p.advance(n)
+		return token{kind: tokenName, value: rest[:n], loc: loc}
+	case c == '-' || isDigit(c):
+		return p.readNumber(loc)
+	case strings.HasPrefix(rest, `"""`):
+		return p.readBlockString(loc)
+	case c == '"':
+		return p.readString(loc)
+	}
+
+	r, _ := utf8.DecodeRuneInString(rest)
+	p.fail(loc, "unexpected character %q", r)
+	return token{}
+}
+
+func (p *parser) readNumber(loc Location) token {
+	rest := p.src[p.pos:]
+	n := 0
+	if rest[n] == '-' {
+		n++
+	}
+	start := n
+	for n < len(rest) && isDigit(rest[n]) {
+		n++
+	}
+	if n == start || (rest[start] == '0' && n-start > 1) {
+		p.fail(loc, "invalid number %q", rest[:max(n, 1)])
+	}
+
+	kind := tokenInt
+	if n < len(rest) && rest[n] == '.' {
+		kind = tokenFloat
+		n++
+		digits := n
+		for n < len(rest) && isDigit(rest[n]) {
+			n++
+		}
+		if n == digits {
+			p.fail(loc, "invalid number %q", rest[:n])
+		}
+	}
+	if n < len(rest) && (rest[n] == 'e' || rest[n] == 'E') {
+		kind = tokenFloat
+		n++
+		if n < len(rest) && (rest[n] == '+' || rest[n] == '-') {
+			n++
+		}
+		digits := n
+		for n < len(rest) && isDigit(rest[n]) {
+			n++
+		}
+		if n == digits {
+			p.fail(loc, "invalid number %q", rest[:n])
+		}
+	}
+	if n < len(rest) && (isNameStart(rest[n]) || rest[n] == '.') {
+		p.fail(loc, "invalid number %q", rest[:n+1])
+	}
+
+	p.advance(n)
+	return token{kind: kind, value: rest[:n], loc: loc}
+}
+
+func (p *parser) readString(loc Location) token {
+	var sb strings.Builder
+	p.advance(1)
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' || p.src[p.pos] == '\r' {
+			p.fail(loc, "unterminated string")
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.advance(1)
+			return token{kind: tokenString, value: sb.String(), loc: loc}
+		case c == '\\':
+			if p.pos+1 >= len(p.src) {
+				p.fail(loc, "unterminated string")
+			}
+			escape := p.src[p.pos+1]
+			if escaped, ok := map[byte]byte{'"': '"', '\\': '\\', '/': '/', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t'}[escape]; ok {
+				sb.WriteByte(escaped)
+				p.advance(2)
+				continue
+			}
+			if escape != 'u' || p.pos+6 > len(p.src) {
+				p.fail(Location{p.line, p.col}, "invalid escape sequence")
+			}
+			code, err := strconv.ParseUint(p.src[p.pos+2:p.pos+6], 16, 32)
+			if err != nil {
+				p.fail(Location{p.line, p.col}, "invalid escape sequence")
+			}
+			sb.WriteRune(rune(code))
+			p.advance(6)
+		default:
+			_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			sb.WriteString(p.src[p.pos : p.pos+size])
+			p.advance(size)
+		}
+	}
+}
+
+// readBlockString reads a """ string, removing the indentation its lines share as the spec asks
+func (p *parser) readBlockString(loc Location) token {
+	p.advance(3)
+	var sb strings.Builder
+	for {
+		if p.pos >= len(p.src) {
+			p.fail(loc, "unterminated string")
+		}
+		rest := p.src[p.pos:]
+		switch {
+		case strings.HasPrefix(rest, `"""`):
+			p.advance(3)
+			return token{kind: tokenString, value: blockStringValue(sb.String()), loc: loc}
+		case strings.HasPrefix(rest, `\"""`):
+			sb.WriteString(`"""`)
+			p.advance(4)
+		default:
+			_, size := utf8.DecodeRuneInString(rest)
+			sb.WriteString(rest[:size])
+			p.advance(size)
+		}
+	}
+}
+
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(strings.ReplaceAll(raw, "\r\n", "\n"), "\r", "\n"), "\n")
+
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			lines[i] = lines[i][min(indent, len(lines[i])):]
+		}
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *parser) peek(value string) bool {
+	return p.token.kind == tokenPunctuator && p.token.value == value
+}
+
+func (p *parser) peekName(value string) bool {
+	return p.token.kind == tokenName && p.token.value == value
+}
+
+func (p *parser) describe() string {
+	switch p.token.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenString:
+		return "string"
+	default:
+		return fmt.Sprintf("%q", p.token.value)
+	}
+}
+
+func (p *parser) expect(value string) Location {
+	if !p.peek(value) {
+		p.fail(p.token.loc, "expected %q, found %s", value, p.describe())
+	}
+	loc := p.token.loc
+	p.next()
+	return loc
+}
+
+func (p *parser) skip(value string) bool {
+	if p.peek(value) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) name() string {
+	if p.token.kind != tokenName {
+		p.fail(p.token.loc, "expected a name, found %s", p.describe())
+	}
+	name := p.token.value
+	p.next()
+	return name
+}
+
+func (p *parser) document() *Document {
+	doc := &Document{Fragments: make(map[string]*Fragment)}
+	if p.token.kind == tokenEOF {
+		p.fail(p.token.loc, "the query must contain an operation")
+	}
+
+	for p.token.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			loc := p.token.loc
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", SelectionSet: p.selectionSet(), Loc: loc})
+		case p.peekName("query"), p.peekName("mutation"), p.peekName("subscription"):
+			doc.Operations = append(doc.Operations, p.operation())
+		case p.peekName("fragment"):
+			fragment := p.fragment()
+			if _, ok := doc.Fragments[fragment.Name]; ok {
+				p.fail(fragment.Loc, "there can be only one fragment named %q", fragment.Name)
+			}
+			doc.Fragments[fragment.Name] = fragment
+		default:
+			p.fail(p.token.loc, "expected an operation or fragment, found %s", p.describe())
+		}
+	}
+	return doc
+}
+
+func (p *parser) operation() *Operation {
+	op := &Operation{Type: p.token.value, Loc: p.token.loc}
+	p.next()
+
+	if p.token.kind == tokenName {
+		op.Name = p.name()
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			def := &VariableDefinition{Loc: p.expect("$")}
+			def.Name = p.name()
+			p.expect(":")
+			def.Type = p.typeRef()
+			if p.skip("=") {
+				def.Default = p.value(true)
+			}
+			op.Variables = append(op.Variables, def)
+		}
+	}
+	op.Directives = p.directives()
+	op.SelectionSet = p.selectionSet()
+	return op
+}
+
+func (p *parser) typeRef() *TypeRef {
+	var t *TypeRef
+	if p.skip("[") {
+		t = &TypeRef{Elem: p.typeRef()}
+		p.expect("]")
+	} else {
+		t = &TypeRef{Name: p.name()}
+	}
+	t.NonNull = p.skip("!")
+	return t
+}
+
+func (p *parser) fragment() *Fragment {
+	fragment := &Fragment{Loc: p.token.loc}
+	p.next()
+
+	nameLoc := p.token.loc
+	fragment.Name = p.name()
+	if fragment.Name == "on" {
+		p.fail(nameLoc, "a fragment cannot be named \"on\"")
+	}
+	if !p.peekName("on") {
+		p.fail(p.token.loc, "expected \"on\", found %s", p.describe())
+	}
+	p.next()
+	fragment.TypeCondition = p.name()
+	fragment.Directives = p.directives()
+	fragment.SelectionSet = p.selectionSet()
+	return fragment
+}
+
+func (p *parser) selectionSet() []Selection {
+	p.expect("{")
+	var selections []Selection
+	for !p.skip("}") {
+		selections = append(selections, p.selection())
+	}
+	if len(selections) == 0 {
+		p.fail(p.token.loc, "a selection set must not be empty")
+	}
+	return selections
+}
+
+func (p *parser) selection() Selection {
+	if !p.peek("...") {
+		return p.field()
+	}
+
+	loc := p.token.loc
+	p.next()
+	if p.token.kind == tokenName && !p.peekName("on") {
+		return &FragmentSpread{Name: p.name(), Directives: p.directives(), Loc: loc}
+	}
+
+	fragment := &InlineFragment{Loc: loc}
+	if p.peekName("on") {
+		p.next()
+		fragment.TypeCondition = p.name()
+	}
+	fragment.Directives = p.directives()
+	fragment.SelectionSet = p.selectionSet()
+	return fragment
+}
+
+func (p *parser) field() *Field {
+	field := &Field{Loc: p.token.loc}
+	field.Name = p.name()
+	if p.skip(":") {
+		field.Alias, field.Name = field.Name, p.name()
+	}
+	field.Arguments = p.arguments(false)
+	field.Directives = p.directives()
+	if p.peek("{") {
+		field.SelectionSet = p.selectionSet()
+	}
+	return field
+}
+
+func (p *parser) arguments(constant bool) []*Argument {
+	if !p.skip("(") {
+		return nil
+	}
+	var args []*Argument
+	for !p.skip(")") {
+		arg := &Argument{Loc: p.token.loc}
+		arg.Name = p.name()
+		p.expect(":")
+		arg.Value = p.value(constant)
+		args = append(args, arg)
+	}
+	return args
+}
+
+func (p *parser) directives() []*Directive {
+	var directives []*Directive
+	for p.peek("@") {
+		directive := &Directive{Loc: p.token.loc}
+		p.next()
+		directive.Name = p.name()
+		directive.Arguments = p.arguments(false)
+		directives = append(directives, directive)
+	}
+	return directives
+}
+
+// value parses a value, where variables are not allowed when constant is true
+func (p *parser) value(constant bool) *Value {
+	tok := p.token
+	switch {
+	case p.peek("$"):
+		if constant {
+			p.fail(tok.loc, "a variable is not allowed here")
+		}
+		p.next()
+		return &Value{Kind: VariableValue, Raw: p.name(), Loc: tok.loc}
+	case p.peek("["):
+		p.next()
+		list := &Value{Kind: ListValue, Loc: tok.loc}
+		for !p.skip("]") {
+			list.List = append(list.List, p.value(constant))
+		}
+		return list
+	case p.peek("{"):
+		p.next()
+		object := &Value{Kind: ObjectValue, Loc: tok.loc}
+		for !p.skip("}") {
+			name := p.name()
+			p.expect(":")
+			object.Fields = append(object.Fields, &ObjectField{Name: name, Value: p.value(constant)})
+		}
+		return object
+	}
+
+	p.next()
+	switch tok.kind {
+	case tokenInt:
+		return &Value{Kind: IntValue, Raw: tok.value, Loc: tok.loc}
+	case tokenFloat:
+		return &Value{Kind: FloatValue, Raw: tok.value, Loc: tok.loc}
+	case tokenString:
+		return &Value{Kind: StringValue, Raw: tok.value, Loc: tok.loc}
+	case tokenName:
+		switch tok.value {
+		case "true", "false":
+			return &Value{Kind: BooleanValue, Raw: tok.value, Loc: tok.loc}
+		case "null":
+			return &Value{Kind: NullValue, Loc: tok.loc}
+		default:
+			return &Value{Kind: EnumValue, Raw: tok.value, Loc: tok.loc}
+		}
+	}
+
+	p.token = tok
+	p.fail(tok.loc, "expected a value, found %s", p.describe())
+	return nil
+}
@@ -0,0 +1,171 @@
+package graphql
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseDocument(t *testing.T) {
+	doc, err := Parse(`
+		# the movies of a page, with a fragment
+		query Movies($page: Int = 1, $genres: [String!]!) @skip(if: false) {
+			top: movies(page: $page, genres: $genres, filter: {year: 1999, title: "The \"Matrix\""}) {
+				...movieFields
+				... on Movie @include(if: true) { year }
+			}
+		}
+
+		fragment movieFields on Movie {
+			id
+			title
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Operations) != 1 {
+		t.Fatalf("got %d operations, want 1", len(doc.Operations))
+	}
+	op := doc.Operations[0]
+	if op.Type != "query" || op.Name != "Movies" {
+		t.Errorf("got %s %s, want query Movies", op.Type, op.Name)
+	}
+	if op.Loc != (Location{Line: 3, Column: 3}) {
+		t.Errorf("got the operation at %v, want 3:3", op.Loc)
+	}
+
+	if len(op.Variables) != 2 {
+		t.Fatalf("got %d variables, want 2", len(op.Variables))
+	}
+	if v := op.Variables[0]; v.Name != "page" || v.Type.String() != "Int" || v.Default == nil || v.Default.Kind != IntValue || v.Default.Raw != "1" {
+		t.Errorf("got $page %s = %v", v.Type, v.Default)
+	}
+	if v := op.Variables[1]; v.Name != "genres" || v.Type.String() != "[String!]!" || v.Default != nil {
+		t.Errorf("got $genres %s", v.Type)
+	}
+	if len(op.Directives) != 1 || op.Directives[0].Name != "skip" {
+		t.Errorf("got directives %v, want @skip", op.Directives)
+	}
+
+	field, ok := op.SelectionSet[0].(*Field)
+	if !ok {
+		t.Fatalf("got a %T, want a field", op.SelectionSet[0])
+	}
+	if field.Name != "movies" || field.Alias != "top" || field.ResponseKey() != "top" {
+		t.Errorf("got field %s aliased %s", field.Name, field.Alias)
+	}
+	if len(field.Arguments) != 3 {
+		t.Fatalf("got %d arguments, want 3", len(field.Arguments))
+	}
+	if arg := field.Arguments[0]; arg.Name != "page" || arg.Value.Kind != VariableValue || arg.Value.Raw != "page" {
+		t.Errorf("got argument %s: %v", arg.Name, arg.Value)
+	}
+	filter := field.Arguments[2].Value
+	if filter.Kind != ObjectValue || len(filter.Fields) != 2 {
+		t.Fatalf("got filter %v, want an object of 2 fields", filter)
+	}
+	if title := filter.Fields[1].Value; title.Kind != StringValue || title.Raw != `The "Matrix"` {
+		t.Errorf("got title %q, want the unescaped string", title.Raw)
+	}
+
+	if spread, ok := field.SelectionSet[0].(*FragmentSpread); !ok || spread.Name != "movieFields" {
+		t.Errorf("got %#v, want a spread of movieFields", field.SelectionSet[0])
+	}
+	inline, ok := field.SelectionSet[1].(*InlineFragment)
+	if !ok || inline.TypeCondition != "Movie" || len(inline.Directives) != 1 || len(inline.SelectionSet) != 1 {
+		t.Errorf("got %#v, want an inline fragment on Movie", field.SelectionSet[1])
+	}
+
+	fragment := doc.Fragments["movieFields"]
+	if fragment == nil || fragment.TypeCondition != "Movie" || len(fragment.SelectionSet) != 2 {
+		t.Errorf("got fragment %#v", fragment)
+	}
+}
+
+func TestParseShorthandQuery(t *testing.T) {
+	doc, err := Parse(`{ movie(id: 1) { title } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op := doc.Operations[0]; op.Type != "query" || op.Name != "" {
+		t.Errorf("got %s %q, want an anonymous query", op.Type, op.Name)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	tests := []struct {
+		literal string
+		kind    ValueKind
+		raw     string
+	}{
+		{`-12`, IntValue, "-12"},
+		{`0`, IntValue, "0"},
+		{`1.5e3`, FloatValue, "1.5e3"},
+		{`"café"`, StringValue, "café"},
+		{`"tab\there"`, StringValue, "tab\there"},
+		{`"""
+			block
+			  string
+		"""`, StringValue, "block\n  string"},
+		{`true`, BooleanValue, "true"},
+		{`null`, NullValue, ""},
+		{`DRAMA`, EnumValue, "DRAMA"},
+	}
+
+	for _, tt := range tests {
+		doc, err := Parse(`{ f(a: ` + tt.literal + `) }`)
+		if err != nil {
+			t.Errorf("%s: %v", tt.literal, err)
+			continue
+		}
+		value := doc.Operations[0].SelectionSet[0].(*Field).Arguments[0].Value
+		if value.Kind != tt.kind || value.Raw != tt.raw {
+			t.Errorf("%s: got kind %d %q, want kind %d %q", tt.literal, value.Kind, value.Raw, tt.kind, tt.raw)
+		}
+	}
+
+	doc, err := Parse(`{ f(a: [1, [2], {b: $c}]) }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := doc.Operations[0].SelectionSet[0].(*Field).Arguments[0].Value
+	if list.Kind != ListValue || len(list.List) != 3 || list.List[1].Kind != ListValue || list.List[2].Fields[0].Value.Kind != VariableValue {
+		t.Errorf("got %v, want a nested list", list)
+	}
+}
+
+func TestParseSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		loc   Location
+	}{
+		{`{ movie `, Location{1, 9}},
+		{`{ movie }}`, Location{1, 10}},
+		{`query { movie(id: ) }`, Location{1, 19}},
+		{`{ movie(id: "unterminated) }`, Location{1, 13}},
+		{"{\n  movie(id: 1.) }", Location{2, 13}},
+		{`{ movie(id: $) }`, Location{1, 14}},
+		{`{ ...on }`, Location{1, 9}},
+		{`fragment on on Movie { id }`, Location{1, 10}},
+		{`subscription`, Location{1, 13}},
+		{``, Location{1, 1}},
+		{`{ movie(id: 1) { title } ¿ }`, Location{1, 26}},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%q: got %v, want a syntax error", tt.query, err)
+			continue
+		}
+		if syntaxErr.Loc != tt.loc {
+			t.Errorf("%q: got %v at %v, want it at %v", tt.query, syntaxErr.Message, syntaxErr.Loc, tt.loc)
+		}
+		if !strings.HasPrefix(err.Error(), "syntax error at ") {
+			t.Errorf("%q: got %q", tt.query, err)
+		}
+	}
+}
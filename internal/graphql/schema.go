@@ -0,0 +1,352 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Type is a *Scalar, *Enum, *Object, *List or *NonNull
+type Type interface {
+	String() string
+}
+
+// namedType is a type declared in the schema, as opposed to the List and NonNull wrappers
+type namedType interface {
+	Type
+	typeName() string
+	kind() string
+}
+
+// Scalar is a leaf type. Serialize turns what resolvers return into the JSON value of the result,
+// and Parse turns an input value, as decoded from JSON or read from a literal, into the value
+// resolvers are given.
+type Scalar struct {
+	Name        string
+	Description string
+	Serialize   func(v any) (any, error)
+	Parse       func(v any) (any, error)
+}
+
+// Enum is a leaf type taking one of its values, which resolvers see as strings
+type Enum struct {
+	Name        string
+	Description string
+	Values      []string
+}
+
+// Object is a type with fields
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*FieldDef
+
+	fields map[string]*FieldDef
+}
+
+// List is a list of its element type
+type List struct {
+	Of Type
+}
+
+// NonNull is its type without null
+type NonNull struct {
+	Of Type
+}
+
+func (t *Scalar) String() string  { return t.Name }
+func (t *Enum) String() string    { return t.Name }
+func (t *Object) String() string  { return t.Name }
+func (t *List) String() string    { return "[" + t.Of.String() + "]" }
+func (t *NonNull) String() string { return t.Of.String() + "!" }
+
+func (t *Scalar) typeName() string { return t.Name }
+func (t *Enum) typeName() string   { return t.Name }
+func (t *Object) typeName() string { return t.Name }
+
+func (t *Scalar) kind() string { return "SCALAR" }
+func (t *Enum) kind() string   { return "ENUM" }
+func (t *Object) kind() string { return "OBJECT" }
+
+// Field returns the field of the object with the name, or nil
+func (t *Object) Field(name string) *FieldDef {
+	return t.fields[name]
+}
+
+// ResolveFunc returns the value of a field of source, the value its parent field resolved to.
+// It may return a Thunk to have the value computed later, so that a Loader can batch it with
+// those of the sibling objects.
+type ResolveFunc func(ctx context.Context, source any, args map[string]any) (any, error)
+
+// FieldDef is a field of an object type
+type FieldDef struct {
+	Name        string
+	Description string
+	Type        Type
+	Args        []*ArgDef
+	Resolve     ResolveFunc
+	// Complexity is the cost of selecting the field given its arguments and the cost of its
+	// selections, 1 plus that cost when nil. Fields returning lists multiply the cost of their
+	// selections by the number of items they may return.
+	Complexity func(args map[string]any, childComplexity int) int
+}
+
+// ArgDef is an argument of a field, whose Default is used when it is not given
+type ArgDef struct {
+	Name        string
+	Description string
+	Type        Type
+	Default     any
+}
+
+// Thunk is a value to be computed once all the sibling fields have been resolved
+type Thunk func() (any, error)
+
+// Schema is the types a query runs against, starting at the fields of Query
+type Schema struct {
+	Query *Object
+
+	types       map[string]namedType
+	schemaField *FieldDef
+	typeField   *FieldDef
+}
+
+// NewSchema checks the types reachable from query and indexes their fields
+func NewSchema(query *Object) (*Schema, error) {
+	s := &Schema{Query: query, types: make(map[string]namedType)}
+
+	for _, t := range []namedType{Int, Float, String, Boolean, ID} {
+		s.types[t.typeName()] = t
+	}
+	if err := s.add(query); err != nil {
+		return nil, err
+	}
+	if err := s.add(introspectionSchema); err != nil {
+		return nil, err
+	}
+	s.introspectionFields()
+	return s, nil
+}
+
+func (s *Schema) add(t Type) error {
+	for {
+		switch wrapper := t.(type) {
+		case *List:
+			t = wrapper.Of
+			continue
+		case *NonNull:
+			t = wrapper.Of
+			continue
+		}
+		break
+	}
+
+	named := t.(namedType)
+	if existing, ok := s.types[named.typeName()]; ok {
+		if existing != named {
+			return fmt.Errorf("graphql: two types are named %s", named.typeName())
+		}
+		return nil
+	}
+	s.types[named.typeName()] = named
+
+	object, ok := named.(*Object)
+	if !ok {
+		return nil
+	}
+	object.fields = make(map[string]*FieldDef, len(object.Fields))
+	for _, field := range object.Fields {
+		if _, ok := object.fields[field.Name]; ok {
+			return fmt.Errorf("graphql: %s has two fields named %s", object.Name, field.Name)
+		}
+		object.fields[field.Name] = field
+
+		if err := s.add(field.Type); err != nil {
+			return err
+		}
+		for _, arg := range field.Args {
+			if err := s.add(arg.Type); err != nil {
+				return err
+			}
+			if _, ok := unwrap(arg.Type).(*Object); ok {
+				return fmt.Errorf("graphql: argument %s of %s.%s must be of an input type", arg.Name, object.Name, field.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// unwrap returns the named type under any List and NonNull wrappers
+func unwrap(t Type) namedType {
+	for {
+		switch wrapper := t.(type) {
+		case *List:
+			t = wrapper.Of
+		case *NonNull:
+			t = wrapper.Of
+		default:
+			return t.(namedType)
+		}
+	}
+}
+
+// Type returns the type of the schema with the name, or nil
+func (s *Schema) Type(name string) Type {
+	t, ok := s.types[name]
+	if !ok {
+		return nil
+	}
+	return t
+}
+
+func coercionError(t Type, v any) error {
+	return fmt.Errorf("%s cannot represent %s", t, describeValue(v))
+}
+
+func describeValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// toInt64 returns integers of any Go type, and floats without a fraction such as those JSON
+// numbers are decoded into
+func toInt64(v any) (int64, bool) {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := value.Float()
+		if f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	return 0, false
+}
+
+func toFloat64(v any) (float64, bool) {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), !math.IsNaN(value.Float()) && !math.IsInf(value.Float(), 0)
+	}
+	i, ok := toInt64(v)
+	return float64(i), ok
+}
+
+// the built-in scalars. Int is 32 bits wide as the spec asks, and IDs are serialized as strings.
+var (
+	Int = &Scalar{
+		Name:        "Int",
+		Description: "A signed 32-bit integer.",
+		Serialize: func(v any) (any, error) {
+			i, ok := toInt64(v)
+			if !ok || i < math.MinInt32 || i > math.MaxInt32 {
+				return nil, fmt.Errorf("Int cannot represent %s", describeValue(v))
+			}
+			return i, nil
+		},
+	}
+	Float = &Scalar{
+		Name:        "Float",
+		Description: "A double-precision floating-point number.",
+		Serialize: func(v any) (any, error) {
+			f, ok := toFloat64(v)
+			if !ok {
+				return nil, fmt.Errorf("Float cannot represent %s", describeValue(v))
+			}
+			return f, nil
+		},
+	}
+	String = &Scalar{
+		Name:        "String",
+		Description: "A UTF-8 character sequence.",
+		Serialize: func(v any) (any, error) {
+			switch v := v.(type) {
+			case string:
+				return v, nil
+			case fmt.Stringer:
+				return v.String(), nil
+			}
+			return nil, fmt.Errorf("String cannot represent %s", describeValue(v))
+		},
+	}
+	Boolean = &Scalar{
+		Name:        "Boolean",
+		Description: "true or false.",
+		Serialize: func(v any) (any, error) {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("Boolean cannot represent %s", describeValue(v))
+			}
+			return b, nil
+		},
+	}
+	ID = &Scalar{
+		Name:        "ID",
+		Description: "A unique identifier, serialized as a string.",
+		Serialize: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if i, ok := toInt64(v); ok {
+				return strconv.FormatInt(i, 10), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent %s", describeValue(v))
+		},
+	}
+)
+
+func init() {
+	Int.Parse = func(v any) (any, error) {
+		i, ok := toInt64(v)
+		if !ok || i < math.MinInt32 || i > math.MaxInt32 {
+			return nil, coercionError(Int, v)
+		}
+		return int(i), nil
+	}
+	Float.Parse = func(v any) (any, error) {
+		f, ok := toFloat64(v)
+		if !ok {
+			return nil, coercionError(Float, v)
+		}
+		return f, nil
+	}
+	String.Parse = func(v any) (any, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, coercionError(String, v)
+		}
+		return s, nil
+	}
+	Boolean.Parse = func(v any) (any, error) {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, coercionError(Boolean, v)
+		}
+		return b, nil
+	}
+	ID.Parse = func(v any) (any, error) {
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		if i, ok := toInt64(v); ok {
+			return strconv.FormatInt(i, 10), nil
+		}
+		return nil, coercionError(ID, v)
+	}
+}
@@ -0,0 +1,394 @@
+package graphql
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// prepare selects the operation to run, coerces its variables and validates it against the
+// schema and the limits, reporting what is wrong. It returns false when the operation cannot run.
+func (e *executor) prepare(req Request) bool {
+	if req.OperationName == "" && len(e.doc.Operations) > 1 {
+		e.report("the operation name must be given when the query has several operations")
+		return false
+	}
+	for _, op := range e.doc.Operations {
+		if req.OperationName == "" || op.Name == req.OperationName {
+			e.operation = op
+			break
+		}
+	}
+	if e.operation == nil {
+		e.report(fmt.Sprintf("the query has no operation named %q", req.OperationName))
+		return false
+	}
+	if e.operation.Type != "query" {
+		e.report(fmt.Sprintf("%s operations are not supported", e.operation.Type), e.operation.Loc)
+		return false
+	}
+
+	if !e.coerceVariables(req.Variables) {
+		return false
+	}
+
+	e.validated = make(map[string]bool)
+	e.checkFragmentCycles()
+	e.validateDirectives(e.operation.Directives)
+	e.validateSelections(e.schema.Query, e.operation.SelectionSet)
+	if len(e.errors) > 0 {
+		return false
+	}
+
+	e.checkLimits()
+	return len(e.errors) == 0
+}
+
+// coerceVariables checks the operation's variable definitions and the values given for them
+func (e *executor) coerceVariables(values map[string]any) bool {
+	e.varTypes = make(map[string]Type)
+	e.variables = make(map[string]any)
+
+	for _, def := range e.operation.Variables {
+		if _, ok := e.varTypes[def.Name]; ok {
+			e.report(fmt.Sprintf("there can be only one variable named $%s", def.Name), def.Loc)
+			continue
+		}
+
+		t := e.schema.typeOf(def.Type)
+		if t == nil || !isInputType(t) {
+			e.report(fmt.Sprintf("variable $%s cannot be of the non-input type %s", def.Name, def.Type), def.Loc)
+			continue
+		}
+		e.varTypes[def.Name] = t
+
+		value, given := values[def.Name]
+		if !given && def.Default != nil {
+			coerced, err := coerceLiteral(t, def.Default, nil)
+			if err != nil {
+				e.report(fmt.Sprintf("variable $%s has an invalid default value: %s", def.Name, err), def.Loc)
+				continue
+			}
+			e.variables[def.Name] = coerced
+			continue
+		}
+		if !given {
+			if _, ok := t.(*NonNull); ok {
+				e.report(fmt.Sprintf("variable $%s of required type %s was not provided", def.Name, t), def.Loc)
+			}
+			continue
+		}
+
+		coerced, err := coerceVariable(t, value)
+		if err != nil {
+			e.report(fmt.Sprintf("variable $%s got an invalid value: %s", def.Name, err), def.Loc)
+			continue
+		}
+		e.variables[def.Name] = coerced
+	}
+	return len(e.errors) == 0
+}
+
+// checkFragmentCycles reports fragments that spread themselves, directly or through others
+func (e *executor) checkFragmentCycles() {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+
+	var visit func(name string, selections []Selection)
+	var spreads func(selections []Selection)
+
+	spreads = func(selections []Selection) {
+		for _, selection := range selections {
+			switch selection := selection.(type) {
+			case *Field:
+				spreads(selection.SelectionSet)
+			case *InlineFragment:
+				spreads(selection.SelectionSet)
+			case *FragmentSpread:
+				fragment := e.doc.Fragments[selection.Name]
+				if fragment == nil {
+					continue
+				}
+				if state[selection.Name] == visiting {
+					e.report(fmt.Sprintf("fragment %q cannot spread itself", selection.Name), selection.Loc)
+					continue
+				}
+				visit(selection.Name, fragment.SelectionSet)
+			}
+		}
+	}
+	visit = func(name string, selections []Selection) {
+		if state[name] != 0 {
+			return
+		}
+		state[name] = visiting
+		spreads(selections)
+		state[name] = done
+	}
+
+	for name, fragment := range e.doc.Fragments {
+		visit(name, fragment.SelectionSet)
+	}
+}
+
+func (e *executor) validateDirectives(directives []*Directive) {
+	for _, directive := range directives {
+		if directive.Name != "skip" && directive.Name != "include" {
+			e.report(fmt.Sprintf("unknown directive \"@%s\"", directive.Name), directive.Loc)
+			continue
+		}
+		e.validateArguments("@"+directive.Name, directiveArgs, directive.Arguments, directive.Loc)
+	}
+}
+
+// directiveArgs are the arguments of @skip and @include
+var directiveArgs = []*ArgDef{{Name: "if", Type: &NonNull{Of: Boolean}}}
+
+func (e *executor) validateArguments(owner string, defs []*ArgDef, args []*Argument, loc Location) {
+	given := make(map[string]bool)
+	for _, arg := range args {
+		if given[arg.Name] {
+			e.report(fmt.Sprintf("there can be only one argument named %q", arg.Name), arg.Loc)
+			continue
+		}
+		given[arg.Name] = true
+
+		var def *ArgDef
+		for _, d := range defs {
+			if d.Name == arg.Name {
+				def = d
+			}
+		}
+		if def == nil {
+			e.report(fmt.Sprintf("unknown argument %q on %s", arg.Name, owner), arg.Loc)
+			continue
+		}
+
+		if arg.Value.Kind == VariableValue {
+			varType, ok := e.varTypes[arg.Value.Raw]
+			if !ok {
+				e.report(fmt.Sprintf("variable $%s is not defined", arg.Value.Raw), arg.Value.Loc)
+				continue
+			}
+			if !variableFits(varType, def.Type, def.Default != nil || e.hasDefault(arg.Value.Raw)) {
+				e.report(fmt.Sprintf("variable $%s of type %s cannot be used for argument %q of type %s", arg.Value.Raw, varType, arg.Name, def.Type), arg.Value.Loc)
+			}
+			continue
+		}
+		if variable := findVariable(arg.Value); variable != nil {
+			if _, ok := e.varTypes[variable.Raw]; !ok {
+				e.report(fmt.Sprintf("variable $%s is not defined", variable.Raw), variable.Loc)
+				continue
+			}
+		}
+		if _, err := coerceLiteral(def.Type, arg.Value, e.variables); err != nil {
+			e.report(fmt.Sprintf("argument %q has an invalid value: %s", arg.Name, err), arg.Value.Loc)
+		}
+	}
+
+	for _, def := range defs {
+		if _, ok := def.Type.(*NonNull); ok && def.Default == nil && !given[def.Name] {
+			e.report(fmt.Sprintf("%s requires the argument %q of type %s", owner, def.Name, def.Type), loc)
+		}
+	}
+}
+
+func (e *executor) hasDefault(variable string) bool {
+	for _, def := range e.operation.Variables {
+		if def.Name == variable {
+			return def.Default != nil && def.Default.Kind != NullValue
+		}
+	}
+	return false
+}
+
+// findVariable returns a variable nested in a list or object value
+func findVariable(v *Value) *Value {
+	if v.Kind == VariableValue {
+		return v
+	}
+	for _, item := range v.List {
+		if variable := findVariable(item); variable != nil {
+			return variable
+		}
+	}
+	for _, field := range v.Fields {
+		if variable := findVariable(field.Value); variable != nil {
+			return variable
+		}
+	}
+	return nil
+}
+
+// validateSelections checks that the selections exist on the type, with valid arguments.
+// Fragments are checked once, where they are first spread.
+func (e *executor) validateSelections(t *Object, selections []Selection) {
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *Field:
+			e.validateDirectives(selection.Directives)
+
+			def := e.fieldDef(t, selection.Name)
+			if def == nil {
+				e.report(fmt.Sprintf("cannot query field %q on type %q", selection.Name, t.Name), selection.Loc)
+				continue
+			}
+			e.validateArguments(fmt.Sprintf("field %q", selection.Name), def.Args, selection.Arguments, selection.Loc)
+
+			object, isObject := unwrap(def.Type).(*Object)
+			switch {
+			case isObject && len(selection.SelectionSet) == 0:
+				e.report(fmt.Sprintf("field %q of type %s must have a selection of subfields", selection.Name, def.Type), selection.Loc)
+			case !isObject && len(selection.SelectionSet) > 0:
+				e.report(fmt.Sprintf("field %q must not have a selection since type %s has no subfields", selection.Name, def.Type), selection.Loc)
+			case isObject:
+				e.validateSelections(object, selection.SelectionSet)
+			}
+
+		case *FragmentSpread:
+			e.validateDirectives(selection.Directives)
+
+			fragment := e.doc.Fragments[selection.Name]
+			if fragment == nil {
+				e.report(fmt.Sprintf("unknown fragment %q", selection.Name), selection.Loc)
+				continue
+			}
+			if !e.fitsType(t, fragment.TypeCondition, fragment.Loc) {
+				continue
+			}
+			if fragment.TypeCondition != t.Name {
+				e.report(fmt.Sprintf("fragment %q cannot be spread here as objects of type %q can never be of type %q", selection.Name, t.Name, fragment.TypeCondition), selection.Loc)
+				continue
+			}
+			if !e.validated[selection.Name] {
+				e.validated[selection.Name] = true
+				e.validateDirectives(fragment.Directives)
+				e.validateSelections(t, fragment.SelectionSet)
+			}
+
+		case *InlineFragment:
+			e.validateDirectives(selection.Directives)
+
+			if selection.TypeCondition != "" {
+				if !e.fitsType(t, selection.TypeCondition, selection.Loc) {
+					continue
+				}
+				if selection.TypeCondition != t.Name {
+					e.report(fmt.Sprintf("a fragment on %q cannot be spread here as objects of type %q can never be of type %q", selection.TypeCondition, t.Name, selection.TypeCondition), selection.Loc)
+					continue
+				}
+			}
+			e.validateSelections(t, selection.SelectionSet)
+		}
+	}
+}
+
+// fitsType reports an unknown type condition, or one that is not an object type
+func (e *executor) fitsType(t *Object, condition string, loc Location) bool {
+	named, ok := e.schema.types[condition]
+	if !ok {
+		e.report(fmt.Sprintf("unknown type %q", condition), loc)
+		return false
+	}
+	if _, ok := named.(*Object); !ok {
+		e.report(fmt.Sprintf("a fragment cannot be on the non-object type %q", condition), loc)
+		return false
+	}
+	return true
+}
+
+// checkLimits reports fields selected under one response key that cannot be merged, and queries
+// deeper or more complex than the options allow. Introspection fields count toward neither limit.
+func (e *executor) checkLimits() {
+	depth, complexity := e.cost(e.schema.Query, e.operation.SelectionSet, 1)
+	if len(e.errors) > 0 {
+		return
+	}
+
+	if e.opts.MaxDepth > 0 && depth > e.opts.MaxDepth {
+		e.report(fmt.Sprintf("the query has a depth of %d, which exceeds the maximum of %d", depth, e.opts.MaxDepth), e.operation.Loc)
+	}
+	if e.opts.MaxComplexity > 0 && complexity > e.opts.MaxComplexity {
+		e.report(fmt.Sprintf("the query has a complexity of %d, which exceeds the maximum of %d", complexity, e.opts.MaxComplexity), e.operation.Loc)
+	}
+}
+
+// cost returns the depth and complexity of the selections made at the given depth. It stops
+// adding up once the query is over a limit, so a query that expands to a huge one through
+// fragments is turned down quickly.
+func (e *executor) cost(t *Object, selections []Selection, depth int) (maxDepth, complexity int) {
+	maxDepth = depth - 1
+
+	for _, group := range e.collectFields(t, selections, nil, make(map[string]bool)) {
+		first := group.fields[0]
+		if !e.mergeable(group) {
+			continue
+		}
+		if strings.HasPrefix(first.Name, "__") {
+			continue
+		}
+
+		def := t.Field(first.Name)
+		childDepth, childComplexity := depth, 0
+		if object, ok := unwrap(def.Type).(*Object); ok {
+			var subselections []Selection
+			for _, field := range group.fields {
+				subselections = append(subselections, field.SelectionSet...)
+			}
+			childDepth, childComplexity = e.cost(object, subselections, depth+1)
+		}
+
+		fieldComplexity := 1 + childComplexity
+		if def.Complexity != nil {
+			fieldComplexity = def.Complexity(e.arguments(def, first), childComplexity)
+		}
+
+		maxDepth = max(maxDepth, childDepth)
+		complexity += fieldComplexity
+		if e.overLimits(maxDepth, complexity) {
+			break
+		}
+	}
+	return maxDepth, complexity
+}
+
+func (e *executor) overLimits(depth, complexity int) bool {
+	return (e.opts.MaxDepth > 0 && depth > e.opts.MaxDepth) ||
+		(e.opts.MaxComplexity > 0 && complexity > e.opts.MaxComplexity)
+}
+
+// mergeable reports fields under one response key that are different fields, or the same field
+// with different arguments
+func (e *executor) mergeable(group *fieldGroup) bool {
+	first := group.fields[0]
+	for _, field := range group.fields[1:] {
+		if field.Name != first.Name {
+			e.report(fmt.Sprintf("fields %q conflict because %q and %q are different fields", group.key, first.Name, field.Name), first.Loc, field.Loc)
+			return false
+		}
+		if argumentsString(field.Arguments) != argumentsString(first.Arguments) {
+			e.report(fmt.Sprintf("fields %q conflict because they have different arguments", group.key), first.Loc, field.Loc)
+			return false
+		}
+	}
+	return true
+}
+
+func argumentsString(args []*Argument) string {
+	values := make(map[string]string, len(args))
+	var names []string
+	for _, arg := range args {
+		values[arg.Name] = arg.Value.String()
+		names = append(names, arg.Name)
+	}
+
+	slices.Sort(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + values[name] + ",")
+	}
+	return b.String()
+}
@@ -0,0 +1,209 @@
+package graphql
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// isInputType reports whether values of the type can be given as arguments and variables
+func isInputType(t Type) bool {
+	switch unwrap(t).(type) {
+	case *Scalar, *Enum:
+		return true
+	}
+	return false
+}
+
+// isLeafType reports whether the type is a scalar or enum, which cannot have selections
+func isLeafType(t Type) bool {
+	return isInputType(t)
+}
+
+// typeOf returns the schema type a query names, or nil when there is no such type
+func (s *Schema) typeOf(ref *TypeRef) Type {
+	var t Type
+	if ref.Elem != nil {
+		elem := s.typeOf(ref.Elem)
+		if elem == nil {
+			return nil
+		}
+		t = &List{Of: elem}
+	} else {
+		named, ok := s.types[ref.Name]
+		if !ok {
+			return nil
+		}
+		t = named
+	}
+	if ref.NonNull {
+		t = &NonNull{Of: t}
+	}
+	return t
+}
+
+// coerceVariable turns a variable's value, as decoded from JSON, into a value of the type
+func coerceVariable(t Type, v any) (any, error) {
+	if nonNull, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, fmt.Errorf("expected a value of type %s, found null", t)
+		}
+		return coerceVariable(nonNull.Of, v)
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		value := reflect.ValueOf(v)
+		if value.Kind() != reflect.Slice {
+			item, err := coerceVariable(t.Of, v)
+			if err != nil {
+				return nil, err
+			}
+			return []any{item}, nil
+		}
+		list := make([]any, value.Len())
+		for i := range list {
+			item, err := coerceVariable(t.Of, value.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("at index %d: %w", i, err)
+			}
+			list[i] = item
+		}
+		return list, nil
+	case *Enum:
+		s, ok := v.(string)
+		if !ok || !slices.Contains(t.Values, s) {
+			return nil, fmt.Errorf("%s is not a value of %s", describeValue(v), t)
+		}
+		return s, nil
+	case *Scalar:
+		return t.Parse(v)
+	}
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+// coerceLiteral turns a value written in the query into a value of the type. Variables are taken
+// from vars, which hold values already coerced to the variables' types.
+func coerceLiteral(t Type, v *Value, vars map[string]any) (any, error) {
+	if v.Kind == VariableValue {
+		return vars[v.Raw], nil
+	}
+
+	if nonNull, ok := t.(*NonNull); ok {
+		if v.Kind == NullValue {
+			return nil, fmt.Errorf("expected a value of type %s, found null", t)
+		}
+		return coerceLiteral(nonNull.Of, v, vars)
+	}
+	if v.Kind == NullValue {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		if v.Kind != ListValue {
+			item, err := coerceLiteral(t.Of, v, vars)
+			if err != nil {
+				return nil, err
+			}
+			return []any{item}, nil
+		}
+		list := make([]any, len(v.List))
+		for i, item := range v.List {
+			value, err := coerceLiteral(t.Of, item, vars)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return list, nil
+	case *Enum:
+		if v.Kind != EnumValue || !slices.Contains(t.Values, v.Raw) {
+			return nil, fmt.Errorf("%s is not a value of %s", v, t)
+		}
+		return v.Raw, nil
+	case *Scalar:
+		var value any
+		switch v.Kind {
+		case IntValue:
+			if t == Float {
+				f, _ := strconv.ParseFloat(v.Raw, 64)
+				value = f
+				break
+			}
+			i, err := strconv.ParseInt(v.Raw, 10, 64)
+			if err != nil {
+				return nil, coercionError(t, v.Raw)
+			}
+			value = i
+		case FloatValue:
+			if t != Float {
+				return nil, fmt.Errorf("%s cannot represent %s", t, v)
+			}
+			f, _ := strconv.ParseFloat(v.Raw, 64)
+			value = f
+		case StringValue:
+			value = v.Raw
+		case BooleanValue:
+			value = v.Raw == "true"
+		default:
+			return nil, fmt.Errorf("%s cannot represent %s", t, v)
+		}
+		return t.Parse(value)
+	}
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+// String writes the value as it would appear in a query
+func (v *Value) String() string {
+	switch v.Kind {
+	case VariableValue:
+		return "$" + v.Raw
+	case StringValue:
+		return strconv.Quote(v.Raw)
+	case NullValue:
+		return "null"
+	case ListValue:
+		items := make([]string, len(v.List))
+		for i, item := range v.List {
+			items[i] = item.String()
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case ObjectValue:
+		fields := make([]string, len(v.Fields))
+		for i, field := range v.Fields {
+			fields[i] = field.Name + ": " + field.Value.String()
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	default:
+		return v.Raw
+	}
+}
+
+// variableFits reports whether a variable of type varType may be used where a value of type
+// locType is expected. hasDefault is true when either the variable or the location has a default
+// that stands in for null.
+func variableFits(varType, locType Type, hasDefault bool) bool {
+	if locNonNull, ok := locType.(*NonNull); ok {
+		if varNonNull, ok := varType.(*NonNull); ok {
+			return variableFits(varNonNull.Of, locNonNull.Of, false)
+		}
+		return hasDefault && variableFits(varType, locNonNull.Of, false)
+	}
+	if varNonNull, ok := varType.(*NonNull); ok {
+		return variableFits(varNonNull.Of, locType, false)
+	}
+	if locList, ok := locType.(*List); ok {
+		varList, ok := varType.(*List)
+		return ok && variableFits(varList.Of, locList.Of, false)
+	}
+	if _, ok := varType.(*List); ok {
+		return false
+	}
+	return unwrap(varType) == unwrap(locType)
+}
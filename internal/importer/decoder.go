@@ -0,0 +1,185 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported import format")
+
+// maximum size of a single NDJSON line
+const maxLineBytes = 1_048_576
+
+// RowError is returned by Decoder.Next when a single row could not be parsed.
+// The decoder can keep going after it.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// Decoder streams rows out of an import file one at a time
+type Decoder interface {
+	// Next returns io.EOF after the last row
+	Next() (store.ImportRow, error)
+}
+
+func NewDecoder(format string, r io.Reader) (Decoder, error) {
+	switch format {
+	case store.ImportFormatCSV:
+		return newCSVDecoder(r)
+	case store.ImportFormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+		return &ndjsonDecoder{scanner: scanner}, nil
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// csvDecoder reads a CSV file with a header row. The title, year, runtime and genres columns are required,
+// source and external_id are optional. Genres are separated by "|" and the runtime is given in minutes.
+type csvDecoder struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+var csvRequiredColumns = []string{"title", "year", "runtime", "genres"}
+
+func newCSVDecoder(r io.Reader) (*csvDecoder, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("csv file must have a header row")
+		}
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvRequiredColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header is missing the %q column", name)
+		}
+	}
+
+	return &csvDecoder{reader: reader, columns: columns}, nil
+}
+
+func (d *csvDecoder) field(record []string, name string) string {
+	i, ok := d.columns[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+func (d *csvDecoder) Next() (store.ImportRow, error) {
+	record, err := d.reader.Read()
+	if err != nil {
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return store.ImportRow{}, &RowError{Line: parseError.StartLine, Err: parseError.Err}
+		}
+		return store.ImportRow{}, err
+	}
+	line, _ := d.reader.FieldPos(0)
+
+	row := store.ImportRow{
+		Line:       line,
+		Source:     d.field(record, "source"),
+		ExternalID: d.field(record, "external_id"),
+	}
+	row.Movie.Title = d.field(record, "title")
+
+	if s := d.field(record, "year"); s != "" {
+		year, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return row, &RowError{Line: line, Err: errors.New("year must be an integer value")}
+		}
+		row.Movie.Year = int32(year)
+	}
+
+	if s := strings.TrimSuffix(d.field(record, "runtime"), " mins"); s != "" {
+		runtime, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return row, &RowError{Line: line, Err: store.ErrInvalidRuntimeFormat}
+		}
+		row.Movie.Runtime = store.Runtime(runtime)
+	}
+
+	if s := d.field(record, "genres"); s != "" {
+		for _, genre := range strings.Split(s, "|") {
+			row.Movie.Genres = append(row.Movie.Genres, strings.TrimSpace(genre))
+		}
+	}
+
+	return row, nil
+}
+
+// ndjsonDecoder reads one JSON movie object per line, in the same shape POST /v1/movies accepts,
+// with optional source and external_id keys. Blank lines are ignored.
+type ndjsonDecoder struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (d *ndjsonDecoder) Next() (store.ImportRow, error) {
+	for d.scanner.Scan() {
+		d.line++
+
+		data := d.scanner.Bytes()
+		if len(strings.TrimSpace(string(data))) == 0 {
+			continue
+		}
+
+		var payload struct {
+			Title      string        `json:"title"`
+			Year       int32         `json:"year"`
+			Runtime    store.Runtime `json:"runtime"`
+			Genres     []string      `json:"genres"`
+			Source     string        `json:"source"`
+			ExternalID string        `json:"external_id"`
+		}
+		row := store.ImportRow{Line: d.line}
+
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return row, &RowError{Line: d.line, Err: err}
+		}
+
+		row.Source = payload.Source
+		row.ExternalID = payload.ExternalID
+		row.Movie = store.Movie{
+			Title:   payload.Title,
+			Year:    payload.Year,
+			Runtime: payload.Runtime,
+			Genres:  payload.Genres,
+		}
+		return row, nil
+	}
+
+	if err := d.scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return store.ImportRow{}, fmt.Errorf("line %d is longer than %d bytes", d.line+1, maxLineBytes)
+		}
+		return store.ImportRow{}, err
+	}
+	return store.ImportRow{}, io.EOF
+}
@@ -0,0 +1,182 @@
+package importer
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+// decoded is a row or the error of the row at a line
+type decoded struct {
+	row store.ImportRow
+	err string
+}
+
+// decodeAll reads every row of the input, going on after the errors of single rows
+func decodeAll(t *testing.T, format, input string) []decoded {
+	t.Helper()
+
+	dec, err := NewDecoder(format, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []decoded
+	for {
+		row, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			return rows
+		}
+		var rowErr *RowError
+		switch {
+		case errors.As(err, &rowErr):
+			rows = append(rows, decoded{row: store.ImportRow{Line: rowErr.Line}, err: rowErr.Error()})
+		case err != nil:
+			t.Fatal(err)
+		default:
+			rows = append(rows, decoded{row: row})
+		}
+	}
+}
+
+func TestCSVDecoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []decoded
+	}{
+		{
+			name:  "every column",
+			input: "title,year,runtime,genres,source,external_id\nMoana,2016,107,animation|adventure,imdb,tt3521164\n",
+			want: []decoded{{row: store.ImportRow{Line: 2, Source: "imdb", ExternalID: "tt3521164", Movie: store.Movie{
+				Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"},
+			}}}},
+		},
+		{
+			name:  "columns in any order and case, with spaces and a runtime in mins",
+			input: " Genres ,RUNTIME,title,year\n drama | comedy ,102 mins, Black Panther ,2018\n",
+			want: []decoded{{row: store.ImportRow{Line: 2, Movie: store.Movie{
+				Title: "Black Panther", Year: 2018, Runtime: 102, Genres: []string{"drama", "comedy"},
+			}}}},
+		},
+		{
+			name:  "empty values and missing trailing fields",
+			input: "title,year,runtime,genres,source\n,,,\nMoana\n",
+			want: []decoded{
+				{row: store.ImportRow{Line: 2}},
+				{row: store.ImportRow{Line: 3, Movie: store.Movie{Title: "Moana"}}},
+			},
+		},
+		{
+			name:  "quoted fields over several lines",
+			input: "title,year,runtime,genres\n\"Crouching Tiger,\nHidden Dragon\",2000,120,action\nMoana,2016,107,animation\n",
+			want: []decoded{
+				{row: store.ImportRow{Line: 2, Movie: store.Movie{Title: "Crouching Tiger,\nHidden Dragon", Year: 2000, Runtime: 120, Genres: []string{"action"}}}},
+				{row: store.ImportRow{Line: 4, Movie: store.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}}},
+			},
+		},
+		{
+			name:  "errors of single rows",
+			input: "title,year,runtime,genres\nMoana,twenty,107,animation\nMoana,2016,107 minutes,animation\nMo\"ana,2016,107,animation\nCoco,2017,105,animation\n",
+			want: []decoded{
+				{row: store.ImportRow{Line: 2}, err: "line 2: year must be an integer value"},
+				{row: store.ImportRow{Line: 3}, err: "line 3: invalid runtime format"},
+				{row: store.ImportRow{Line: 4}, err: `line 4: bare " in non-quoted-field`},
+				{row: store.ImportRow{Line: 5, Movie: store.Movie{Title: "Coco", Year: 2017, Runtime: 105, Genres: []string{"animation"}}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := decodeAll(t, store.ImportFormatCSV, tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCSVDecoderHeader(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"", "csv file must have a header row"},
+		{"title,year,runtime\nMoana,2016,107\n", `csv header is missing the "genres" column`},
+		{"name,year,runtime,genres\n", `csv header is missing the "title" column`},
+	}
+
+	for _, tt := range tests {
+		_, err := NewDecoder(store.ImportFormatCSV, strings.NewReader(tt.input))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got %v, want %s", tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestNDJSONDecoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []decoded
+	}{
+		{
+			name:  "every key",
+			input: `{"title":"Moana","year":2016,"runtime":"107 mins","genres":["animation","adventure"],"source":"imdb","external_id":"tt3521164"}`,
+			want: []decoded{{row: store.ImportRow{Line: 1, Source: "imdb", ExternalID: "tt3521164", Movie: store.Movie{
+				Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"},
+			}}}},
+		},
+		{
+			name:  "blank lines are skipped but counted",
+			input: "\n{\"title\":\"Moana\"}\n  \n{\"title\":\"Coco\"}\n",
+			want: []decoded{
+				{row: store.ImportRow{Line: 2, Movie: store.Movie{Title: "Moana"}}},
+				{row: store.ImportRow{Line: 4, Movie: store.Movie{Title: "Coco"}}},
+			},
+		},
+		{
+			name:  "errors of single rows",
+			input: "{\"title\":\"Moana\",\"runtime\":107}\n{\"title\":\n{\"title\":1}\n{\"title\":\"Coco\"}\n",
+			want: []decoded{
+				{row: store.ImportRow{Line: 1}, err: "line 1: invalid runtime format"},
+				{row: store.ImportRow{Line: 2}, err: "line 2: unexpected end of JSON input"},
+				{row: store.ImportRow{Line: 3}, err: "line 3: json: cannot unmarshal number into Go struct field .title of type string"},
+				{row: store.ImportRow{Line: 4, Movie: store.Movie{Title: "Coco"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := decodeAll(t, store.ImportFormatNDJSON, tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNDJSONDecoderLineTooLong(t *testing.T) {
+	input := "{\"title\":\"Moana\"}\n{\"title\":\"" + strings.Repeat("a", maxLineBytes) + "\"}\n"
+
+	dec, err := NewDecoder(store.ImportFormatNDJSON, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file cannot be read any further, so this is not a RowError
+	_, err = dec.Next()
+	var rowErr *RowError
+	if err == nil || errors.As(err, &rowErr) || err.Error() != "line 2 is longer than 1048576 bytes" {
+		t.Errorf("got %v, want the line to be too long", err)
+	}
+}
+
+func TestNewDecoderRejectsUnknownFormats(t *testing.T) {
+	if _, err := NewDecoder("xlsx", strings.NewReader("")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got %v, want ErrUnsupportedFormat", err)
+	}
+}
@@ -0,0 +1,199 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+const (
+	DefaultBatchSize = 1000
+
+	// the job keeps counting failed rows past this limit but stops storing their errors
+	maxRowErrors = 1000
+)
+
+type Importer struct {
+	Store     store.Storage
+	BatchSize int
+}
+
+func New(s store.Storage, batchSize int) *Importer {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &Importer{Store: s, BatchSize: batchSize}
+}
+
+// Run streams rows out of r, validates each of them with store.ValidateMovie and writes
+// them in batches. The job's progress is saved after every batch so that it can be queried
+// while the import is still running.
+func (im *Importer) Run(ctx context.Context, job *store.ImportJob, r io.Reader) error {
+	job.Status = store.ImportStatusRunning
+	if err := im.Store.Imports.UpdateJob(ctx, job); err != nil {
+		return err
+	}
+
+	err := im.run(ctx, job, r)
+
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+	job.Status = store.ImportStatusCompleted
+	if err != nil {
+		job.Status = store.ImportStatusFailed
+		job.Message = err.Error()
+	}
+
+	// the job status is saved even when ctx has been cancelled
+	if updateErr := im.Store.Imports.UpdateJob(context.WithoutCancel(ctx), job); updateErr != nil {
+		return errors.Join(err, updateErr)
+	}
+	return err
+}
+
+func (im *Importer) run(ctx context.Context, job *store.ImportJob, r io.Reader) error {
+	dec, err := NewDecoder(job.Format, r)
+	if err != nil {
+		return err
+	}
+
+	// external IDs seen so far, so that a file repeating one does not write the same movie twice
+	seen := make(map[[2]string]int)
+	batch := make([]store.ImportRow, 0, im.BatchSize)
+	// genre names resolved to their slugs so far, with "" for unknown genres
+	genres := make(map[string]string)
+
+	for {
+		row, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var rowErr *RowError
+		switch {
+		case errors.As(err, &rowErr):
+			job.Processed++
+			im.addRowError(job, rowErr.Line, map[string]string{"row": rowErr.Err.Error()})
+			continue
+		case err != nil:
+			return err
+		}
+
+		job.Processed++
+
+		v := validator.New()
+		store.ValidateMovie(v, &row.Movie)
+		validateExternalID(v, row)
+
+		if row.ExternalID != "" {
+			key := [2]string{row.Source, row.ExternalID}
+			if line, ok := seen[key]; ok {
+				v.AddError("external_id", validator.CodeDuplicate, fmt.Sprintf("duplicates the external id on line %d", line))
+			} else {
+				seen[key] = row.Line
+			}
+		}
+
+		if !v.Valid() {
+			im.addRowError(job, row.Line, v.Errors)
+			continue
+		}
+
+		batch = append(batch, row)
+		if len(batch) == im.BatchSize {
+			if err := im.flush(ctx, job, batch, genres); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	return im.flush(ctx, job, batch, genres)
+}
+
+func (im *Importer) flush(ctx context.Context, job *store.ImportJob, batch []store.ImportRow, genres map[string]string) error {
+	batch, err := im.normalizeGenres(ctx, job, batch, genres)
+	if err != nil {
+		return err
+	}
+
+	if len(batch) > 0 && job.Mode != store.ImportModeDryRun {
+		result, err := im.Store.Imports.InsertBatch(ctx, batch, job.Mode, job.CreatedBy)
+		if err != nil {
+			return err
+		}
+		job.Inserted += result.Inserted
+		job.Updated += result.Updated
+		job.Skipped += result.Skipped
+	}
+	return im.Store.Imports.UpdateJob(ctx, job)
+}
+
+// normalizeGenres rewrites the genres of the batch to the slugs of the taxonomy, looking up the
+// names not seen in earlier batches in one query. Rows with unknown genres are failed and left out.
+func (im *Importer) normalizeGenres(ctx context.Context, job *store.ImportJob, batch []store.ImportRow, genres map[string]string) ([]store.ImportRow, error) {
+	var names []string
+	for _, row := range batch {
+		for _, name := range row.Movie.Genres {
+			if _, ok := genres[name]; !ok && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	if len(names) > 0 {
+		resolved, err := im.Store.Genres.Resolve(ctx, names)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			genres[name] = resolved[name]
+		}
+	}
+
+	valid := batch[:0]
+	for _, row := range batch {
+		slugs := make([]string, 0, len(row.Movie.Genres))
+		var unknown []string
+
+		for _, name := range row.Movie.Genres {
+			switch slug := genres[name]; {
+			case slug == "":
+				unknown = append(unknown, fmt.Sprintf("%q", name))
+			case !slices.Contains(slugs, slug):
+				slugs = append(slugs, slug)
+			}
+		}
+
+		if len(unknown) > 0 {
+			im.addRowError(job, row.Line, map[string]string{"genres": "contains unknown genres: " + strings.Join(unknown, ", ")})
+			continue
+		}
+		row.Movie.Genres = slugs
+		valid = append(valid, row)
+	}
+	return valid, nil
+}
+
+func (im *Importer) addRowError(job *store.ImportJob, line int, rowErrors map[string]string) {
+	job.Failed++
+	if len(job.RowErrors) < maxRowErrors {
+		job.RowErrors = append(job.RowErrors, store.ImportRowError{Line: line, Errors: rowErrors})
+	}
+}
+
+func validateExternalID(v *validator.Validator, row store.ImportRow) {
+	if row.ExternalID == "" && row.Source == "" {
+		return
+	}
+	v.Check(row.Source != "", "source", validator.CodeRequired, "must be provided with an external_id")
+	v.Check(row.ExternalID != "", "external_id", validator.CodeRequired, "must be provided with a source")
+	store.ValidateExternalID(v, row.Source, row.ExternalID)
+}
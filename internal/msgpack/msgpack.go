@@ -0,0 +1,325 @@
+// Package msgpack converts between MessagePack and the generic values that encoding/json decodes
+// into with UseNumber: nil, bool, json.Number, string, []any and map[string]any. Going through
+// those values keeps the field names and formats of the JSON representation.
+package msgpack
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// maxDepth bounds the nesting of decoded arrays and maps
+const maxDepth = 64
+
+// SyntaxError reports malformed MessagePack and the byte offset it was found at
+type SyntaxError struct {
+	msg    string
+	Offset int64
+}
+
+func (e *SyntaxError) Error() string {
+	return e.msg
+}
+
+// Marshal encodes a generic JSON value as MessagePack. Map keys are written in sorted order.
+func Marshal(v any) ([]byte, error) {
+	return appendValue(nil, v)
+}
+
+func appendValue(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		return appendNumber(b, v)
+	case string:
+		return appendString(b, v), nil
+	case []any:
+		b = appendLength(b, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			var err error
+			if b, err = appendValue(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		b = appendLength(b, len(v), 0x80, 0xde, 0xdf)
+		for _, key := range keys {
+			b = appendString(b, key)
+			var err error
+			if b, err = appendValue(b, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+}
+
+func appendNumber(b []byte, n json.Number) ([]byte, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		switch {
+		case i >= 0 && i <= 0x7f:
+			return append(b, byte(i)), nil
+		case i >= -32 && i < 0:
+			return append(b, byte(i)), nil
+		case i >= 0 && i <= math.MaxUint8:
+			return append(b, 0xcc, byte(i)), nil
+		case i >= 0 && i <= math.MaxUint16:
+			return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(i)), nil
+		case i >= 0 && i <= math.MaxUint32:
+			return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(i)), nil
+		case i >= 0:
+			return binary.BigEndian.AppendUint64(append(b, 0xcf), uint64(i)), nil
+		case i >= math.MinInt8:
+			return append(b, 0xd0, byte(i)), nil
+		case i >= math.MinInt16:
+			return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i)), nil
+		case i >= math.MinInt32:
+			return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i)), nil
+		default:
+			return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i)), nil
+		}
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return binary.BigEndian.AppendUint64(append(b, 0xcf), u), nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return nil, fmt.Errorf("msgpack: invalid number %q", n)
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f)), nil
+}
+
+func appendString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// appendLength writes the header of an array or map, whose fix format holds up to 15 entries
+func appendLength(b []byte, n int, fix, format16, format32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, format16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, format32), uint32(n))
+	}
+}
+
+// Unmarshal decodes a single MessagePack value into a generic JSON value. Binary data becomes a
+// string, and map keys must be strings since JSON allows no others.
+func Unmarshal(data []byte) (any, error) {
+	d := &decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(d.data) {
+		return nil, d.errorf("unexpected data after the top-level value")
+	}
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+	off  int
+}
+
+func (d *decoder) errorf(format string, args ...any) error {
+	return &SyntaxError{msg: "msgpack: " + fmt.Sprintf(format, args...), Offset: int64(d.off)}
+}
+
+var errUnexpectedEnd = errors.New("unexpected end of data")
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.off < n {
+		return nil, d.errorf("%v", errUnexpectedEnd)
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+func (d *decoder) uint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+func (d *decoder) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, d.errorf("exceeded max depth of %d", maxDepth)
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c := b[0]; {
+	case c <= 0x7f:
+		return json.Number(strconv.Itoa(int(c))), nil
+	case c >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(c)))), nil
+	case c&0xf0 == 0x80:
+		return d.object(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return d.array(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return d.str(int(c & 0x1f))
+	}
+
+	switch c := b[0]; c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6, 0xd9, 0xda, 0xdb:
+		size := map[byte]int{0xc4: 1, 0xc5: 2, 0xc6: 4, 0xd9: 1, 0xda: 2, 0xdb: 4}[c]
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case 0xca:
+		u, err := d.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.float(float64(math.Float32frombits(uint32(u))))
+	case 0xcb:
+		u, err := d.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return d.float(math.Float64frombits(u))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.uint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(u, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		u, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// sign-extend from the width of the value
+		shift := 64 - 8*size
+		return json.Number(strconv.FormatInt(int64(u<<shift)>>shift, 10)), nil
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.object(int(n), depth)
+	default:
+		d.off--
+		return nil, d.errorf("unsupported format byte 0x%02x", c)
+	}
+}
+
+func (d *decoder) str(n int) (any, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *decoder) float(f float64) (any, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, d.errorf("%v cannot be represented in JSON", f)
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+func (d *decoder) array(n int, depth int) (any, error) {
+	// every element takes at least one byte, which bounds what a forged length can allocate
+	if n > len(d.data)-d.off {
+		return nil, d.errorf("%v", errUnexpectedEnd)
+	}
+	items := make([]any, 0, n)
+	for range n {
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (d *decoder) object(n int, depth int) (any, error) {
+	if 2*n > len(d.data)-d.off {
+		return nil, d.errorf("%v", errUnexpectedEnd)
+	}
+	members := make(map[string]any, n)
+	for range n {
+		start := d.off
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		// binary data decodes to a string too, but is not one
+		name, ok := key.(string)
+		if c := d.data[start]; !ok || c >= 0xc4 && c <= 0xc6 {
+			d.off = start
+			return nil, d.errorf("map keys must be strings")
+		}
+		value, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		members[name] = value
+	}
+	return members, nil
+}
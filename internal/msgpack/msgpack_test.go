@@ -0,0 +1,204 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	members := map[string]any{}
+	for _, key := range strings.Split("abcdefghijklmnopqrstuvwxyz", "") {
+		members[key] = json.Number("1")
+	}
+	items := make([]any, 20)
+	for i := range items {
+		items[i] = "item"
+	}
+
+	tests := []any{
+		nil,
+		true,
+		false,
+		json.Number("0"),
+		json.Number("127"),
+		json.Number("128"),
+		json.Number("255"),
+		json.Number("256"),
+		json.Number("65536"),
+		json.Number("4294967296"),
+		json.Number("9223372036854775807"),
+		json.Number("18446744073709551615"),
+		json.Number("-1"),
+		json.Number("-32"),
+		json.Number("-33"),
+		json.Number("-129"),
+		json.Number("-32769"),
+		json.Number("-2147483649"),
+		json.Number("-9223372036854775808"),
+		json.Number("1.5"),
+		json.Number("-0.25"),
+		json.Number("1e+100"),
+		"",
+		"café",
+		strings.Repeat("a", 31),
+		strings.Repeat("a", 32),
+		strings.Repeat("a", 300),
+		strings.Repeat("b", 70000),
+		[]any{},
+		[]any{json.Number("1"), "two", []any{nil, false}},
+		items,
+		map[string]any{},
+		map[string]any{"title": "Moana", "genres": []any{"animation"}, "year": json.Number("2016"), "runtime": map[string]any{"mins": json.Number("107")}},
+		members,
+	}
+
+	for _, v := range tests {
+		data, err := Marshal(v)
+		if err != nil {
+			t.Errorf("%v: %v", v, err)
+			continue
+		}
+		got, err := Unmarshal(data)
+		if err != nil {
+			t.Errorf("%v: %v", v, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("got %#v, want %#v", got, v)
+		}
+	}
+}
+
+func TestMarshalSortsKeys(t *testing.T) {
+	data, err := Marshal(map[string]any{"b": json.Number("2"), "a": json.Number("1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := decodeHex(t, "82 a161 01 a162 02"); !bytes.Equal(data, want) {
+		t.Errorf("got %x, want %x", data, want)
+	}
+}
+
+func TestMarshalRejectsUnsupportedValues(t *testing.T) {
+	for _, v := range []any{json.Number("one"), 1, map[string]string{}} {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("%#v: got no error", v)
+		}
+	}
+}
+
+// formats Marshal does not write but other encoders do
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"ca 3fc00000", `1.5`},
+		{"d0 ff", `-1`},
+		{"d1 ff7f", `-129`},
+		{"d3 0000000000000001", `1`},
+		{"c4 03 616263", `"abc"`},
+		{"d9 03 616263", `"abc"`},
+		{"dc 0002 01 02", `[1,2]`},
+		{"de 0001 a161 c0", `{"a":null}`},
+		{"df 00000001 a161 c3", `{"a":true}`},
+	}
+
+	for _, tt := range tests {
+		v, err := Unmarshal(decodeHex(t, tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.data, err)
+			continue
+		}
+		got, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		msg    string
+		offset int64
+	}{
+		{"empty", "", "unexpected end of data", 0},
+		{"truncated integer", "cd 01", "unexpected end of data", 1},
+		{"truncated string", "a3 6162", "unexpected end of data", 1},
+		{"truncated length", "da 01", "unexpected end of data", 1},
+		{"truncated array", "93 01 02", "unexpected end of data", 1},
+		{"truncated map", "81 a161", "unexpected end of data", 3},
+		{"oversized string", "db ffffffff 61", "unexpected end of data", 5},
+		{"oversized binary", "c6 ffffffff 61", "unexpected end of data", 5},
+		{"oversized array", "dd ffffffff 01", "unexpected end of data", 5},
+		{"oversized map", "df ffffffff 01", "unexpected end of data", 5},
+		{"integer map key", "81 01 02", "map keys must be strings", 1},
+		{"nil map key", "81 c0 02", "map keys must be strings", 1},
+		{"binary map key", "81 c4 01 61 02", "map keys must be strings", 1},
+		{"array map key", "81 90 01", "map keys must be strings", 1},
+		{"never used byte", "c1", "unsupported format byte 0xc1", 0},
+		{"extension", "d4 01 00", "unsupported format byte 0xd4", 0},
+		{"NaN", "cb 7ff8000000000001", "NaN cannot be represented in JSON", 9},
+		{"infinity", "ca 7f800000", "+Inf cannot be represented in JSON", 5},
+		{"trailing data", "01 02", "unexpected data after the top-level value", 1},
+		{"too deep", strings.Repeat("91", 65) + "90", "exceeded max depth of 64", 65},
+		{"too deep in maps", strings.Repeat("81 a161", 65) + "c0", "exceeded max depth of 64", 193},
+	}
+
+	for _, tt := range tests {
+		_, err := Unmarshal(decodeHex(t, tt.data))
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: got %v, want a syntax error", tt.name, err)
+			continue
+		}
+		if syntaxErr.Error() != "msgpack: "+tt.msg || syntaxErr.Offset != tt.offset {
+			t.Errorf("%s: got %q at %d, want %q at %d", tt.name, syntaxErr, syntaxErr.Offset, tt.msg, tt.offset)
+		}
+	}
+}
+
+func TestUnmarshalMaxDepth(t *testing.T) {
+	data := append(bytes.Repeat([]byte{0x91}, maxDepth), 0x90)
+	if _, err := Unmarshal(data); err != nil {
+		t.Errorf("got %v for %d levels of nesting", err, maxDepth)
+	}
+
+	// a deep document stops at the limit rather than running out of stack
+	if _, err := Unmarshal(bytes.Repeat([]byte{0x91}, 1<<20)); err == nil {
+		t.Error("got no error for a million levels of nesting")
+	}
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	data, err := Marshal(map[string]any{"title": "Moana", "genres": []any{"animation", "adventure"}, "year": json.Number("2016"), "rating": json.Number("7.6")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := range len(data) {
+		if _, err := Unmarshal(data[:n]); err == nil {
+			t.Errorf("got no error for the first %d of %d bytes", n, len(data))
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Package recommend computes the movie similarity table behind the similar movies and
+// recommendation endpoints. The signals are the genres two movies share, how often they appear
+// together in collections, whether they belong to the same franchise and how close their release
+// years are. There are no ratings or credits in the catalogue yet; they would be further signals.
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+const (
+	// the number of similar movies kept per movie
+	DefaultPerMovie = 50
+
+	// movies sharing a genre are only compared with this many others from that genre, those with
+	// the closest release years, which keeps a rebuild from being quadratic in the catalogue size
+	maxGenreCandidates = 1000
+
+	// collections holding more movies than this say little about any two of them and are skipped
+	maxCollectionSize = 200
+
+	// release years this far apart no longer count as close
+	yearWindow = 20
+
+	genreWeight      = 0.5
+	collectionWeight = 0.35
+	yearWeight       = 0.15
+	franchiseBonus   = 0.3
+)
+
+type candidate struct {
+	sharedGenres      []string
+	sharedCollections int
+	sameFranchise     bool
+}
+
+// Build computes up to perMovie similar movies for every movie
+func Build(movies []*store.Movie, memberships []store.CollectionMembership, perMovie int) []store.Similarity {
+	if perMovie <= 0 {
+		perMovie = DefaultPerMovie
+	}
+
+	byID := make(map[int64]*store.Movie, len(movies))
+	for _, movie := range movies {
+		byID[movie.ID] = movie
+	}
+
+	// the movies of each genre ordered by release year
+	genres := make(map[string][]*store.Movie)
+	for _, movie := range movies {
+		for _, genre := range movie.Genres {
+			genres[genre] = append(genres[genre], movie)
+		}
+	}
+	for _, posting := range genres {
+		sort.SliceStable(posting, func(i, j int) bool { return posting[i].Year < posting[j].Year })
+	}
+
+	collections := make(map[int64][]int64)
+	franchises := make(map[int64]bool)
+	movieCollections := make(map[int64][]int64)
+	for _, m := range memberships {
+		if _, ok := byID[m.MovieID]; !ok {
+			continue
+		}
+		collections[m.CollectionID] = append(collections[m.CollectionID], m.MovieID)
+		movieCollections[m.MovieID] = append(movieCollections[m.MovieID], m.CollectionID)
+		if m.Kind == store.CollectionKindFranchise {
+			franchises[m.CollectionID] = true
+		}
+	}
+
+	var similarities []store.Similarity
+
+	for _, movie := range movies {
+		candidates := make(map[int64]*candidate)
+		get := func(id int64) *candidate {
+			c, ok := candidates[id]
+			if !ok {
+				c = &candidate{}
+				candidates[id] = c
+			}
+			return c
+		}
+
+		for _, genre := range movie.Genres {
+			for _, other := range nearestByYear(genres[genre], movie.Year, maxGenreCandidates) {
+				if other.ID != movie.ID {
+					c := get(other.ID)
+					c.sharedGenres = append(c.sharedGenres, genre)
+				}
+			}
+		}
+
+		for _, collectionID := range movieCollections[movie.ID] {
+			members := collections[collectionID]
+			if len(members) > maxCollectionSize {
+				continue
+			}
+			for _, id := range members {
+				if id != movie.ID {
+					c := get(id)
+					c.sharedCollections++
+					c.sameFranchise = c.sameFranchise || franchises[collectionID]
+				}
+			}
+		}
+
+		scored := make([]store.Similarity, 0, len(candidates))
+		for id, c := range candidates {
+			scored = append(scored, score(movie, byID[id], c))
+		}
+		sort.Slice(scored, func(i, j int) bool {
+			if scored[i].Score != scored[j].Score {
+				return scored[i].Score > scored[j].Score
+			}
+			return scored[i].SimilarID < scored[j].SimilarID
+		})
+		if len(scored) > perMovie {
+			scored = scored[:perMovie]
+		}
+		similarities = append(similarities, scored...)
+	}
+	return similarities
+}
+
+// nearestByYear returns up to limit movies of a posting list sorted by year, those released
+// closest to year
+func nearestByYear(posting []*store.Movie, year int32, limit int) []*store.Movie {
+	if len(posting) <= limit {
+		return posting
+	}
+	i := sort.Search(len(posting), func(i int) bool { return posting[i].Year >= year })
+	lo := max(0, i-limit/2)
+	hi := min(len(posting), lo+limit)
+	lo = max(0, hi-limit)
+	return posting[lo:hi]
+}
+
+func score(movie, other *store.Movie, c *candidate) store.Similarity {
+	var (
+		total   float64
+		reasons []string
+	)
+
+	if len(c.sharedGenres) > 0 {
+		union := len(movie.Genres) + len(other.Genres) - len(c.sharedGenres)
+		total += genreWeight * float64(len(c.sharedGenres)) / float64(union)
+		if len(c.sharedGenres) == 1 {
+			reasons = append(reasons, "shares the genre "+c.sharedGenres[0])
+		} else {
+			reasons = append(reasons, "shares the genres "+strings.Join(c.sharedGenres, ", "))
+		}
+	}
+
+	if c.sharedCollections > 0 {
+		total += collectionWeight * (1 - 1/float64(1+c.sharedCollections))
+		if c.sharedCollections == 1 {
+			reasons = append(reasons, "appears in a collection with it")
+		} else {
+			reasons = append(reasons, fmt.Sprintf("appears in %d collections with it", c.sharedCollections))
+		}
+	}
+
+	if c.sameFranchise {
+		total += franchiseBonus
+		reasons = append(reasons, "belongs to the same franchise")
+	}
+
+	years := math.Abs(float64(movie.Year - other.Year))
+	if years < yearWindow {
+		total += yearWeight * (1 - years/yearWindow)
+		switch years {
+		case 0:
+			reasons = append(reasons, "released the same year")
+		case 1:
+			reasons = append(reasons, "released a year apart")
+		default:
+			if years <= 5 {
+				reasons = append(reasons, fmt.Sprintf("released %d years apart", int(years)))
+			}
+		}
+	}
+
+	return store.Similarity{
+		MovieID:   movie.ID,
+		SimilarID: other.ID,
+		Score:     math.Round(total*10000) / 10000,
+		Reasons:   slices.Clip(reasons),
+	}
+}
+
+// Rebuild recomputes the similarity table from the movies and collections in the store
+func Rebuild(ctx context.Context, s store.Storage, perMovie int) (int, error) {
+	var movies []*store.Movie
+
+	err := s.Movies.Export(ctx, store.MovieFilter{}, func(movie *store.Movie) error {
+		movies = append(movies, movie)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	memberships, err := s.Similarities.GetMemberships(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	similarities := Build(movies, memberships, perMovie)
+	if err := s.Similarities.ReplaceAll(ctx, similarities); err != nil {
+		return 0, err
+	}
+	return len(similarities), nil
+}
@@ -0,0 +1,463 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	// a franchise is an ordered series of movies such as a trilogy, a list is curated by its owner
+	CollectionKindFranchise = "franchise"
+	CollectionKindList      = "list"
+
+	CollectionVisibilityPublic  = "public"
+	CollectionVisibilityPrivate = "private"
+
+	// the number of movies a single collection can hold
+	MaxCollectionMovies = 1000
+)
+
+type Collection struct {
+	ID          int64             `json:"id"`
+	CreatedAt   time.Time         `json:"created_at"`
+	Kind        string            `json:"kind"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	OwnerID     int64             `json:"owner_id"`
+	Visibility  string            `json:"visibility"`
+	Version     int32             `json:"version"`
+	Movies      []CollectionMovie `json:"movies,omitempty"`
+}
+
+// CollectionMovie is a movie in a collection along with its position, starting at 1
+type CollectionMovie struct {
+	Position int    `json:"position"`
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Year     int32  `json:"year,omitempty"`
+}
+
+// MovieCollection is a collection as listed on one of its movies
+type MovieCollection struct {
+	ID       int64  `json:"id"`
+	Kind     string `json:"kind"`
+	Title    string `json:"title"`
+	Position int    `json:"position"`
+}
+
+func ValidateCollection(v *validator.Validator, c *Collection) {
+	v.Check(validator.PermittedValue(c.Kind, CollectionKindFranchise, CollectionKindList), "kind", validator.CodeNotPermitted, "must be franchise or list")
+	v.Check(c.Title != "", "title", validator.CodeRequired, "must be provided")
+	v.Check(len(c.Title) <= 200, "title", validator.CodeInvalidLength, "must not be more than 200 bytes long")
+	v.Check(len(c.Description) <= 5000, "description", validator.CodeInvalidLength, "must not be more than 5000 bytes long")
+	v.Check(validator.PermittedValue(c.Visibility, CollectionVisibilityPublic, CollectionVisibilityPrivate), "visibility", validator.CodeNotPermitted, "must be public or private")
+}
+
+type CollectionStore struct {
+	DB *sql.DB
+}
+
+// GetAll lists the public collections and the private ones owned by viewerID, optionally
+// only those of one kind
+func (s *CollectionStore) GetAll(ctx context.Context, viewerID int64, kind string, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER() , id , created_at , kind , title , description , owner_id , visibility , version
+	FROM collections
+	WHERE (visibility = 'public' OR owner_id = $1)
+	AND (kind = $2 OR $2 = '')
+	ORDER BY %s
+	LIMIT $3 OFFSET $4
+	`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, viewerID, kind, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+		var c Collection
+		err := rows.Scan(
+			&totalRecords,
+			&c.ID,
+			&c.CreatedAt,
+			&c.Kind,
+			&c.Title,
+			&c.Description,
+			&c.OwnerID,
+			&c.Visibility,
+			&c.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		collections = append(collections, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return collections, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Get returns the collection with its movies in order, numbered without the gaps left by deleted movies. A private collection is only found
+// for its owner, so that its existence is not given away to anyone else.
+func (s *CollectionStore) Get(ctx context.Context, id int64, viewerID int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrorNotFound
+	}
+	query := `
+	SELECT id , created_at , kind , title , description , owner_id , visibility , version
+	FROM collections
+	WHERE id = $1 AND (visibility = 'public' OR owner_id = $2)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	var c Collection
+	err := s.DB.QueryRowContext(ctx, query, id, viewerID).Scan(
+		&c.ID,
+		&c.CreatedAt,
+		&c.Kind,
+		&c.Title,
+		&c.Description,
+		&c.OwnerID,
+		&c.Visibility,
+		&c.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+	SELECT row_number() OVER (ORDER BY collection_movies.position) , movies.id , movies.title , movies.year
+	FROM collection_movies
+	INNER JOIN movies ON movies.id = collection_movies.movie_id
+	WHERE collection_movies.collection_id = $1
+	ORDER BY collection_movies.position ASC
+	`
+
+	rows, err := s.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	c.Movies = []CollectionMovie{}
+	for rows.Next() {
+		var movie CollectionMovie
+		if err := rows.Scan(&movie.Position, &movie.ID, &movie.Title, &movie.Year); err != nil {
+			return nil, err
+		}
+		c.Movies = append(c.Movies, movie)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetForMovie lists the collections holding the movie that viewerID is allowed to see
+func (s *CollectionStore) GetForMovie(ctx context.Context, movieID int64, viewerID int64) ([]MovieCollection, error) {
+	collections, err := s.GetForMovies(ctx, []int64{movieID}, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	return collections[movieID], nil
+}
+
+// GetForMovies is GetForMovie for several movies in one query, with an empty list for every
+// movie that is in no collection the viewer can see
+func (s *CollectionStore) GetForMovies(ctx context.Context, movieIDs []int64, viewerID int64) (map[int64][]MovieCollection, error) {
+	query := `
+	SELECT collection_movies.movie_id , collections.id , collections.kind , collections.title , (
+		SELECT count(*) FROM collection_movies AS previous
+		WHERE previous.collection_id = collection_movies.collection_id
+		AND previous.position <= collection_movies.position
+	)
+	FROM collection_movies
+	INNER JOIN collections ON collections.id = collection_movies.collection_id
+	WHERE collection_movies.movie_id = ANY($1)
+	AND (collections.visibility = 'public' OR collections.owner_id = $2)
+	ORDER BY collections.kind ASC , collections.id ASC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, pq.Array(movieIDs), viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := make(map[int64][]MovieCollection, len(movieIDs))
+	for _, movieID := range movieIDs {
+		collections[movieID] = []MovieCollection{}
+	}
+	for rows.Next() {
+		var (
+			movieID int64
+			c       MovieCollection
+		)
+		if err := rows.Scan(&movieID, &c.ID, &c.Kind, &c.Title, &c.Position); err != nil {
+			return nil, err
+		}
+		collections[movieID] = append(collections[movieID], c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+func (s *CollectionStore) Create(ctx context.Context, c *Collection) error {
+	query := `
+	INSERT INTO collections (kind , title , description , owner_id , visibility)
+	VALUES ($1 , $2 , $3 , $4 , $5)
+	RETURNING id , created_at , version
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	args := []any{c.Kind, c.Title, c.Description, c.OwnerID, c.Visibility}
+	return s.DB.QueryRowContext(ctx, query, args...).Scan(&c.ID, &c.CreatedAt, &c.Version)
+}
+
+func (s *CollectionStore) Update(ctx context.Context, c *Collection) error {
+	query := `
+	UPDATE collections
+	SET kind = $1 , title = $2 , description = $3 , visibility = $4 , version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING version
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	args := []any{c.Kind, c.Title, c.Description, c.Visibility, c.ID, c.Version}
+	err := s.DB.QueryRowContext(ctx, query, args...).Scan(&c.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *CollectionStore) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrorNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM collections WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// beginReorder starts the transaction of an ordering operation. Bumping the version locks the
+// collection row, so concurrent operations on one collection run one after the other, and
+// renumbering closes the gaps left by deleted movies.
+func (s *CollectionStore) beginReorder(ctx context.Context, c *Collection) (*sql.Tx, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+	UPDATE collections SET version = version + 1
+	WHERE id = $1 AND version = $2
+	RETURNING version
+	`, c.ID, c.Version).Scan(&c.Version)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrEditConflict
+		}
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE collection_movies
+	SET position = ranked.position
+	FROM (
+		SELECT movie_id , row_number() OVER (ORDER BY position) AS position
+		FROM collection_movies
+		WHERE collection_id = $1
+	) AS ranked
+	WHERE collection_movies.collection_id = $1
+	AND collection_movies.movie_id = ranked.movie_id
+	AND collection_movies.position <> ranked.position
+	`, c.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// AddMovie inserts the movie at position, moving the movies from there on down by one. A
+// position of 0 or past the end appends the movie.
+func (s *CollectionStore) AddMovie(ctx context.Context, c *Collection, movieID int64, position int) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	tx, err := s.beginReorder(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		movieExists  bool
+		inCollection bool
+		count        int
+	)
+	err = tx.QueryRowContext(ctx, `
+	SELECT
+		EXISTS (SELECT 1 FROM movies WHERE id = $2) ,
+		EXISTS (SELECT 1 FROM collection_movies WHERE collection_id = $1 AND movie_id = $2) ,
+		(SELECT count(*) FROM collection_movies WHERE collection_id = $1)
+	`, c.ID, movieID).Scan(&movieExists, &inCollection, &count)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !movieExists:
+		return ErrorNotFound
+	case inCollection:
+		return ErrMovieInCollection
+	case count >= MaxCollectionMovies:
+		return ErrCollectionFull
+	}
+
+	if position < 1 || position > count {
+		position = count + 1
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE collection_movies SET position = position + 1
+	WHERE collection_id = $1 AND position >= $2
+	`, c.ID, position)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO collection_movies (collection_id , movie_id , position)
+	VALUES ($1 , $2 , $3)
+	`, c.ID, movieID, position)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveMovie takes the movie out of the collection and moves the movies after it up by one
+func (s *CollectionStore) RemoveMovie(ctx context.Context, c *Collection, movieID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	tx, err := s.beginReorder(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var position int
+	err = tx.QueryRowContext(ctx, `
+	DELETE FROM collection_movies WHERE collection_id = $1 AND movie_id = $2
+	RETURNING position
+	`, c.ID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE collection_movies SET position = position - 1
+	WHERE collection_id = $1 AND position > $2
+	`, c.ID, position)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReorderMovies puts the movies of the collection in the order of movieIDs, which must hold
+// every movie of the collection exactly once
+func (s *CollectionStore) ReorderMovies(ctx context.Context, c *Collection, movieIDs []int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	tx, err := s.beginReorder(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current []int64
+	err = tx.QueryRowContext(ctx, `
+	SELECT coalesce(array_agg(movie_id ORDER BY movie_id), '{}') FROM collection_movies WHERE collection_id = $1
+	`, c.ID).Scan(pq.Array(&current))
+	if err != nil {
+		return err
+	}
+
+	sorted := slices.Clone(movieIDs)
+	slices.Sort(sorted)
+	if !slices.Equal(current, sorted) {
+		return ErrCollectionMovies
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE collection_movies
+	SET position = ordered.position
+	FROM unnest($2::bigint[]) WITH ORDINALITY AS ordered (movie_id , position)
+	WHERE collection_movies.collection_id = $1
+	AND collection_movies.movie_id = ordered.movie_id
+	`, c.ID, pq.Array(movieIDs))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
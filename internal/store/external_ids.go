@@ -0,0 +1,160 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+
+	"github.com/AmiyoKm/green_light/internal/validator"
+	"github.com/lib/pq"
+)
+
+// SourceRX matches the names of upstream catalogues, such as imdb or tmdb
+var SourceRX = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,29}$`)
+
+// ExternalID identifies a movie in an upstream catalogue
+type ExternalID struct {
+	Source     string `json:"source"`
+	ExternalID string `json:"external_id"`
+}
+
+func ValidateExternalID(v *validator.Validator, source, externalID string) {
+	v.Check(source != "", "source", validator.CodeRequired, "must be provided")
+	v.Check(validator.Matches(source, SourceRX), "source", validator.CodeInvalidFormat, "must be a lower case name of up to 30 letters, digits, _ or -")
+	v.Check(externalID != "", "external_id", validator.CodeRequired, "must be provided")
+	v.Check(len(externalID) <= 100, "external_id", validator.CodeInvalidLength, "must not be more than 100 bytes long")
+}
+
+func (s *MovieStore) GetByExternalID(ctx context.Context, source, externalID string) (*Movie, error) {
+	query := `
+		SELECT movies.id , movies.created_at , movies.title , movies.year , movies.runtime , movies.genres , movies.version
+		FROM movie_external_ids
+		INNER JOIN movies ON movies.id = movie_external_ids.movie_id
+		WHERE movie_external_ids.source = $1 AND movie_external_ids.external_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	movie := &Movie{}
+	err := s.DB.QueryRowContext(ctx, query, source, externalID).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	return movie, nil
+}
+
+// CreateWithExternalID inserts the movie along with its identifier in the source catalogue. It
+// returns ErrEditConflict when another request attached the identifier to a movie first.
+func (s *MovieStore) CreateWithExternalID(ctx context.Context, movie *Movie, source, externalID string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	// the two inserts share the transaction of the store when it has one, and get their own otherwise
+	tx := s.Tx
+	if tx == nil {
+		var err error
+		tx, err = s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	}
+
+	query := `
+	INSERT INTO movies (title , year , runtime , genres)
+	VALUES ($1 , $2 , $3 , $4) RETURNING id , created_at , version
+	`
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+	INSERT INTO movie_external_ids (movie_id , source , external_id)
+	VALUES ($1 , $2 , $3)
+	ON CONFLICT (source , external_id) DO NOTHING
+	`, movie.ID, source, externalID)
+	if err != nil {
+		return err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrEditConflict
+	}
+
+	if s.Tx == nil {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	s.notifySaved(movie)
+	return nil
+}
+
+// GetExternalIDs lists the identifiers of the movie in the upstream catalogues
+func (s *MovieStore) GetExternalIDs(ctx context.Context, movieID int64) ([]ExternalID, error) {
+	ids, err := s.GetExternalIDsForMovies(ctx, []int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+	return ids[movieID], nil
+}
+
+// GetExternalIDsForMovies returns the external identifiers of several movies in one query,
+// with an empty list for every movie that has none
+func (s *MovieStore) GetExternalIDsForMovies(ctx context.Context, movieIDs []int64) (map[int64][]ExternalID, error) {
+	query := `
+		SELECT movie_id , source , external_id
+		FROM movie_external_ids
+		WHERE movie_id = ANY($1)
+		ORDER BY source ASC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64][]ExternalID, len(movieIDs))
+	for _, movieID := range movieIDs {
+		ids[movieID] = []ExternalID{}
+	}
+	for rows.Next() {
+		var (
+			movieID int64
+			id      ExternalID
+		)
+		if err := rows.Scan(&movieID, &id.Source, &id.ExternalID); err != nil {
+			return nil, err
+		}
+		ids[movieID] = append(ids[movieID], id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
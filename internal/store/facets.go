@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const (
+	FacetGenres  = "genres"
+	FacetDecade  = "decade"
+	FacetRuntime = "runtime"
+)
+
+var FacetSafeList = []string{FacetGenres, FacetDecade, FacetRuntime}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets holds the counts for each requested facet, keyed by the facet name
+type Facets map[string][]FacetCount
+
+// the GROUP BY expression behind each facet. Genre counts come from unnesting the arrays of the
+// matching rows, so with a genres filter every selected genre counts all the results and the
+// other genres show how far they would narrow them.
+var facetQueries = map[string]string{
+	FacetGenres: `
+	SELECT genre , count(*)
+	FROM movies , unnest(genres) AS genre
+	WHERE %s
+	GROUP BY genre
+	ORDER BY count(*) DESC , genre ASC
+	`,
+	FacetDecade: `
+	SELECT ((year / 10) * 10)::text || 's' AS decade , count(*)
+	FROM movies
+	WHERE %s
+	GROUP BY decade
+	ORDER BY decade ASC
+	`,
+	FacetRuntime: `
+	SELECT CASE
+		WHEN runtime < 90 THEN '0-89'
+		WHEN runtime < 120 THEN '90-119'
+		WHEN runtime < 150 THEN '120-149'
+		ELSE '150+'
+	END AS bucket , count(*)
+	FROM movies
+	WHERE %s
+	GROUP BY bucket
+	ORDER BY min(runtime) ASC
+	`,
+}
+
+// GetAllWithFacets returns the same page as GetAll along with the counts of the requested facets
+// over every movie matching the filter. Both run in one REPEATABLE READ transaction, so the counts
+// describe exactly the snapshot the results were read from.
+func (s *MovieStore) GetAllWithFacets(ctx context.Context, filter MovieFilter, filters Filters, facets []string) ([]*Movie, Metadata, Facets, error) {
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+	defer tx.Rollback()
+
+	movies, metadata, err := s.getAll(ctx, tx, filter, filters)
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	where, _, args := filter.build(nil)
+	result := make(Facets, len(facets))
+
+	for _, facet := range facets {
+		query, ok := facetQueries[facet]
+		if !ok {
+			panic("unsafe facet parameter: " + facet)
+		}
+
+		counts, err := countFacet(ctx, tx, fmt.Sprintf(query, where), args)
+		if err != nil {
+			return nil, Metadata{}, nil, err
+		}
+		result[facet] = counts
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, Metadata{}, nil, err
+	}
+	return movies, metadata, result, nil
+}
+
+// countFacet runs one of the facetQueries once its WHERE clause is filled in
+func countFacet(ctx context.Context, q querier, query string, args []any) ([]FacetCount, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FacetCount{}
+	for rows.Next() {
+		var count FacetCount
+		if err := rows.Scan(&count.Value, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
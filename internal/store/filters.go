@@ -1,32 +1,104 @@
 package store
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"strconv"
 	"strings"
 
 	"github.com/AmiyoKm/green_light/internal/validator"
 )
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+
+	// UseCursor switches from page numbers to keyset pagination. An empty Cursor asks for the first page.
+	UseCursor bool
+	Cursor    string
+	// IncludeTotal makes a cursor query also count every matching record, which page mode always does
+	IncludeTotal bool
+}
+
+// Cursor marks the last row of a page in keyset pagination: its values in the sort columns
+// and its id, which breaks ties between rows that share the same values
+type Cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	ID     int64    `json:"id"`
+}
+
+func (c Cursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(js, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// sortKey is one column of a possibly multi-column sort such as "-year,title"
+type sortKey struct {
+	column    string
+	direction string
 }
 
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafeList {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+// sortKeys splits the sort into its columns. Every part must be in the SortSafeList, which is
+// what makes it safe to format the column names into the query.
+func (f Filters) sortKeys() []sortKey {
+	var keys []sortKey
+
+	for _, part := range strings.Split(f.Sort, ",") {
+		if !validator.PermittedValue(part, f.SortSafeList...) {
+			panic("unsafe sort parameter: " + f.Sort)
+		}
+
+		key := sortKey{column: strings.TrimPrefix(part, "-"), direction: "ASC"}
+		if strings.HasPrefix(part, "-") {
+			key.direction = "DESC"
+		}
+		// relevance always lists the best match first
+		if key.column == "relevance" {
+			key.direction = "DESC"
+		}
+		keys = append(keys, key)
+
+		// id is unique, so no column after it can change the order
+		if key.column == "id" {
+			break
 		}
 	}
-	panic("unsafe sort parameter: " + f.Sort)
+	return keys
 }
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
-		return "DESC"
+
+// orderBy returns the ORDER BY clause for the sort. id breaks ties so the order is stable across pages.
+func (f Filters) orderBy() string {
+	var parts []string
+
+	keys := f.sortKeys()
+	for _, key := range keys {
+		parts = append(parts, key.column+" "+key.direction)
+	}
+	if keys[len(keys)-1].column != "id" {
+		parts = append(parts, "id ASC")
 	}
-	return "ASC"
+	return strings.Join(parts, " , ")
 }
 
 func (f Filters) limit() int {
@@ -37,23 +109,122 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// keysetCondition returns the WHERE clause that selects the rows after the cursor along with
+// its arguments, numbering the placeholders from firstParam. For a sort on a, b it expands to
+// a > $1 OR (a = $1 AND b > $2) OR (a = $1 AND b = $2 AND id > $3), flipping > to < for
+// descending columns.
+func (f Filters) keysetCondition(c Cursor, firstParam int) (string, []any) {
+	type keysetColumn struct {
+		sortKey
+		param int
+	}
+
+	var (
+		columns []keysetColumn
+		args    []any
+	)
+
+	keys := f.sortKeys()
+	for i, key := range keys {
+		if key.column == "id" {
+			args = append(args, c.ID)
+		} else {
+			args = append(args, c.Values[i])
+		}
+		columns = append(columns, keysetColumn{sortKey: key, param: firstParam + len(args) - 1})
+	}
+	if keys[len(keys)-1].column != "id" {
+		args = append(args, c.ID)
+		columns = append(columns, keysetColumn{sortKey: sortKey{column: "id", direction: "ASC"}, param: firstParam + len(args) - 1})
+	}
+
+	var alternatives []string
+	for i, column := range columns {
+		var terms []string
+		for _, previous := range columns[:i] {
+			terms = append(terms, fmt.Sprintf("%s = $%d", previous.column, previous.param))
+		}
+
+		op := ">"
+		if column.direction == "DESC" {
+			op = "<"
+		}
+		terms = append(terms, fmt.Sprintf("%s %s $%d", column.column, op, column.param))
+
+		alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+// cursorFor builds the cursor pointing after the given movie for the current sort
+func (f Filters) cursorFor(movie *Movie) Cursor {
+	c := Cursor{Sort: f.Sort, ID: movie.ID}
+
+	for _, key := range f.sortKeys() {
+		switch key.column {
+		case "id":
+			c.Values = append(c.Values, strconv.FormatInt(movie.ID, 10))
+		case "title":
+			c.Values = append(c.Values, movie.Title)
+		case "year":
+			c.Values = append(c.Values, strconv.FormatInt(int64(movie.Year), 10))
+		case "runtime":
+			c.Values = append(c.Values, strconv.FormatInt(int64(movie.Runtime), 10))
+		}
+	}
+	return c
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page < 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize < 10_000_000, "page_size", "must be a maximum of 10 million")
+	v.Check(f.Page > 0, "page", validator.CodeTooSmall, "must be greater than zero")
+	v.Check(f.Page < 10_000_000, "page", validator.CodeTooLarge, "must be a maximum of 10 million")
+	v.Check(f.PageSize > 0, "page_size", validator.CodeTooSmall, "must be greater than zero")
+	v.Check(f.PageSize < 10_000_000, "page_size", validator.CodeTooLarge, "must be a maximum of 10 million")
+
+	columns := make(map[string]bool)
+	for _, part := range strings.Split(f.Sort, ",") {
+		if !validator.PermittedValue(part, f.SortSafeList...) {
+			v.AddError("sort", validator.CodeNotPermitted, fmt.Sprintf("invalid sort value %q, must be a comma-separated list of %s", part, strings.Join(f.SortSafeList, ", ")))
+			return
+		}
+		column := strings.TrimPrefix(part, "-")
+		if columns[column] {
+			v.AddError("sort", validator.CodeDuplicate, fmt.Sprintf("must not sort by %s more than once", column))
+			return
+		}
+		columns[column] = true
+	}
 
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+	if f.UseCursor && f.Cursor != "" {
+		c, err := DecodeCursor(f.Cursor)
+		if err != nil {
+			v.AddError("cursor", validator.CodeInvalid, "must be a cursor returned by a previous request")
+			return
+		}
+		if c.Sort != f.Sort {
+			v.AddError("cursor", validator.CodeInvalid, "was issued for a different sort value")
+			return
+		}
 
+		keys := f.sortKeys()
+		v.Check(len(c.Values) == len(keys), "cursor", validator.CodeInvalid, "must be a cursor returned by a previous request")
+		for i := 0; i < len(keys) && i < len(c.Values); i++ {
+			if keys[i].column != "title" {
+				_, err := strconv.ParseInt(c.Values[i], 10, 64)
+				v.Check(err == nil, "cursor", validator.CodeInvalid, "must be a cursor returned by a previous request")
+			}
+		}
+	}
 }
 
 type Metadata struct {
-	CurrentPage int `json:"current_page,omitempty"`
-	PageSize    int `json:"page_size,omitempty"`
-	FirstPage   int `json:"first_page,omitempty"`
-	LastPage    int `json:"last_page,omitempty"`
-	TotalRecord int `json:"total_records,omitempty"`
+	CurrentPage int    `json:"current_page,omitempty"`
+	PageSize    int    `json:"page_size,omitempty"`
+	FirstPage   int    `json:"first_page,omitempty"`
+	LastPage    int    `json:"last_page,omitempty"`
+	TotalRecord int    `json:"total_records,omitempty"`
+	NextCursor  string `json:"next_cursor,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+var testSortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []Cursor{
+		{Sort: "id", Values: []string{"42"}, ID: 42},
+		{Sort: "-year,title", Values: []string{"2016", "Moana"}, ID: 7},
+		// titles can hold anything, including the characters of the encoding and of JSON
+		{Sort: "title", Values: []string{`Crouching "Tiger", Hidden/Dragon+ 卧虎藏龙`}, ID: 1},
+		{Sort: "title", Values: []string{""}, ID: 0},
+	}
+
+	for _, c := range tests {
+		s := c.Encode()
+		got, err := DecodeCursor(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c) {
+			t.Errorf("got %#v, want %#v", got, c)
+		}
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, s := range []string{"not a cursor!", "eyJz", "bnVsbCB0cnVl", "WyJhIl0"} {
+		if _, err := DecodeCursor(s); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%q: got %v, want ErrInvalidCursor", s, err)
+		}
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	tests := []struct {
+		sort       string
+		cursor     Cursor
+		firstParam int
+		where      string
+		args       []any
+	}{
+		{
+			sort:       "id",
+			cursor:     Cursor{Values: []string{"5"}, ID: 5},
+			firstParam: 1,
+			where:      "((id > $1))",
+			args:       []any{int64(5)},
+		},
+		{
+			sort:       "-id",
+			cursor:     Cursor{Values: []string{"5"}, ID: 5},
+			firstParam: 3,
+			where:      "((id < $3))",
+			args:       []any{int64(5)},
+		},
+		{
+			sort:       "title",
+			cursor:     Cursor{Values: []string{"Moana"}, ID: 7},
+			firstParam: 1,
+			where:      "((title > $1) OR (title = $1 AND id > $2))",
+			args:       []any{"Moana", int64(7)},
+		},
+		{
+			sort:       "-year,title",
+			cursor:     Cursor{Values: []string{"2016", "Moana"}, ID: 7},
+			firstParam: 2,
+			where:      "((year < $2) OR (year = $2 AND title > $3) OR (year = $2 AND title = $3 AND id > $4))",
+			args:       []any{"2016", "Moana", int64(7)},
+		},
+		{
+			sort:       "runtime,-title,-year",
+			cursor:     Cursor{Values: []string{"107", "Moana", "2016"}, ID: 7},
+			firstParam: 1,
+			where:      "((runtime > $1) OR (runtime = $1 AND title < $2) OR (runtime = $1 AND title = $2 AND year < $3) OR (runtime = $1 AND title = $2 AND year = $3 AND id > $4))",
+			args:       []any{"107", "Moana", "2016", int64(7)},
+		},
+		// id is unique, so it ends the sort and the tie-breaker is not added again
+		{
+			sort:       "-year,-id",
+			cursor:     Cursor{Values: []string{"2016", "7"}, ID: 7},
+			firstParam: 1,
+			where:      "((year < $1) OR (year = $1 AND id < $2))",
+			args:       []any{"2016", int64(7)},
+		},
+	}
+
+	for _, tt := range tests {
+		f := Filters{Sort: tt.sort, SortSafeList: testSortSafeList}
+		where, args := f.keysetCondition(tt.cursor, tt.firstParam)
+		if where != tt.where {
+			t.Errorf("%s: got %s, want %s", tt.sort, where, tt.where)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: got args %#v, want %#v", tt.sort, args, tt.args)
+		}
+	}
+}
+
+func TestCursorFor(t *testing.T) {
+	movie := &Movie{ID: 7, Title: "Moana", Year: 2016, Runtime: 107}
+
+	tests := []struct {
+		sort   string
+		values []string
+	}{
+		{"id", []string{"7"}},
+		{"-title", []string{"Moana"}},
+		{"-year,title", []string{"2016", "Moana"}},
+		{"runtime,-id", []string{"107", "7"}},
+	}
+
+	for _, tt := range tests {
+		f := Filters{Sort: tt.sort, SortSafeList: testSortSafeList}
+		want := Cursor{Sort: tt.sort, Values: tt.values, ID: 7}
+		c := f.cursorFor(movie)
+		if !reflect.DeepEqual(c, want) {
+			t.Errorf("%s: got %#v, want %#v", tt.sort, c, want)
+		}
+
+		// the cursor of a page is accepted for the next one
+		f.Page, f.PageSize = 1, 20
+		f.UseCursor, f.Cursor = true, c.Encode()
+		v := validator.New()
+		if ValidateFilters(v, f); !v.Valid() {
+			t.Errorf("%s: got errors %v for its own cursor", tt.sort, v.Errors)
+		}
+	}
+}
+
+func TestValidateFiltersCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		sort   string
+		cursor string
+		msg    string
+	}{
+		{"first page", "title", "", ""},
+		{"garbage", "title", "not a cursor!", "must be a cursor returned by a previous request"},
+		{"other sort", "title", Cursor{Sort: "-title", Values: []string{"Moana"}, ID: 7}.Encode(), "was issued for a different sort value"},
+		{"missing value", "-year,title", Cursor{Sort: "-year,title", Values: []string{"2016"}, ID: 7}.Encode(), "must be a cursor returned by a previous request"},
+		{"non-numeric year", "year", Cursor{Sort: "year", Values: []string{"Moana"}, ID: 7}.Encode(), "must be a cursor returned by a previous request"},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateFilters(v, Filters{Page: 1, PageSize: 20, Sort: tt.sort, SortSafeList: testSortSafeList, UseCursor: true, Cursor: tt.cursor})
+		if got := v.Errors["cursor"]; got != tt.msg {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.msg)
+		}
+	}
+}
+
+func TestValidateFiltersSort(t *testing.T) {
+	tests := []struct {
+		sort string
+		code string
+	}{
+		{"id", ""},
+		{"-year,title", ""},
+		{"runtime,-title,year,-id", ""},
+		{"rating", validator.CodeNotPermitted},
+		{"year,", validator.CodeNotPermitted},
+		{"year,-rating", validator.CodeNotPermitted},
+		{"year,-year", validator.CodeDuplicate},
+		{"title,year,title", validator.CodeDuplicate},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateFilters(v, Filters{Page: 1, PageSize: 20, Sort: tt.sort, SortSafeList: testSortSafeList})
+		if got := v.Codes["sort"]; got != tt.code {
+			t.Errorf("%s: got code %q, want %q", tt.sort, got, tt.code)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	tests := []struct {
+		sort  string
+		order string
+	}{
+		{"id", "id ASC"},
+		{"-id", "id DESC"},
+		{"title", "title ASC , id ASC"},
+		{"-year,title", "year DESC , title ASC , id ASC"},
+		// nothing after id can change the order
+		{"-id,title", "id DESC"},
+	}
+
+	for _, tt := range tests {
+		f := Filters{Sort: tt.sort, SortSafeList: testSortSafeList}
+		if got := f.orderBy(); got != tt.order {
+			t.Errorf("%s: got %q, want %q", tt.sort, got, tt.order)
+		}
+	}
+
+	// relevance is best first whichever way it is asked for
+	f := Filters{Sort: "relevance,-year", SortSafeList: append(testSortSafeList, "relevance")}
+	if got, want := f.orderBy(), "relevance DESC , year DESC , id ASC"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,362 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/AmiyoKm/green_light/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Genre is an entry of the genre taxonomy. Movies store the slugs of their genres, and any
+// alias or spelling of the slug is rewritten to it when a movie is saved.
+type Genre struct {
+	ID        int64     `json:"-"`
+	CreatedAt time.Time `json:"-"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	// Parent is the slug of the broader genre this one belongs to, if any
+	Parent  *string `json:"parent,omitempty"`
+	Version int32   `json:"version"`
+}
+
+// GenreSlug is the key a genre name is looked up by: lower case words of letters and digits
+// joined by hyphens, so that "Sci-Fi", "sci fi" and "SCI-FI" all become "sci-fi". The
+// 000013 migration computes the same key in SQL.
+func GenreSlug(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "-")
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Slug != "", "slug", validator.CodeRequired, "must be provided")
+	v.Check(len(genre.Slug) <= 50, "slug", validator.CodeInvalidLength, "must not be more than 50 bytes long")
+	v.Check(genre.Slug == GenreSlug(genre.Slug), "slug", validator.CodeInvalidFormat, "must only contain lower case letters, digits and single hyphens")
+
+	v.Check(strings.TrimSpace(genre.Name) != "", "name", validator.CodeRequired, "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", validator.CodeInvalidLength, "must not be more than 100 bytes long")
+
+	v.Check(len(genre.Aliases) <= 20, "aliases", validator.CodeTooMany, "must not contain more than 20 aliases")
+	v.Check(validator.Unique(genre.Aliases), "aliases", validator.CodeDuplicate, "must not contain duplicate values")
+	for _, alias := range genre.Aliases {
+		v.Check(alias != "", "aliases", validator.CodeInvalidFormat, "must only contain letters and digits")
+		v.Check(alias != genre.Slug, "aliases", validator.CodeInvalid, "must not contain the slug of the genre")
+	}
+
+	if genre.Parent != nil {
+		v.Check(*genre.Parent != genre.Slug, "parent", validator.CodeInvalid, "must not be the genre itself")
+	}
+}
+
+const PQ_DUPLICATE_GENRE_SLUG string = `pq: duplicate key value violates unique constraint "genres_slug_key"`
+
+type GenreStore struct {
+	DB *sql.DB
+}
+
+const genreColumns = `
+	genres.id , genres.created_at , genres.slug , genres.name , parents.slug , genres.version ,
+	coalesce(array_agg(genre_aliases.alias ORDER BY genre_aliases.alias) FILTER (WHERE genre_aliases.alias IS NOT NULL), '{}')
+	FROM genres
+	LEFT JOIN genres AS parents ON parents.id = genres.parent_id
+	LEFT JOIN genre_aliases ON genre_aliases.genre_id = genres.id
+`
+
+func scanGenre(row interface{ Scan(...any) error }) (*Genre, error) {
+	var genre Genre
+	err := row.Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Slug,
+		&genre.Name,
+		&genre.Parent,
+		&genre.Version,
+		pq.Array(&genre.Aliases),
+	)
+	return &genre, err
+}
+
+func (s *GenreStore) GetAll(ctx context.Context) ([]*Genre, error) {
+	query := `SELECT ` + genreColumns + `
+	GROUP BY genres.id , parents.slug
+	ORDER BY genres.slug ASC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+	for rows.Next() {
+		genre, err := scanGenre(rows)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, genre)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+func (s *GenreStore) Get(ctx context.Context, slug string) (*Genre, error) {
+	query := `SELECT ` + genreColumns + `
+	WHERE genres.slug = $1
+	GROUP BY genres.id , parents.slug
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	genre, err := scanGenre(s.DB.QueryRowContext(ctx, query, slug))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	return genre, nil
+}
+
+// checkGenreKeys returns ErrDuplicateGenre when the slug or an alias of the genre is already
+// the slug or an alias of another genre
+func checkGenreKeys(ctx context.Context, tx *sql.Tx, genre *Genre) error {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM genres WHERE slug = ANY($1) AND id <> $2
+		UNION ALL
+		SELECT 1 FROM genre_aliases WHERE alias = ANY($1) AND genre_id <> $2
+	)
+	`
+
+	keys := append([]string{genre.Slug}, genre.Aliases...)
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, query, pq.Array(keys), genre.ID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrDuplicateGenre
+	}
+	return nil
+}
+
+func replaceGenreAliases(ctx context.Context, tx *sql.Tx, genre *Genre) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM genre_aliases WHERE genre_id = $1`, genre.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO genre_aliases (alias , genre_id)
+	SELECT alias , $2 FROM unnest($1::text[]) AS alias
+	`, pq.Array(genre.Aliases), genre.ID)
+	return err
+}
+
+func (s *GenreStore) Create(ctx context.Context, genre *Genre) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkGenreKeys(ctx, tx, genre); err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO genres (slug , name , parent_id)
+	VALUES ($1 , $2 , (SELECT id FROM genres WHERE slug = $3))
+	RETURNING id , created_at , version
+	`
+
+	err = tx.QueryRowContext(ctx, query, genre.Slug, genre.Name, genre.Parent).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == PQ_DUPLICATE_GENRE_SLUG:
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	if err := replaceGenreAliases(ctx, tx, genre); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update saves the name, aliases and parent of the genre. The slug cannot change, since
+// it is what movies refer to the genre by.
+func (s *GenreStore) Update(ctx context.Context, genre *Genre) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkGenreKeys(ctx, tx, genre); err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE genres
+	SET name = $1 , parent_id = (SELECT id FROM genres WHERE slug = $2) , version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version
+	`
+
+	err = tx.QueryRowContext(ctx, query, genre.Name, genre.Parent, genre.ID, genre.Version).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if err := replaceGenreAliases(ctx, tx, genre); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes a genre that no movie uses any more, returning ErrGenreInUse otherwise.
+// Its sub-genres are left without a parent.
+func (s *GenreStore) Delete(ctx context.Context, slug string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the row lock keeps the genre from being deleted while the usage is counted
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM genres WHERE slug = $1 FOR UPDATE`, slug).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorNotFound
+		default:
+			return err
+		}
+	}
+
+	var used bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movies WHERE genres @> ARRAY[$1])`, slug).Scan(&used)
+	if err != nil {
+		return err
+	}
+	if used {
+		return ErrGenreInUse
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Resolve maps each of the given genre names to the slug of the genre it names, either
+// directly or through an alias. Names matching no genre are left out of the map.
+func (s *GenreStore) Resolve(ctx context.Context, names []string) (map[string]string, error) {
+	query := `
+	SELECT slug , slug FROM genres WHERE slug = ANY($1)
+	UNION ALL
+	SELECT genre_aliases.alias , genres.slug
+	FROM genre_aliases
+	INNER JOIN genres ON genres.id = genre_aliases.genre_id
+	WHERE genre_aliases.alias = ANY($1)
+	`
+
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, GenreSlug(name))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slugs := make(map[string]string, len(keys))
+	for rows.Next() {
+		var key, slug string
+		if err := rows.Scan(&key, &slug); err != nil {
+			return nil, err
+		}
+		slugs[key] = slug
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	resolved := make(map[string]string, len(names))
+	for _, name := range names {
+		if slug, ok := slugs[GenreSlug(name)]; ok {
+			resolved[name] = slug
+		}
+	}
+	return resolved, nil
+}
+
+// Suggest returns the slugs of up to limit genres whose slug, name or aliases look like name
+func (s *GenreStore) Suggest(ctx context.Context, name string, limit int) ([]string, error) {
+	query := `
+	SELECT genres.slug
+	FROM genres
+	LEFT JOIN genre_aliases ON genre_aliases.genre_id = genres.id
+	GROUP BY genres.id
+	HAVING max(greatest(similarity(genres.slug, $1), similarity(genres.name, $1), coalesce(similarity(genre_aliases.alias, $1), 0))) > 0.3
+	ORDER BY max(greatest(similarity(genres.slug, $1), similarity(genres.name, $1), coalesce(similarity(genre_aliases.alias, $1), 0))) DESC , genres.slug ASC
+	LIMIT $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, GenreSlug(name), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slugs := []string{}
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		slugs = append(slugs, slug)
+	}
+	return slugs, rows.Err()
+}
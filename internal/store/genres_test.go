@@ -0,0 +1,63 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+func TestGenreSlug(t *testing.T) {
+	tests := []struct {
+		name string
+		slug string
+	}{
+		{"drama", "drama"},
+		{"Sci-Fi", "sci-fi"},
+		{"sci fi", "sci-fi"},
+		{"  SCI--FI  ", "sci-fi"},
+		{"Rom/Com", "rom-com"},
+		{"Film-Noir 1940s", "film-noir-1940s"},
+		{"Ciência ficção", "ciência-ficção"},
+		{"--", ""},
+	}
+
+	for _, tt := range tests {
+		if got := GenreSlug(tt.name); got != tt.slug {
+			t.Errorf("%q: got %q, want %q", tt.name, got, tt.slug)
+		}
+	}
+}
+
+func TestValidateGenre(t *testing.T) {
+	parent := func(slug string) *string { return &slug }
+
+	tests := []struct {
+		name  string
+		genre Genre
+		key   string
+		code  string
+	}{
+		{"valid", Genre{Slug: "sci-fi", Name: "Science fiction", Aliases: []string{"scifi", "sf"}, Parent: parent("speculative")}, "", ""},
+		{"slug not normalised", Genre{Slug: "Sci Fi", Name: "Science fiction"}, "slug", validator.CodeInvalidFormat},
+		{"missing name", Genre{Slug: "sci-fi", Name: "  "}, "name", validator.CodeRequired},
+		{"duplicate aliases", Genre{Slug: "sci-fi", Name: "Science fiction", Aliases: []string{"sf", "sf"}}, "aliases", validator.CodeDuplicate},
+		{"alias of the slug", Genre{Slug: "sci-fi", Name: "Science fiction", Aliases: []string{"sci-fi"}}, "aliases", validator.CodeInvalid},
+		{"empty alias", Genre{Slug: "sci-fi", Name: "Science fiction", Aliases: []string{""}}, "aliases", validator.CodeInvalidFormat},
+		{"own parent", Genre{Slug: "sci-fi", Name: "Science fiction", Parent: parent("sci-fi")}, "parent", validator.CodeInvalid},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateGenre(v, &tt.genre)
+
+		if tt.key == "" {
+			if !v.Valid() {
+				t.Errorf("%s: got errors %v", tt.name, v.Errors)
+			}
+			continue
+		}
+		if got := v.Codes[tt.key]; got != tt.code {
+			t.Errorf("%s: got %q for %s, want %q in %v", tt.name, got, tt.key, tt.code, v.Errors)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// IdempotencyLockTimeout is how long a key stays claimed by a request that never completed,
+// e.g. because its replica went down, before a retry may take it over
+var IdempotencyLockTimeout = time.Minute
+
+// IdempotentResponse is the response stored for an idempotency key and replayed on retries
+type IdempotentResponse struct {
+	Status int
+	Header map[string][]string
+	Body   []byte
+}
+
+type IdempotencyStore struct {
+	DB *sql.DB
+}
+
+// Begin claims key for a request with the given fingerprint. It returns a nil response when the
+// caller now holds the key and must run the request, and the stored response when the same
+// request already completed within the retention window. A key held by a request that is still
+// running gives ErrIdempotencyKeyInUse, and one used for a different request ErrIdempotencyKeyReused.
+func (s *IdempotencyStore) Begin(ctx context.Context, userID int64, key string, fingerprint []byte, retention time.Duration) (*IdempotentResponse, error) {
+	// an expired or abandoned key is taken over as if it were new
+	query := `
+	INSERT INTO idempotency_keys (user_id , key , fingerprint)
+	VALUES ($1 , $2 , $3)
+	ON CONFLICT (user_id , key) DO UPDATE
+	SET fingerprint = EXCLUDED.fingerprint , status = NULL , header = NULL , body = NULL , created_at = NOW()
+	WHERE idempotency_keys.created_at < $4
+	OR (idempotency_keys.status IS NULL AND idempotency_keys.created_at < $5)
+	RETURNING user_id
+	`
+	now := time.Now()
+	args := []any{userID, key, fingerprint, now.Add(-retention), now.Add(-IdempotencyLockTimeout)}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, query, args...).Scan(&userID)
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	query = `
+	SELECT fingerprint , status , header , body
+	FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2
+	`
+
+	var (
+		stored []byte
+		status sql.NullInt32
+		header []byte
+		resp   IdempotentResponse
+	)
+	err = s.DB.QueryRowContext(ctx, query, userID, key).Scan(&stored, &status, &header, &resp.Body)
+	if err != nil {
+		switch {
+		// the request holding the key failed and released it in the meantime
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrIdempotencyKeyInUse
+		default:
+			return nil, err
+		}
+	}
+
+	switch {
+	case !bytes.Equal(stored, fingerprint):
+		return nil, ErrIdempotencyKeyReused
+	case !status.Valid:
+		return nil, ErrIdempotencyKeyInUse
+	}
+
+	resp.Status = int(status.Int32)
+	if err := json.Unmarshal(header, &resp.Header); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Complete stores the response of the request holding key, to be replayed from now on
+func (s *IdempotencyStore) Complete(ctx context.Context, userID int64, key string, resp *IdempotentResponse) error {
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE idempotency_keys
+	SET status = $1 , header = $2 , body = $3
+	WHERE user_id = $4 AND key = $5
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	_, err = s.DB.ExecContext(ctx, query, resp.Status, header, resp.Body, userID, key)
+	return err
+}
+
+// Release gives up a key whose request did not complete, so that a retry runs it again
+func (s *IdempotencyStore) Release(ctx context.Context, userID int64, key string) error {
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2 AND status IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+// DeleteExpired removes the keys older than the retention window and returns how many it removed
+func (s *IdempotencyStore) DeleteExpired(ctx context.Context, retention time.Duration) (int64, error) {
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE created_at < $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,373 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+
+	ImportModeSkip   = "skip"
+	ImportModeUpsert = "upsert"
+	ImportModeDryRun = "dry-run"
+
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+// a single batch gets more time than a normal query because it copies thousands of rows
+var ImportBatchTimeDuration = 30 * time.Second
+
+type ImportRowError struct {
+	Line   int               `json:"line"`
+	Errors map[string]string `json:"errors"`
+}
+
+type ImportJob struct {
+	ID         int64            `json:"id"`
+	CreatedAt  time.Time        `json:"created_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+	CreatedBy  *int64           `json:"created_by,omitempty"`
+	Format     string           `json:"format"`
+	Mode       string           `json:"mode"`
+	Status     string           `json:"status"`
+	Processed  int              `json:"processed"`
+	Inserted   int              `json:"inserted"`
+	Updated    int              `json:"updated"`
+	Skipped    int              `json:"skipped"`
+	Failed     int              `json:"failed"`
+	RowErrors  []ImportRowError `json:"row_errors"`
+	Message    string           `json:"message,omitempty"`
+}
+
+// ImportRow is a parsed and validated movie waiting to be written in a batch
+type ImportRow struct {
+	Line       int
+	Source     string
+	ExternalID string
+	Movie      Movie
+}
+
+type ImportResult struct {
+	Inserted int
+	Updated  int
+	Skipped  int
+}
+
+func ValidateImportJob(v *validator.Validator, job *ImportJob) {
+	v.Check(validator.PermittedValue(job.Format, ImportFormatCSV, ImportFormatNDJSON), "format", validator.CodeNotPermitted, "must be csv or ndjson")
+	v.Check(validator.PermittedValue(job.Mode, ImportModeSkip, ImportModeUpsert, ImportModeDryRun), "mode", validator.CodeNotPermitted, "must be skip, upsert or dry-run")
+}
+
+type ImportStore struct {
+	DB        *sql.DB
+	Observers []MovieObserver
+}
+
+func (s *ImportStore) CreateJob(ctx context.Context, job *ImportJob) error {
+	query := `
+		INSERT INTO import_jobs (created_by , format , mode , status)
+		VALUES ($1 , $2 , $3 , $4)
+		RETURNING id , created_at
+	`
+	job.Status = ImportStatusPending
+	job.RowErrors = []ImportRowError{}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	return s.DB.QueryRowContext(ctx, query, job.CreatedBy, job.Format, job.Mode, job.Status).Scan(&job.ID, &job.CreatedAt)
+}
+
+func (s *ImportStore) GetJob(ctx context.Context, id int64) (*ImportJob, error) {
+	if id < 1 {
+		return nil, ErrorNotFound
+	}
+	query := `
+		SELECT id , created_at , finished_at , created_by , format , mode , status ,
+		processed , inserted , updated , skipped , failed , row_errors , message
+		FROM import_jobs WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	var (
+		job       ImportJob
+		rowErrors []byte
+	)
+	err := s.DB.QueryRowContext(ctx, query, id).Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.FinishedAt,
+		&job.CreatedBy,
+		&job.Format,
+		&job.Mode,
+		&job.Status,
+		&job.Processed,
+		&job.Inserted,
+		&job.Updated,
+		&job.Skipped,
+		&job.Failed,
+		&rowErrors,
+		&job.Message,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(rowErrors, &job.RowErrors); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// UpdateJob saves the progress counters, the collected row errors and the status of the job
+func (s *ImportStore) UpdateJob(ctx context.Context, job *ImportJob) error {
+	rowErrors, err := json.Marshal(job.RowErrors)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE import_jobs
+		SET status = $1 , processed = $2 , inserted = $3 , updated = $4 , skipped = $5 , failed = $6 ,
+		row_errors = $7 , message = $8 , finished_at = $9
+		WHERE id = $10
+	`
+	args := []any{
+		job.Status,
+		job.Processed,
+		job.Inserted,
+		job.Updated,
+		job.Skipped,
+		job.Failed,
+		rowErrors,
+		job.Message,
+		job.FinishedAt,
+		job.ID,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	_, err = s.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// InsertBatch copies the rows into a temporary table with COPY and merges them into movies in a
+// single transaction. Rows whose external ID is already known are updated in upsert mode and skipped
+// otherwise. Every write is recorded in the movie revision history, and observers hear about the
+// movies written once the transaction has committed.
+func (s *ImportStore) InsertBatch(ctx context.Context, rows []ImportRow, mode string, authorID *int64) (ImportResult, error) {
+	var result ImportResult
+
+	ctx, cancel := context.WithTimeout(ctx, ImportBatchTimeDuration)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		CREATE TEMP TABLE import_rows (
+			line INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			external_id TEXT NOT NULL,
+			title TEXT NOT NULL,
+			year INTEGER NOT NULL,
+			runtime INTEGER NOT NULL,
+			genres TEXT[] NOT NULL,
+			movie_id BIGINT,
+			is_new BOOLEAN NOT NULL DEFAULT FALSE,
+			is_updated BOOLEAN NOT NULL DEFAULT FALSE
+		) ON COMMIT DROP
+	`)
+	if err != nil {
+		return result, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("import_rows", "line", "source", "external_id", "title", "year", "runtime", "genres"))
+	if err != nil {
+		return result, err
+	}
+	for _, row := range rows {
+		_, err := stmt.ExecContext(ctx, row.Line, row.Source, row.ExternalID, row.Movie.Title, row.Movie.Year, row.Movie.Runtime, pq.Array(row.Movie.Genres))
+		if err != nil {
+			stmt.Close()
+			return result, err
+		}
+	}
+	// an Exec without arguments flushes the buffered COPY data
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return result, err
+	}
+	if err := stmt.Close(); err != nil {
+		return result, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE import_rows r SET movie_id = e.movie_id
+		FROM movie_external_ids e
+		WHERE r.external_id <> '' AND e.source = r.source AND e.external_id = r.external_id
+	`)
+	if err != nil {
+		return result, err
+	}
+
+	var existing int
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM import_rows WHERE movie_id IS NOT NULL`).Scan(&existing)
+	if err != nil {
+		return result, err
+	}
+
+	if mode == ImportModeUpsert && existing > 0 {
+		res, err := tx.ExecContext(ctx, `
+			WITH old AS (
+				SELECT m.id , m.title , m.year , m.runtime , m.genres
+				FROM movies m INNER JOIN import_rows r ON r.movie_id = m.id
+				FOR UPDATE OF m
+			), upd AS (
+				UPDATE movies m
+				SET title = r.title , year = r.year , runtime = r.runtime , genres = r.genres , version = m.version + 1
+				FROM import_rows r
+				WHERE r.movie_id = m.id
+				AND (m.title , m.year , m.runtime , m.genres) IS DISTINCT FROM (r.title , r.year , r.runtime , r.genres)
+				RETURNING m.id , m.version , m.title , m.year , m.runtime , m.genres
+			), marked AS (
+				UPDATE import_rows r SET is_updated = TRUE FROM upd WHERE r.movie_id = upd.id
+			)
+			INSERT INTO movie_revisions (movie_id , version , action , author_id , title , year , runtime , genres , changes)
+			SELECT upd.id , upd.version , 'update' , $1 , upd.title , upd.year , upd.runtime , upd.genres ,
+			jsonb_strip_nulls(jsonb_build_object(
+				'title' , CASE WHEN old.title <> upd.title THEN jsonb_build_object('from' , old.title , 'to' , upd.title) END ,
+				'year' , CASE WHEN old.year <> upd.year THEN jsonb_build_object('from' , old.year , 'to' , upd.year) END ,
+				'runtime' , CASE WHEN old.runtime <> upd.runtime THEN jsonb_build_object('from' , old.runtime || ' mins' , 'to' , upd.runtime || ' mins') END ,
+				'genres' , CASE WHEN old.genres <> upd.genres THEN jsonb_build_object('from' , to_jsonb(old.genres) , 'to' , to_jsonb(upd.genres)) END
+			))
+			FROM upd INNER JOIN old ON old.id = upd.id
+		`, authorID)
+		if err != nil {
+			return result, err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return result, err
+		}
+		result.Updated = int(updated)
+	}
+	result.Skipped = existing - result.Updated
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE import_rows SET movie_id = nextval(pg_get_serial_sequence('movies' , 'id')) , is_new = TRUE
+		WHERE movie_id IS NULL
+	`)
+	if err != nil {
+		return result, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO movies (id , title , year , runtime , genres)
+		SELECT movie_id , title , year , runtime , genres FROM import_rows WHERE is_new ORDER BY line
+	`)
+	if err != nil {
+		return result, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return result, err
+	}
+	result.Inserted = int(inserted)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO movie_external_ids (movie_id , source , external_id)
+		SELECT movie_id , source , external_id FROM import_rows WHERE is_new AND external_id <> ''
+	`)
+	if err != nil {
+		return result, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO movie_revisions (movie_id , version , action , author_id , title , year , runtime , genres , changes)
+		SELECT movie_id , 1 , 'create' , $1 , title , year , runtime , genres ,
+		jsonb_build_object(
+			'title' , jsonb_build_object('from' , NULL , 'to' , title) ,
+			'year' , jsonb_build_object('from' , NULL , 'to' , year) ,
+			'runtime' , jsonb_build_object('from' , NULL , 'to' , runtime || ' mins') ,
+			'genres' , jsonb_build_object('from' , NULL , 'to' , to_jsonb(genres))
+		)
+		FROM import_rows WHERE is_new
+	`, authorID)
+	if err != nil {
+		return result, err
+	}
+
+	written, err := s.written(ctx, tx)
+	if err != nil {
+		return result, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+	for _, o := range s.Observers {
+		if batch, ok := o.(MovieBatchObserver); ok {
+			batch.MoviesSaved(written)
+			continue
+		}
+		for _, movie := range written {
+			o.MovieSaved(movie)
+		}
+	}
+	return result, nil
+}
+
+// written reads back the movies inserted or updated by the batch in tx
+func (s *ImportStore) written(ctx context.Context, tx *sql.Tx) ([]*Movie, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT m.id , m.created_at , m.title , m.year , m.runtime , m.genres , m.version
+		FROM movies m INNER JOIN import_rows r ON r.movie_id = m.id
+		WHERE r.is_new OR r.is_updated
+		ORDER BY r.line
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movies []*Movie
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	return movies, rows.Err()
+}
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/AmiyoKm/green_light/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	// LocaleRX matches lower case BCP 47 language tags such as fr, pt-br or zh-hant-tw
+	LocaleRX = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+	// CountryRX matches ISO 3166-1 alpha-2 country codes
+	CountryRX = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// MovieRelease is the release of a movie in one country
+type MovieRelease struct {
+	Country     string `json:"country"`
+	ReleaseDate string `json:"release_date"`
+	// Certification is the age rating given in the country, such as PG-13 or FSK 12
+	Certification string `json:"certification,omitempty"`
+}
+
+// Localizations holds the alternate titles of a movie keyed by locale and its regional releases
+type Localizations struct {
+	Titles   map[string]string `json:"titles"`
+	Releases []MovieRelease    `json:"releases"`
+}
+
+func ValidateLocalizations(v *validator.Validator, l *Localizations) {
+	v.Check(len(l.Titles) <= 100, "titles", validator.CodeTooMany, "must not contain more than 100 locales")
+	for locale, title := range l.Titles {
+		v.Check(validator.Matches(locale, LocaleRX), "titles", validator.CodeInvalidFormat, fmt.Sprintf("locale %q must be a lower case language tag such as fr or pt-br", locale))
+		v.Check(strings.TrimSpace(title) != "", "titles", validator.CodeRequired, fmt.Sprintf("title for %q must be provided", locale))
+		v.Check(len(title) <= 500, "titles", validator.CodeInvalidLength, fmt.Sprintf("title for %q must not be more than 500 bytes long", locale))
+	}
+
+	v.Check(len(l.Releases) <= 250, "releases", validator.CodeTooMany, "must not contain more than 250 releases")
+	countries := make(map[string]bool, len(l.Releases))
+	for _, release := range l.Releases {
+		v.Check(validator.Matches(release.Country, CountryRX), "releases", validator.CodeInvalidFormat, fmt.Sprintf("country %q must be an upper case ISO 3166-1 alpha-2 code", release.Country))
+		v.Check(!countries[release.Country], "releases", validator.CodeDuplicate, fmt.Sprintf("country %q must not be listed more than once", release.Country))
+		countries[release.Country] = true
+
+		_, err := time.Parse(time.DateOnly, release.ReleaseDate)
+		v.Check(err == nil, "releases", validator.CodeInvalidFormat, fmt.Sprintf("release_date for %q must be a date such as 2006-01-02", release.Country))
+		v.Check(len(release.Certification) <= 20, "releases", validator.CodeInvalidLength, fmt.Sprintf("certification for %q must not be more than 20 bytes long", release.Country))
+	}
+}
+
+func (s *MovieStore) GetLocalizations(ctx context.Context, movieID int64) (*Localizations, error) {
+	localizations, err := s.GetLocalizationsForMovies(ctx, []int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+	return localizations[movieID], nil
+}
+
+// GetLocalizationsForMovies returns the localizations of several movies in two queries, with
+// empty localizations for every movie that has none
+func (s *MovieStore) GetLocalizationsForMovies(ctx context.Context, movieIDs []int64) (map[int64]*Localizations, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	localizations := make(map[int64]*Localizations, len(movieIDs))
+	for _, movieID := range movieIDs {
+		localizations[movieID] = &Localizations{Titles: map[string]string{}, Releases: []MovieRelease{}}
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT movie_id , locale , title FROM movie_titles WHERE movie_id = ANY($1)`, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			movieID       int64
+			locale, title string
+		)
+		if err := rows.Scan(&movieID, &locale, &title); err != nil {
+			return nil, err
+		}
+		localizations[movieID].Titles[locale] = title
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.DB.QueryContext(ctx, `
+	SELECT movie_id , country , to_char(release_date , 'YYYY-MM-DD') , certification
+	FROM movie_releases
+	WHERE movie_id = ANY($1)
+	ORDER BY release_date ASC , country ASC
+	`, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			movieID int64
+			release MovieRelease
+		)
+		if err := rows.Scan(&movieID, &release.Country, &release.ReleaseDate, &release.Certification); err != nil {
+			return nil, err
+		}
+		l := localizations[movieID]
+		l.Releases = append(l.Releases, release)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return localizations, nil
+}
+
+// ReplaceLocalizations swaps the alternate titles and releases of the movie for the given ones.
+// It counts as an edit of the movie, so the version is checked and bumped like in Update, and the
+// caller records the revision of the new version in the same transaction.
+func (s *MovieStore) ReplaceLocalizations(ctx context.Context, movie *Movie, l *Localizations) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	// the statements share the transaction of the store when it has one, and get their own otherwise
+	tx := s.Tx
+	if tx == nil {
+		var err error
+		tx, err = s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	}
+
+	locales := make([]string, 0, len(l.Titles))
+	titles := make([]string, 0, len(l.Titles))
+	for locale, title := range l.Titles {
+		locales = append(locales, locale)
+		titles = append(titles, title)
+	}
+
+	err := tx.QueryRowContext(ctx, `
+	UPDATE movies
+	SET alternate_titles = array_to_string($1::text[] , ' ') , version = version + 1
+	WHERE id = $2 AND version = $3
+	RETURNING version
+	`, pq.Array(titles), movie.ID, movie.Version).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM movie_titles WHERE movie_id = $1`, movie.ID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO movie_titles (movie_id , locale , title)
+	SELECT $1 , locale , title FROM unnest($2::text[] , $3::text[]) AS t (locale , title)
+	`, movie.ID, pq.Array(locales), pq.Array(titles))
+	if err != nil {
+		return err
+	}
+
+	countries := make([]string, 0, len(l.Releases))
+	dates := make([]string, 0, len(l.Releases))
+	certifications := make([]string, 0, len(l.Releases))
+	for _, release := range l.Releases {
+		countries = append(countries, release.Country)
+		dates = append(dates, release.ReleaseDate)
+		certifications = append(certifications, release.Certification)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM movie_releases WHERE movie_id = $1`, movie.ID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO movie_releases (movie_id , country , release_date , certification)
+	SELECT $1 , country , release_date::date , certification
+	FROM unnest($2::text[] , $3::text[] , $4::text[]) AS r (country , release_date , certification)
+	`, movie.ID, pq.Array(countries), pq.Array(dates), pq.Array(certifications))
+	if err != nil {
+		return err
+	}
+
+	if s.Tx == nil {
+		return tx.Commit()
+	}
+	return nil
+}
+
+// GetTitles returns the alternate titles of the movies in the given locales, keyed by movie id and then locale
+func (s *MovieStore) GetTitles(ctx context.Context, movieIDs []int64, locales []string) (map[int64]map[string]string, error) {
+	titles := make(map[int64]map[string]string)
+	if len(movieIDs) == 0 || len(locales) == 0 {
+		return titles, nil
+	}
+
+	query := `
+	SELECT movie_id , locale , title
+	FROM movie_titles
+	WHERE movie_id = ANY($1) AND locale = ANY($2)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, pq.Array(movieIDs), pq.Array(locales))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id            int64
+			locale, title string
+		)
+		if err := rows.Scan(&id, &locale, &title); err != nil {
+			return nil, err
+		}
+		if titles[id] == nil {
+			titles[id] = make(map[string]string)
+		}
+		titles[id][locale] = title
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
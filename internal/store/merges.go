@@ -0,0 +1,196 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// DuplicateCandidate is a pair of movies that look like the same movie entered twice
+type DuplicateCandidate struct {
+	Movie     *Movie  `json:"movie"`
+	Duplicate *Movie  `json:"duplicate"`
+	Score     float64 `json:"score"`
+}
+
+// GetDuplicateCandidates pairs movies whose titles are at least threshold similar by trigrams,
+// released at most a year apart and with runtimes within 10 minutes of each other. The pairs are
+// scored on all three and listed best first, the older movie of each pair being the Movie.
+func (s *MovieStore) GetDuplicateCandidates(ctx context.Context, threshold float64, filters Filters) ([]DuplicateCandidate, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer tx.Rollback()
+
+	// the % operator, which is what movies_title_trgm_idx answers, compares against this setting
+	_, err = tx.ExecContext(ctx, `SELECT set_config('pg_trgm.similarity_threshold' , $1 , true)`, fmt.Sprint(threshold))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := `
+	SELECT count(*) OVER() , score ,
+		a.id , a.created_at , a.title , a.year , a.runtime , a.genres , a.version ,
+		b.id , b.created_at , b.title , b.year , b.runtime , b.genres , b.version
+	FROM (
+		SELECT a.id AS a_id , b.id AS b_id ,
+			0.6 * similarity(a.title , b.title)
+			+ 0.2 * (1 - abs(a.year - b.year))
+			+ 0.2 * (1 - abs(a.runtime - b.runtime) / 10.0) AS score
+		FROM movies AS a
+		INNER JOIN movies AS b ON b.title % a.title AND b.id > a.id
+		WHERE abs(a.year - b.year) <= 1
+		AND abs(a.runtime - b.runtime) <= 10
+	) AS pairs
+	INNER JOIN movies AS a ON a.id = pairs.a_id
+	INNER JOIN movies AS b ON b.id = pairs.b_id
+	ORDER BY score DESC , a.id ASC , b.id ASC
+	LIMIT $1 OFFSET $2
+	`
+
+	rows, err := tx.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	candidates := []DuplicateCandidate{}
+
+	for rows.Next() {
+		c := DuplicateCandidate{Movie: &Movie{}, Duplicate: &Movie{}}
+		err := rows.Scan(
+			&totalRecords,
+			&c.Score,
+			&c.Movie.ID,
+			&c.Movie.CreatedAt,
+			&c.Movie.Title,
+			&c.Movie.Year,
+			&c.Movie.Runtime,
+			pq.Array(&c.Movie.Genres),
+			&c.Movie.Version,
+			&c.Duplicate.ID,
+			&c.Duplicate.CreatedAt,
+			&c.Duplicate.Title,
+			&c.Duplicate.Year,
+			&c.Duplicate.Runtime,
+			pq.Array(&c.Duplicate.Genres),
+			&c.Duplicate.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		candidates = append(candidates, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return candidates, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Merge folds the duplicate movie into the survivor and deletes it. The external IDs, collection
+// entries, alternate titles and releases of the duplicate move over wherever the survivor has none
+// of its own, and requests for the duplicate's id are redirected to the survivor from then on.
+// The survivor's version is bumped and the movie is updated in place.
+func (s *MovieStore) Merge(ctx context.Context, duplicateID int64, survivor *Movie) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// both rows are locked in id order so that two merges of the same pair cannot deadlock
+	var locked int
+	err = tx.QueryRowContext(ctx, `
+	SELECT count(*) FROM (
+		SELECT id FROM movies WHERE id IN ($1 , $2) ORDER BY id FOR UPDATE
+	) AS movies
+	`, duplicateID, survivor.ID).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if locked != 2 {
+		return ErrorNotFound
+	}
+
+	statements := []string{
+		`UPDATE movie_external_ids SET movie_id = $2
+		WHERE movie_id = $1 AND source NOT IN (SELECT source FROM movie_external_ids WHERE movie_id = $2)`,
+
+		`UPDATE collection_movies SET movie_id = $2
+		WHERE movie_id = $1 AND collection_id NOT IN (SELECT collection_id FROM collection_movies WHERE movie_id = $2)`,
+
+		`UPDATE movie_titles SET movie_id = $2
+		WHERE movie_id = $1 AND locale NOT IN (SELECT locale FROM movie_titles WHERE movie_id = $2)`,
+
+		`UPDATE movie_releases SET movie_id = $2
+		WHERE movie_id = $1 AND country NOT IN (SELECT country FROM movie_releases WHERE movie_id = $2)`,
+
+		// movies merged into the duplicate earlier now lead to the survivor as well
+		`UPDATE movie_redirects SET movie_id = $2 WHERE movie_id = $1`,
+
+		`INSERT INTO movie_redirects (old_id , movie_id) VALUES ($1 , $2)`,
+
+		`DELETE FROM movies WHERE id = $1`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, duplicateID, survivor.ID); err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `
+	UPDATE movies
+	SET alternate_titles = coalesce((SELECT string_agg(title , ' ') FROM movie_titles WHERE movie_id = $1) , '') ,
+		version = version + 1
+	WHERE id = $1 AND version = $2
+	RETURNING version
+	`, survivor.ID, survivor.Version).Scan(&survivor.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, o := range s.Observers {
+		o.MovieDeleted(duplicateID)
+	}
+	s.notifySaved(survivor)
+	return nil
+}
+
+// GetRedirect returns the id of the movie that the movie with the given id was merged into
+func (s *MovieStore) GetRedirect(ctx context.Context, id int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	var movieID int64
+	err := s.DB.QueryRowContext(ctx, `SELECT movie_id FROM movie_redirects WHERE old_id = $1`, id).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrorNotFound
+		default:
+			return 0, err
+		}
+	}
+	return movieID, nil
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/AmiyoKm/green_light/internal/validator"
@@ -19,10 +20,45 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitempty"`
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
+	// OriginalTitle is only set when Title has been replaced by a localized title for the response
+	OriginalTitle string `json:"original_title,omitempty"`
+	// Relevance is only set when the movie was found by a q= search
+	Relevance float64 `json:"relevance,omitempty"`
+}
+
+// MovieObserver is told about every movie written through the MovieStore,
+// e.g. to keep an in-memory index in sync
+type MovieObserver interface {
+	MovieSaved(movie *Movie)
+	MovieDeleted(id int64)
+}
+
+// MovieBatchObserver is a MovieObserver that takes the movies of a bulk write, such as an import
+// batch, in one call rather than one call per movie
+type MovieBatchObserver interface {
+	MovieObserver
+	MoviesSaved(movies []*Movie)
 }
 
 type MovieStore struct {
-	DB *sql.DB
+	DB        *sql.DB
+	Observers []MovieObserver
+	// Tx is set on the stores of a TxStorage, see conn
+	Tx *sql.Tx
+}
+
+// conn returns the transaction the store is bound to, if any, for the methods that TxStorage offers
+func (s *MovieStore) conn() querier {
+	if s.Tx != nil {
+		return s.Tx
+	}
+	return s.DB
+}
+
+func (s *MovieStore) notifySaved(movie *Movie) {
+	for _, o := range s.Observers {
+		o.MovieSaved(movie)
+	}
 }
 
 func (s *MovieStore) Create(ctx context.Context, movie *Movie) error {
@@ -36,7 +72,12 @@ func (s *MovieStore) Create(ctx context.Context, movie *Movie) error {
 
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	return s.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := s.conn().QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+	s.notifySaved(movie)
+	return nil
 }
 func (s *MovieStore) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
@@ -51,7 +92,7 @@ func (s *MovieStore) Get(ctx context.Context, id int64) (*Movie, error) {
 	defer cancel()
 
 	movie := &Movie{}
-	err := s.DB.QueryRowContext(ctx, query, id).Scan(
+	err := s.conn().QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -81,7 +122,7 @@ func (s *MovieStore) Update(ctx context.Context, movie *Movie) error {
 	defer cancel()
 
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID, movie.Version}
-	err := s.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := s.conn().QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 
 	if err != nil {
 		switch err {
@@ -91,48 +132,116 @@ func (s *MovieStore) Update(ctx context.Context, movie *Movie) error {
 			return err
 		}
 	}
+	s.notifySaved(movie)
 	return nil
 }
-func (s *MovieStore) Delete(ctx context.Context, id int64) error {
-	if id < 1 {
+
+// Delete removes the movie as long as it is still at the version it was read at, and returns
+// ErrEditConflict otherwise, so that what was read of it is what was deleted
+func (s *MovieStore) Delete(ctx context.Context, movie *Movie) error {
+	if movie.ID < 1 {
 		return ErrorNotFound
 	}
 	query := `
-		DELETE FROM movies where id = $1
+		DELETE FROM movies WHERE id = $1 AND version = $2
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
 	defer cancel()
 
-	result, err := s.DB.ExecContext(ctx, query, id)
+	result, err := s.conn().ExecContext(ctx, query, movie.ID, movie.Version)
 	if err != nil {
 		return err
 	}
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return nil
+		return err
 	}
 	if rowsAffected == 0 {
-		return ErrorNotFound
+		return ErrEditConflict
+	}
+	for _, o := range s.Observers {
+		o.MovieDeleted(movie.ID)
 	}
 	return nil
 }
 
-func (s *MovieStore) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// MovieFields are the fields of a movie that a listing can be limited to
+var MovieFields = []string{"id", "title", "year", "runtime", "genres", "version"}
+
+// movieColumns returns the columns a listing reads for filter.Fields. id is always read, and so
+// are the sort columns since the cursor of a page is built from them.
+func movieColumns(filter MovieFilter, filters Filters) []string {
+	if len(filter.Fields) == 0 {
+		return []string{"id", "created_at", "title", "year", "runtime", "genres", "version"}
+	}
+
+	read := map[string]bool{"id": true}
+	for _, field := range filter.Fields {
+		read[field] = true
+	}
+	for _, key := range filters.sortKeys() {
+		read[key.column] = true
+	}
+
+	var columns []string
+	for _, field := range MovieFields {
+		if read[field] {
+			columns = append(columns, field)
+		}
+	}
+	return columns
+}
+
+// movieDest returns the scan destinations in movie for the columns
+func movieDest(movie *Movie, columns []string) []any {
+	dest := make([]any, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "id":
+			dest[i] = &movie.ID
+		case "created_at":
+			dest[i] = &movie.CreatedAt
+		case "title":
+			dest[i] = &movie.Title
+		case "year":
+			dest[i] = &movie.Year
+		case "runtime":
+			dest[i] = &movie.Runtime
+		case "genres":
+			dest[i] = pq.Array(&movie.Genres)
+		case "version":
+			dest[i] = &movie.Version
+		}
+	}
+	return dest
+}
+
+func (s *MovieStore) GetAll(ctx context.Context, filter MovieFilter, filters Filters) ([]*Movie, Metadata, error) {
+	return s.getAll(ctx, s.DB, filter, filters)
+}
+
+func (s *MovieStore) getAll(ctx context.Context, q querier, filter MovieFilter, filters Filters) ([]*Movie, Metadata, error) {
+	if filters.UseCursor {
+		return s.getAllByCursor(ctx, q, filter, filters)
+	}
+
+	where, relevance, args := filter.build(nil)
+	args = append(args, filters.limit(), filters.offset())
+	columns := movieColumns(filter, filters)
+
 	query := fmt.Sprintf(`
-	SELECT count(*) over() , id , created_at , title , year , runtime , genres , version
+	SELECT count(*) over() , %s , %s AS relevance
 	FROM movies
-	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple',$1) OR $1 = '')
-	AND (genres @> $2 OR $2 = '{}')
-	ORDER BY %s %s , id ASC
-	LIMIT $3 OFFSET $4
-	`, filters.sortColumn(), filters.sortDirection())
+	WHERE %s
+	ORDER BY %s
+	LIMIT $%d OFFSET $%d
+	`, strings.Join(columns, " , "), relevance, where, filters.orderBy(), len(args)-1, len(args))
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
 	defer cancel()
 
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
-	rows, err := s.DB.QueryContext(ctx, query, args...)
+	rows, err := q.QueryContext(ctx, query, args...)
 
 	if err != nil {
 		return nil, Metadata{}, err
@@ -145,16 +254,8 @@ func (s *MovieStore) GetAll(ctx context.Context, title string, genres []string,
 	for rows.Next() {
 		var movie Movie
 
-		err := rows.Scan(
-			&totalRecords,
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		dest := append([]any{&totalRecords}, movieDest(&movie, columns)...)
+		err := rows.Scan(append(dest, &movie.Relevance)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -169,19 +270,196 @@ func (s *MovieStore) GetAll(ctx context.Context, title string, genres []string,
 
 }
 
+// getAllByCursor returns the page of movies after filters.Cursor. It seeks past the cursor with
+// a WHERE clause instead of an OFFSET, so deep pages cost the same as the first one and rows
+// inserted during a crawl do not shift later pages.
+func (s *MovieStore) getAllByCursor(ctx context.Context, q querier, filter MovieFilter, filters Filters) ([]*Movie, Metadata, error) {
+	where, relevance, args := filter.build(nil)
+	filterArgs := args
+
+	if filters.Cursor != "" {
+		cursor, err := DecodeCursor(filters.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		condition, keysetArgs := filters.keysetCondition(cursor, len(args)+1)
+		args = append(args, keysetArgs...)
+		where += " AND " + condition
+	}
+	args = append(args, filters.limit()+1)
+	columns := movieColumns(filter, filters)
+
+	query := fmt.Sprintf(`
+	SELECT %s , %s AS relevance
+	FROM movies
+	WHERE %s
+	ORDER BY %s
+	LIMIT $%d
+	`, strings.Join(columns, " , "), relevance, where, filters.orderBy(), len(args))
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(append(movieDest(&movie, columns), &movie.Relevance)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+
+	// one row more than the page size was fetched to find out whether there is a next page
+	if len(movies) > filters.limit() {
+		movies = movies[:filters.limit()]
+		metadata.NextCursor = filters.cursorFor(movies[len(movies)-1]).Encode()
+	}
+
+	if filters.IncludeTotal {
+		where, _, _ := filter.build(nil)
+		query := fmt.Sprintf(`SELECT count(*) FROM movies WHERE %s`, where)
+
+		err := q.QueryRowContext(ctx, query, filterArgs...).Scan(&metadata.TotalRecord)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	return movies, metadata, nil
+}
+
+// SuggestTitles returns the titles closest to q by trigram similarity. It backs up the in-memory
+// suggestion index for misspelled queries.
+func (s *MovieStore) SuggestTitles(ctx context.Context, q string, limit int) ([]*Movie, error) {
+	query := `
+	SELECT id , title , year
+	FROM movies
+	WHERE title % $1
+	ORDER BY similarity(title , $1) DESC , id ASC
+	LIMIT $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+		if err := rows.Scan(&movie.ID, &movie.Title, &movie.Year); err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// number of rows fetched from the export cursor at a time
+const exportFetchSize = 500
+
+// Export walks every movie matching the filter with a server-side cursor and calls fn for each
+// one in id order. Rows are fetched in small batches so memory use stays flat however large the
+// catalogue is. The cursor runs in a read-only REPEATABLE READ transaction so the export sees a
+// single snapshot.
+func (s *MovieStore) Export(ctx context.Context, filter MovieFilter, fn func(*Movie) error) error {
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	where, _, args := filter.build(nil)
+
+	query := fmt.Sprintf(`
+	DECLARE movies_export NO SCROLL CURSOR FOR
+	SELECT id , created_at , title , year , runtime , genres , version
+	FROM movies
+	WHERE %s
+	ORDER BY id ASC
+	`, where)
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM movies_export", exportFetchSize)
+
+	for {
+		rows, err := tx.QueryContext(ctx, fetch)
+		if err != nil {
+			return err
+		}
+
+		fetched := 0
+		for rows.Next() {
+			var movie Movie
+
+			err := rows.Scan(
+				&movie.ID,
+				&movie.CreatedAt,
+				&movie.Title,
+				&movie.Year,
+				&movie.Runtime,
+				pq.Array(&movie.Genres),
+				&movie.Version,
+			)
+			if err == nil {
+				err = fn(&movie)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			fetched++
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		if fetched < exportFetchSize {
+			break
+		}
+	}
+
+	return tx.Commit()
+}
+
 func ValidateMovie(v *validator.Validator, movie *Movie) {
-	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(movie.Title != "", "title", validator.CodeRequired, "must be provided")
+	v.Check(len(movie.Title) <= 500, "title", validator.CodeInvalidLength, "must not be more than 500 bytes long")
 
-	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+	v.Check(movie.Year != 0, "year", validator.CodeRequired, "must be provided")
+	v.Check(movie.Year >= 1888, "year", validator.CodeTooSmall, "must be greater than 1888")
+	v.Check(movie.Year <= int32(time.Now().Year()), "year", validator.CodeTooLarge, "must not be in the future")
 
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
+	v.Check(movie.Runtime != 0, "runtime", validator.CodeRequired, "must be provided")
+	v.Check(movie.Runtime > 0, "runtime", validator.CodeTooSmall, "must be a positive integer")
 
-	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	v.Check(movie.Genres != nil, "genres", validator.CodeRequired, "must be provided")
+	v.Check(len(movie.Genres) >= 1, "genres", validator.CodeTooFew, "must contain at least 1 genre")
+	v.Check(len(movie.Genres) <= 5, "genres", validator.CodeTooMany, "must not contain more than 5 genres")
+	v.Check(validator.Unique(movie.Genres), "genres", validator.CodeDuplicate, "must not contain duplicate values")
 }
@@ -0,0 +1,255 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	RevisionActionCreate   = "create"
+	RevisionActionUpdate   = "update"
+	RevisionActionDelete   = "delete"
+	RevisionActionRevert   = "revert"
+	RevisionActionMerge    = "merge"
+	RevisionActionLocalize = "localize"
+)
+
+// FieldChange holds the old and new value of a single movie field
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+type MovieRevision struct {
+	ID        int64                  `json:"-"`
+	MovieID   int64                  `json:"movie_id"`
+	Version   int32                  `json:"version"`
+	Action    string                 `json:"action"`
+	AuthorID  *int64                 `json:"author_id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Movie     Movie                  `json:"movie"`
+	Changes   map[string]FieldChange `json:"changes"`
+}
+
+// NewMovieRevision builds a revision from the state of the movie before and after a change.
+// before is nil for a create and after is nil for a delete.
+func NewMovieRevision(action string, authorID int64, before, after *Movie) *MovieRevision {
+	rev := &MovieRevision{
+		Action:  action,
+		Changes: diffMovies(before, after),
+	}
+	if authorID > 0 {
+		rev.AuthorID = &authorID
+	}
+
+	switch {
+	case after != nil:
+		rev.MovieID = after.ID
+		rev.Version = after.Version
+		rev.Movie = *after
+	case before != nil:
+		// a delete has no new version, so it takes the next one to keep the history ordered
+		rev.MovieID = before.ID
+		rev.Version = before.Version + 1
+		rev.Movie = *before
+	}
+	return rev
+}
+
+// diffMovies returns the fields that differ between before and after, keyed by their JSON name
+func diffMovies(before, after *Movie) map[string]FieldChange {
+	var from, to Movie
+	if before != nil {
+		from = *before
+	}
+	if after != nil {
+		to = *after
+	}
+	fromOrNil := func(v any) any {
+		if before == nil {
+			return nil
+		}
+		return v
+	}
+	toOrNil := func(v any) any {
+		if after == nil {
+			return nil
+		}
+		return v
+	}
+
+	changes := make(map[string]FieldChange)
+
+	if before == nil || after == nil || from.Title != to.Title {
+		changes["title"] = FieldChange{From: fromOrNil(from.Title), To: toOrNil(to.Title)}
+	}
+	if before == nil || after == nil || from.Year != to.Year {
+		changes["year"] = FieldChange{From: fromOrNil(from.Year), To: toOrNil(to.Year)}
+	}
+	if before == nil || after == nil || from.Runtime != to.Runtime {
+		changes["runtime"] = FieldChange{From: fromOrNil(from.Runtime), To: toOrNil(to.Runtime)}
+	}
+	if before == nil || after == nil || !slices.Equal(from.Genres, to.Genres) {
+		changes["genres"] = FieldChange{From: fromOrNil(from.Genres), To: toOrNil(to.Genres)}
+	}
+	return changes
+}
+
+type RevisionStore struct {
+	DB *sql.DB
+	// Tx is set on the stores of a TxStorage
+	Tx *sql.Tx
+}
+
+func (s *RevisionStore) conn() querier {
+	if s.Tx != nil {
+		return s.Tx
+	}
+	return s.DB
+}
+
+func (s *RevisionStore) Create(ctx context.Context, rev *MovieRevision) error {
+	changes, err := json.Marshal(rev.Changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_revisions (movie_id , version , action , author_id , title , year , runtime , genres , changes)
+		VALUES ($1 , $2 , $3 , $4 , $5 , $6 , $7 , $8 , $9)
+		RETURNING id , created_at
+	`
+	args := []any{
+		rev.MovieID,
+		rev.Version,
+		rev.Action,
+		rev.AuthorID,
+		rev.Movie.Title,
+		rev.Movie.Year,
+		rev.Movie.Runtime,
+		pq.Array(rev.Movie.Genres),
+		changes,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	return s.conn().QueryRowContext(ctx, query, args...).Scan(&rev.ID, &rev.CreatedAt)
+}
+
+func (s *RevisionStore) Get(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrorNotFound
+	}
+	query := `
+		SELECT id , movie_id , version , action , author_id , created_at , title , year , runtime , genres , changes
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rev, err := scanRevision(s.DB.QueryRowContext(ctx, query, movieID, version))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	return rev, nil
+}
+
+func (s *RevisionStore) GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := `
+		SELECT count(*) over() , id , movie_id , version , action , author_id , created_at , title , year , runtime , genres , changes
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY version DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var (
+			rev     MovieRevision
+			changes []byte
+		)
+		err := rows.Scan(
+			&totalRecords,
+			&rev.ID,
+			&rev.MovieID,
+			&rev.Version,
+			&rev.Action,
+			&rev.AuthorID,
+			&rev.CreatedAt,
+			&rev.Movie.Title,
+			&rev.Movie.Year,
+			&rev.Movie.Runtime,
+			pq.Array(&rev.Movie.Genres),
+			&changes,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if err := json.Unmarshal(changes, &rev.Changes); err != nil {
+			return nil, Metadata{}, err
+		}
+		rev.Movie.ID = rev.MovieID
+		rev.Movie.Version = rev.Version
+		revisions = append(revisions, &rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return revisions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func scanRevision(row *sql.Row) (*MovieRevision, error) {
+	var (
+		rev     MovieRevision
+		changes []byte
+	)
+	err := row.Scan(
+		&rev.ID,
+		&rev.MovieID,
+		&rev.Version,
+		&rev.Action,
+		&rev.AuthorID,
+		&rev.CreatedAt,
+		&rev.Movie.Title,
+		&rev.Movie.Year,
+		&rev.Movie.Runtime,
+		pq.Array(&rev.Movie.Genres),
+		&changes,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(changes, &rev.Changes); err != nil {
+		return nil, err
+	}
+	rev.Movie.ID = rev.MovieID
+	rev.Movie.Version = rev.Version
+	return &rev, nil
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewMovieRevision(t *testing.T) {
+	moana := &Movie{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 1}
+	sequel := &Movie{ID: 1, Title: "Moana 2", Year: 2024, Runtime: 107, Genres: []string{"animation"}, Version: 2}
+
+	tests := []struct {
+		name          string
+		action        string
+		before, after *Movie
+		version       int32
+		changes       map[string]FieldChange
+	}{
+		{
+			name:    "create",
+			action:  RevisionActionCreate,
+			after:   moana,
+			version: 1,
+			changes: map[string]FieldChange{
+				"title":   {To: "Moana"},
+				"year":    {To: int32(2016)},
+				"runtime": {To: Runtime(107)},
+				"genres":  {To: []string{"animation"}},
+			},
+		},
+		{
+			name:    "update",
+			action:  RevisionActionUpdate,
+			before:  moana,
+			after:   sequel,
+			version: 2,
+			changes: map[string]FieldChange{
+				"title": {From: "Moana", To: "Moana 2"},
+				"year":  {From: int32(2016), To: int32(2024)},
+			},
+		},
+		{
+			name:    "update changing nothing",
+			action:  RevisionActionUpdate,
+			before:  sequel,
+			after:   sequel,
+			version: 2,
+			changes: map[string]FieldChange{},
+		},
+		{
+			// a delete takes the version after the last one to stay last in the history
+			name:    "delete",
+			action:  RevisionActionDelete,
+			before:  sequel,
+			version: 3,
+			changes: map[string]FieldChange{
+				"title":   {From: "Moana 2"},
+				"year":    {From: int32(2024)},
+				"runtime": {From: Runtime(107)},
+				"genres":  {From: []string{"animation"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		rev := NewMovieRevision(tt.action, 0, tt.before, tt.after)
+		if rev.MovieID != 1 || rev.Version != tt.version || rev.Action != tt.action {
+			t.Errorf("%s: got movie %d version %d action %s", tt.name, rev.MovieID, rev.Version, rev.Action)
+		}
+		if rev.AuthorID != nil {
+			t.Errorf("%s: got author %d for an anonymous change", tt.name, *rev.AuthorID)
+		}
+		if !reflect.DeepEqual(rev.Changes, tt.changes) {
+			t.Errorf("%s: got changes %#v, want %#v", tt.name, rev.Changes, tt.changes)
+		}
+	}
+}
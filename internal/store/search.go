@@ -0,0 +1,186 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/AmiyoKm/green_light/internal/validator"
+	"github.com/lib/pq"
+)
+
+// searchDocument is the text matched by title and q= searches: the original title followed by
+// the localized ones. It must stay identical to the expression of the movies_title_* indexes and
+// of movies_search_document_trgm_idx.
+const searchDocument = "title || ' ' || alternate_titles"
+
+// text search configurations that can be picked for stemming search queries.
+// each one needs a matching movies_title_<language>_idx index to be fast.
+var SearchLanguages = []string{"simple", "english", "french", "german", "italian", "portuguese", "spanish"}
+
+const (
+	GenresMatchAll = "all"
+	GenresMatchAny = "any"
+)
+
+// MovieFilter selects the movies returned by GetAll and Export. Zero values leave a filter out.
+type MovieFilter struct {
+	// Title matches every word of the title, or of one of its localized titles, with the 'simple' configuration
+	Title  string
+	Genres []string
+	// GenresMatch is GenresMatchAll to require every genre in Genres or GenresMatchAny for at least one
+	GenresMatch string
+	// GenresNot excludes movies having any of these genres
+	GenresNot []string
+
+	YearGTE      int32
+	YearLTE      int32
+	RuntimeMin   int32
+	RuntimeMax   int32
+	CreatedAfter time.Time
+
+	// Search is the q= relevance search. It matches stemmed words and word prefixes and falls back
+	// to trigram word similarity so that misspelled titles are still found.
+	Search string
+	// Language is the text search configuration used to stem Search, one of SearchLanguages
+	Language string
+
+	// Fields limits the columns read to these of MovieFields, every column is read when it is empty
+	Fields []string
+}
+
+func ValidateMovieFilter(v *validator.Validator, f MovieFilter) {
+	if f.GenresMatch != "" {
+		v.Check(validator.PermittedValue(f.GenresMatch, GenresMatchAll, GenresMatchAny), "genres_match", validator.CodeNotPermitted, "must be all or any")
+	}
+	v.Check(len(f.Genres) <= 20, "genres", validator.CodeTooMany, "must not contain more than 20 genres")
+	v.Check(len(f.GenresNot) <= 20, "genres_not", validator.CodeTooMany, "must not contain more than 20 genres")
+
+	if f.YearGTE != 0 {
+		v.Check(f.YearGTE >= 1888, "year_gte", validator.CodeTooSmall, "must be greater than 1888")
+	}
+	if f.YearLTE != 0 {
+		v.Check(f.YearLTE >= 1888, "year_lte", validator.CodeTooSmall, "must be greater than 1888")
+	}
+	if f.YearGTE != 0 && f.YearLTE != 0 {
+		v.Check(f.YearGTE <= f.YearLTE, "year_gte", validator.CodeTooLarge, "must not be greater than year_lte")
+	}
+
+	v.Check(f.RuntimeMin >= 0, "runtime_between", validator.CodeTooSmall, "must not contain negative values")
+	if f.RuntimeMax != 0 {
+		v.Check(f.RuntimeMin <= f.RuntimeMax, "runtime_between", validator.CodeInvalid, "must be a minimum followed by a maximum")
+	}
+
+	if !f.CreatedAfter.IsZero() {
+		v.Check(f.CreatedAfter.Before(time.Now()), "created_after", validator.CodeTooLarge, "must not be in the future")
+	}
+
+	v.Check(len(f.Search) <= 200, "q", validator.CodeInvalidLength, "must not be more than 200 bytes long")
+	v.Check(validator.PermittedValue(f.Language, SearchLanguages...), "language", validator.CodeNotPermitted, "unsupported search language")
+
+	ValidateMovieFields(v, f.Fields)
+}
+
+// ValidateMovieFields checks a fields= list against MovieFields
+func ValidateMovieFields(v *validator.Validator, fields []string) {
+	for _, field := range fields {
+		if !validator.PermittedValue(field, MovieFields...) {
+			v.AddError("fields", validator.CodeNotPermitted, fmt.Sprintf("invalid field %q, must be a comma-separated list of %s", field, strings.Join(MovieFields, ", ")))
+			break
+		}
+	}
+	v.Check(validator.Unique(fields), "fields", validator.CodeDuplicate, "must not contain duplicate values")
+}
+
+// prefixTSQuery turns free text into a to_tsquery expression that matches every word as a prefix,
+// e.g. "star wa" becomes "star:* & wa:*". Anything but letters and digits is dropped, so the
+// result is always valid tsquery syntax.
+func prefixTSQuery(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i := range words {
+		words[i] = words[i] + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// build returns the WHERE conditions for the filter and the expression ranking each row against
+// Search, appending their arguments to args. The relevance is the full-text rank plus the
+// trigram word similarity, so exact word matches come first and close misspellings follow; it is
+// 0 without a search. The language is only inlined after being checked against SearchLanguages,
+// so the expression matches the one in the title indexes.
+func (f MovieFilter) build(args []any) (string, string, []any) {
+	conditions := []string{"TRUE"}
+	relevance := "0"
+
+	if f.Title != "" {
+		args = append(args, f.Title)
+		conditions = append(conditions, fmt.Sprintf("to_tsvector('simple', %s) @@ plainto_tsquery('simple', $%d)", searchDocument, len(args)))
+	}
+
+	if len(f.Genres) > 0 {
+		args = append(args, pq.Array(f.Genres))
+		if f.GenresMatch == GenresMatchAny {
+			conditions = append(conditions, fmt.Sprintf("genres && $%d", len(args)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("genres @> $%d", len(args)))
+		}
+	}
+
+	if len(f.GenresNot) > 0 {
+		args = append(args, pq.Array(f.GenresNot))
+		conditions = append(conditions, fmt.Sprintf("NOT genres && $%d", len(args)))
+	}
+
+	if f.YearGTE != 0 {
+		args = append(args, f.YearGTE)
+		conditions = append(conditions, fmt.Sprintf("year >= $%d", len(args)))
+	}
+	if f.YearLTE != 0 {
+		args = append(args, f.YearLTE)
+		conditions = append(conditions, fmt.Sprintf("year <= $%d", len(args)))
+	}
+
+	if f.RuntimeMin != 0 {
+		args = append(args, f.RuntimeMin)
+		conditions = append(conditions, fmt.Sprintf("runtime >= $%d", len(args)))
+	}
+	if f.RuntimeMax != 0 {
+		args = append(args, f.RuntimeMax)
+		conditions = append(conditions, fmt.Sprintf("runtime <= $%d", len(args)))
+	}
+
+	if !f.CreatedAfter.IsZero() {
+		args = append(args, f.CreatedAfter)
+		conditions = append(conditions, fmt.Sprintf("created_at > $%d", len(args)))
+	}
+
+	if f.Search != "" {
+		args = append(args, f.Search)
+		textParam := len(args)
+
+		// <% compares the query with the most similar run of words of the document rather than with
+		// the whole of it, so a misspelled title is still found among its localized titles. It is
+		// what movies_search_document_trgm_idx answers.
+		match := fmt.Sprintf("$%d <%% (%s)", textParam, searchDocument)
+		relevance = fmt.Sprintf("word_similarity($%d, %s)", textParam, searchDocument)
+
+		if tsquery := prefixTSQuery(f.Search); tsquery != "" {
+			args = append(args, tsquery)
+			match = fmt.Sprintf("(to_tsvector(%[1]s, %[2]s) @@ to_tsquery(%[1]s, $%[3]d) OR %[4]s)", f.language(), searchDocument, len(args), match)
+			relevance = fmt.Sprintf("ts_rank(to_tsvector(%[1]s, %[2]s), to_tsquery(%[1]s, $%[3]d)) + %[4]s", f.language(), searchDocument, len(args), relevance)
+		}
+		conditions = append(conditions, match)
+	}
+
+	return strings.Join(conditions, " AND "), relevance, args
+}
+
+func (f MovieFilter) language() string {
+	if !validator.PermittedValue(f.Language, SearchLanguages...) {
+		return "'simple'"
+	}
+	return "'" + f.Language + "'"
+}
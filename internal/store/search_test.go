@@ -0,0 +1,81 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/AmiyoKm/green_light/internal/validator"
+)
+
+func TestValidateMovieFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter MovieFilter
+		key    string
+		code   string
+	}{
+		{"empty", MovieFilter{}, "", ""},
+		{"year range", MovieFilter{YearGTE: 1990, YearLTE: 2000}, "", ""},
+		{"single year", MovieFilter{YearGTE: 1990, YearLTE: 1990}, "", ""},
+		{"reversed year range", MovieFilter{YearGTE: 2000, YearLTE: 1990}, "year_gte", validator.CodeTooLarge},
+		{"year before cinema", MovieFilter{YearLTE: 1800}, "year_lte", validator.CodeTooSmall},
+		{"runtime range", MovieFilter{RuntimeMin: 90, RuntimeMax: 120}, "", ""},
+		{"open runtime range", MovieFilter{RuntimeMin: 90}, "", ""},
+		{"reversed runtime range", MovieFilter{RuntimeMin: 120, RuntimeMax: 90}, "runtime_between", validator.CodeInvalid},
+		{"negative runtime", MovieFilter{RuntimeMin: -1}, "runtime_between", validator.CodeTooSmall},
+		{"any genre", MovieFilter{Genres: []string{"drama"}, GenresMatch: GenresMatchAny}, "", ""},
+		{"unknown genres match", MovieFilter{GenresMatch: "most"}, "genres_match", validator.CodeNotPermitted},
+		{"too many excluded genres", MovieFilter{GenresNot: make([]string, 21)}, "genres_not", validator.CodeTooMany},
+		{"unknown language", MovieFilter{Language: "klingon"}, "language", validator.CodeNotPermitted},
+	}
+
+	for _, tt := range tests {
+		if tt.filter.Language == "" {
+			tt.filter.Language = "english"
+		}
+		v := validator.New()
+		ValidateMovieFilter(v, tt.filter)
+
+		if tt.key == "" {
+			if !v.Valid() {
+				t.Errorf("%s: got errors %v", tt.name, v.Errors)
+			}
+			continue
+		}
+		if got := v.Codes[tt.key]; got != tt.code {
+			t.Errorf("%s: got %q for %s, want %q in %v", tt.name, got, tt.key, tt.code, v.Errors)
+		}
+	}
+}
+
+func TestMovieFilterBuild(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter MovieFilter
+		where  string
+		nargs  int
+	}{
+		{"empty", MovieFilter{}, "TRUE", 1},
+		{"all genres", MovieFilter{Genres: []string{"drama", "comedy"}}, "TRUE AND genres @> $2", 2},
+		{"any genre", MovieFilter{Genres: []string{"drama"}, GenresMatch: GenresMatchAny}, "TRUE AND genres && $2", 2},
+		{
+			"excluded genres and ranges",
+			MovieFilter{GenresNot: []string{"horror"}, YearGTE: 1990, YearLTE: 2000, RuntimeMax: 120},
+			"TRUE AND NOT genres && $2 AND year >= $3 AND year <= $4 AND runtime <= $5",
+			5,
+		},
+	}
+
+	for _, tt := range tests {
+		// the arguments of the query before the filter keep their placeholders
+		where, relevance, args := tt.filter.build([]any{"before"})
+		if where != tt.where {
+			t.Errorf("%s: got %q, want %q", tt.name, where, tt.where)
+		}
+		if relevance != "0" {
+			t.Errorf("%s: got relevance %q without a search", tt.name, relevance)
+		}
+		if len(args) != tt.nargs || args[0] != "before" {
+			t.Errorf("%s: got %d args, want %d", tt.name, len(args), tt.nargs)
+		}
+	}
+}
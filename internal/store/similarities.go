@@ -0,0 +1,233 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SimilarityRebuildTimeDuration bounds the transaction that swaps in a rebuilt similarity table
+var SimilarityRebuildTimeDuration = 5 * time.Minute
+
+// Similarity is a precomputed link from a movie to a similar one. Reasons explain the score in
+// words, e.g. "shares the genres drama, crime".
+type Similarity struct {
+	MovieID   int64
+	SimilarID int64
+	Score     float64
+	Reasons   []string
+}
+
+// SimilarMovie is a movie recommended from the similarity table along with why it was picked
+type SimilarMovie struct {
+	Movie   *Movie   `json:"movie"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// CollectionMembership is one movie's entry in a collection, as read by the recommendation job
+type CollectionMembership struct {
+	CollectionID int64
+	Kind         string
+	MovieID      int64
+}
+
+type SimilarityStore struct {
+	DB *sql.DB
+}
+
+// GetMemberships returns every movie entry of every collection, public or private. Private lists
+// are only used as anonymous co-occurrence signals and never shown to other users.
+func (s *SimilarityStore) GetMemberships(ctx context.Context) ([]CollectionMembership, error) {
+	query := `
+	SELECT collections.id , collections.kind , collection_movies.movie_id
+	FROM collection_movies
+	INNER JOIN collections ON collections.id = collection_movies.collection_id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, SimilarityRebuildTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memberships []CollectionMembership
+	for rows.Next() {
+		var m CollectionMembership
+		if err := rows.Scan(&m.CollectionID, &m.Kind, &m.MovieID); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+// ReplaceAll swaps the whole similarity table for the given rows in one transaction, so readers
+// see either the old or the new table. Rows of movies deleted in the meantime are dropped.
+func (s *SimilarityStore) ReplaceAll(ctx context.Context, similarities []Similarity) error {
+	ctx, cancel := context.WithTimeout(ctx, SimilarityRebuildTimeDuration)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		CREATE TEMP TABLE new_similarities (
+			movie_id BIGINT NOT NULL,
+			similar_id BIGINT NOT NULL,
+			score DOUBLE PRECISION NOT NULL,
+			reasons TEXT[] NOT NULL
+		) ON COMMIT DROP
+	`)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("new_similarities", "movie_id", "similar_id", "score", "reasons"))
+	if err != nil {
+		return err
+	}
+	for _, sim := range similarities {
+		_, err := stmt.ExecContext(ctx, sim.MovieID, sim.SimilarID, sim.Score, pq.Array(sim.Reasons))
+		if err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	// an Exec without arguments flushes the buffered COPY data
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM movie_similarities`); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO movie_similarities (movie_id , similar_id , score , reasons)
+		SELECT n.movie_id , n.similar_id , n.score , n.reasons
+		FROM new_similarities AS n
+		WHERE EXISTS (SELECT 1 FROM movies WHERE id = n.movie_id)
+		AND EXISTS (SELECT 1 FROM movies WHERE id = n.similar_id)
+	`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func scanSimilarMovies(rows *sql.Rows) ([]*SimilarMovie, int, error) {
+	totalRecords := 0
+	similar := []*SimilarMovie{}
+
+	for rows.Next() {
+		sm := &SimilarMovie{Movie: &Movie{}}
+		err := rows.Scan(
+			&totalRecords,
+			&sm.Score,
+			pq.Array(&sm.Reasons),
+			&sm.Movie.ID,
+			&sm.Movie.CreatedAt,
+			&sm.Movie.Title,
+			&sm.Movie.Year,
+			&sm.Movie.Runtime,
+			pq.Array(&sm.Movie.Genres),
+			&sm.Movie.Version,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		similar = append(similar, sm)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return similar, totalRecords, nil
+}
+
+// GetSimilar lists the movies most similar to the given movie, best first
+func (s *SimilarityStore) GetSimilar(ctx context.Context, movieID int64, filters Filters) ([]*SimilarMovie, Metadata, error) {
+	query := `
+	SELECT count(*) OVER() , movie_similarities.score , movie_similarities.reasons ,
+		movies.id , movies.created_at , movies.title , movies.year , movies.runtime , movies.genres , movies.version
+	FROM movie_similarities
+	INNER JOIN movies ON movies.id = movie_similarities.similar_id
+	WHERE movie_similarities.movie_id = $1
+	ORDER BY movie_similarities.score DESC , movies.id ASC
+	LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	similar, totalRecords, err := scanSimilarMovies(rows)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	return similar, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetRecommendations lists the movies most similar to those the user has put in their own
+// collections, summing the scores over every movie they are similar to. Movies already in one
+// of the user's collections are left out, and each pick names the movies that led to it.
+func (s *SimilarityStore) GetRecommendations(ctx context.Context, userID int64, filters Filters) ([]*SimilarMovie, Metadata, error) {
+	query := `
+	WITH seeds AS (
+		SELECT DISTINCT collection_movies.movie_id
+		FROM collection_movies
+		INNER JOIN collections ON collections.id = collection_movies.collection_id
+		WHERE collections.owner_id = $1
+	), picks AS (
+		SELECT movie_similarities.similar_id AS movie_id ,
+			sum(movie_similarities.score) AS score ,
+			array_agg('similar to ' || seed_movies.title ORDER BY movie_similarities.score DESC) AS reasons
+		FROM movie_similarities
+		INNER JOIN seeds ON seeds.movie_id = movie_similarities.movie_id
+		INNER JOIN movies AS seed_movies ON seed_movies.id = movie_similarities.movie_id
+		WHERE movie_similarities.similar_id NOT IN (SELECT movie_id FROM seeds)
+		GROUP BY movie_similarities.similar_id
+	)
+	SELECT count(*) OVER() , picks.score , picks.reasons[1:3] ,
+		movies.id , movies.created_at , movies.title , movies.year , movies.runtime , movies.genres , movies.version
+	FROM picks
+	INNER JOIN movies ON movies.id = picks.movie_id
+	ORDER BY picks.score DESC , movies.id ASC
+	LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	similar, totalRecords, err := scanSimilarMovies(rows)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	return similar, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// the runtime percentiles reported by MovieStats, in ascending order
+var statsPercentiles = []float64{0.25, 0.5, 0.75, 0.9, 0.99}
+
+type RuntimePercentile struct {
+	Percentile float64 `json:"percentile"`
+	Runtime    float64 `json:"runtime"`
+}
+
+type WeekCount struct {
+	Week  time.Time `json:"week"`
+	Count int       `json:"count"`
+}
+
+// MovieStats summarises the catalogue, or the part of it having every genre in Genres
+type MovieStats struct {
+	Genres             []string            `json:"genres,omitempty"`
+	Total              int                 `json:"total"`
+	ByGenre            []FacetCount        `json:"by_genre"`
+	ByDecade           []FacetCount        `json:"by_decade"`
+	RuntimePercentiles []RuntimePercentile `json:"runtime_percentiles"`
+	AddedPerWeek       []WeekCount         `json:"added_per_week"`
+	GeneratedAt        time.Time           `json:"generated_at"`
+}
+
+type StatsStore struct {
+	DB *sql.DB
+}
+
+// Movies computes the catalogue statistics over the movies having all of the given genres, with
+// the additions counted over the last weeks weeks. The genres condition is answered from the
+// movies_genres_idx GIN index, and every query runs in one REPEATABLE READ transaction so that
+// the numbers agree with each other.
+func (s *StatsStore) Movies(ctx context.Context, genres []string, weeks int) (*MovieStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeDuration)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	where, _, args := MovieFilter{Genres: genres}.build(nil)
+
+	stats := &MovieStats{
+		Genres:       genres,
+		ByGenre:      []FacetCount{},
+		ByDecade:     []FacetCount{},
+		AddedPerWeek: []WeekCount{},
+		GeneratedAt:  time.Now(),
+	}
+
+	query := fmt.Sprintf(`SELECT count(*) FROM movies WHERE %s`, where)
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&stats.Total); err != nil {
+		return nil, err
+	}
+
+	for _, facet := range []struct {
+		query  string
+		counts *[]FacetCount
+	}{
+		{facetQueries[FacetGenres], &stats.ByGenre},
+		{facetQueries[FacetDecade], &stats.ByDecade},
+	} {
+		counts, err := countFacet(ctx, tx, fmt.Sprintf(facet.query, where), args)
+		if err != nil {
+			return nil, err
+		}
+		*facet.counts = counts
+	}
+
+	// percentile_cont returns NULL for every percentile when no movie matches
+	query = fmt.Sprintf(`
+	SELECT percentile_cont($%d::float8[]) WITHIN GROUP (ORDER BY runtime)
+	FROM movies
+	WHERE %s
+	`, len(args)+1, where)
+
+	var runtimes []sql.NullFloat64
+	err = tx.QueryRowContext(ctx, query, append(args, pq.Array(statsPercentiles))...).Scan(pq.Array(&runtimes))
+	if err != nil {
+		return nil, err
+	}
+	stats.RuntimePercentiles = make([]RuntimePercentile, 0, len(statsPercentiles))
+	for i, runtime := range runtimes {
+		if runtime.Valid {
+			stats.RuntimePercentiles = append(stats.RuntimePercentiles, RuntimePercentile{Percentile: statsPercentiles[i], Runtime: runtime.Float64})
+		}
+	}
+
+	// weeks without additions are listed with a zero count
+	query = fmt.Sprintf(`
+	SELECT week , count(movies.id)
+	FROM generate_series(date_trunc('week', now()) - ($%d - 1) * interval '1 week', date_trunc('week', now()), interval '1 week') AS week
+	LEFT JOIN movies ON date_trunc('week', movies.created_at) = week AND %s
+	GROUP BY week
+	ORDER BY week ASC
+	`, len(args)+1, where)
+
+	rows, err := tx.QueryContext(ctx, query, append(args, weeks)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var week WeekCount
+		if err := rows.Scan(&week.Week, &week.Count); err != nil {
+			return nil, err
+		}
+		stats.AddedPerWeek = append(stats.AddedPerWeek, week)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -8,20 +8,98 @@ import (
 )
 
 var (
-	QueryTimeDuration    = time.Second * 5
-	ErrorNotFound        = errors.New("resource not found")
-	ErrDuplicateEmail    = errors.New("duplicate email")
-	ErrDuplicateUsername = errors.New("duplicate username")
-	ErrEditConflict      = errors.New("edit conflict")
+	QueryTimeDuration       = time.Second * 5
+	ErrorNotFound           = errors.New("resource not found")
+	ErrDuplicateEmail       = errors.New("duplicate email")
+	ErrDuplicateUsername    = errors.New("duplicate username")
+	ErrEditConflict         = errors.New("edit conflict")
+	ErrDuplicateGenre       = errors.New("duplicate genre")
+	ErrGenreInUse           = errors.New("genre in use")
+	ErrMovieInCollection    = errors.New("movie already in collection")
+	ErrCollectionFull       = errors.New("collection full")
+	ErrCollectionMovies     = errors.New("movies do not match the collection")
+	ErrIdempotencyKeyInUse  = errors.New("idempotency key in use")
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused")
 )
 
+// querier is satisfied by both *sql.DB and *sql.Tx, so a query can run on its own or as part of a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Storage struct {
 	Movies interface {
-		GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
+		GetAll(ctx context.Context, filter MovieFilter, filters Filters) ([]*Movie, Metadata, error)
+		GetAllWithFacets(ctx context.Context, filter MovieFilter, filters Filters, facets []string) ([]*Movie, Metadata, Facets, error)
 		Create(ctx context.Context, movie *Movie) error
 		Get(ctx context.Context, id int64) (*Movie, error)
 		Update(ctx context.Context, movie *Movie) error
+		Delete(ctx context.Context, movie *Movie) error
+		Export(ctx context.Context, filter MovieFilter, fn func(*Movie) error) error
+		SuggestTitles(ctx context.Context, q string, limit int) ([]*Movie, error)
+		GetByExternalID(ctx context.Context, source, externalID string) (*Movie, error)
+		CreateWithExternalID(ctx context.Context, movie *Movie, source, externalID string) error
+		GetExternalIDs(ctx context.Context, movieID int64) ([]ExternalID, error)
+		GetExternalIDsForMovies(ctx context.Context, movieIDs []int64) (map[int64][]ExternalID, error)
+		GetLocalizations(ctx context.Context, movieID int64) (*Localizations, error)
+		GetLocalizationsForMovies(ctx context.Context, movieIDs []int64) (map[int64]*Localizations, error)
+		ReplaceLocalizations(ctx context.Context, movie *Movie, l *Localizations) error
+		GetTitles(ctx context.Context, movieIDs []int64, locales []string) (map[int64]map[string]string, error)
+		GetDuplicateCandidates(ctx context.Context, threshold float64, filters Filters) ([]DuplicateCandidate, Metadata, error)
+		Merge(ctx context.Context, duplicateID int64, survivor *Movie) error
+		GetRedirect(ctx context.Context, id int64) (int64, error)
+	}
+	Revisions interface {
+		Create(ctx context.Context, rev *MovieRevision) error
+		Get(ctx context.Context, movieID int64, version int32) (*MovieRevision, error)
+		GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error)
+	}
+	Tx interface {
+		InTx(ctx context.Context, fn func(tx TxStorage) error) error
+	}
+	Imports interface {
+		CreateJob(ctx context.Context, job *ImportJob) error
+		GetJob(ctx context.Context, id int64) (*ImportJob, error)
+		UpdateJob(ctx context.Context, job *ImportJob) error
+		InsertBatch(ctx context.Context, rows []ImportRow, mode string, authorID *int64) (ImportResult, error)
+	}
+	Collections interface {
+		GetAll(ctx context.Context, viewerID int64, kind string, filters Filters) ([]*Collection, Metadata, error)
+		Get(ctx context.Context, id int64, viewerID int64) (*Collection, error)
+		GetForMovie(ctx context.Context, movieID int64, viewerID int64) ([]MovieCollection, error)
+		GetForMovies(ctx context.Context, movieIDs []int64, viewerID int64) (map[int64][]MovieCollection, error)
+		Create(ctx context.Context, c *Collection) error
+		Update(ctx context.Context, c *Collection) error
 		Delete(ctx context.Context, id int64) error
+		AddMovie(ctx context.Context, c *Collection, movieID int64, position int) error
+		RemoveMovie(ctx context.Context, c *Collection, movieID int64) error
+		ReorderMovies(ctx context.Context, c *Collection, movieIDs []int64) error
+	}
+	Genres interface {
+		GetAll(ctx context.Context) ([]*Genre, error)
+		Get(ctx context.Context, slug string) (*Genre, error)
+		Create(ctx context.Context, genre *Genre) error
+		Update(ctx context.Context, genre *Genre) error
+		Delete(ctx context.Context, slug string) error
+		Resolve(ctx context.Context, names []string) (map[string]string, error)
+		Suggest(ctx context.Context, name string, limit int) ([]string, error)
+	}
+	Similarities interface {
+		GetMemberships(ctx context.Context) ([]CollectionMembership, error)
+		ReplaceAll(ctx context.Context, similarities []Similarity) error
+		GetSimilar(ctx context.Context, movieID int64, filters Filters) ([]*SimilarMovie, Metadata, error)
+		GetRecommendations(ctx context.Context, userID int64, filters Filters) ([]*SimilarMovie, Metadata, error)
+	}
+	Stats interface {
+		Movies(ctx context.Context, genres []string, weeks int) (*MovieStats, error)
+	}
+	Idempotency interface {
+		Begin(ctx context.Context, userID int64, key string, fingerprint []byte, retention time.Duration) (*IdempotentResponse, error)
+		Complete(ctx context.Context, userID int64, key string, resp *IdempotentResponse) error
+		Release(ctx context.Context, userID int64, key string) error
+		DeleteExpired(ctx context.Context, retention time.Duration) (int64, error)
 	}
 	Users interface {
 		Get(ctx context.Context, userID int64) (*User, error)
@@ -40,12 +118,20 @@ type Storage struct {
 	}
 }
 
-func NewStorage(db *sql.DB) Storage {
+func NewStorage(db *sql.DB, observers ...MovieObserver) Storage {
 
 	return Storage{
-		Movies:      &MovieStore{DB: db},
-		Users:       &UserStore{DB: db},
-		Tokens:      &TokenStore{DB: db},
-		Permissions: &PermissionStore{DB: db},
+		Movies:       &MovieStore{DB: db, Observers: observers},
+		Revisions:    &RevisionStore{DB: db},
+		Tx:           &TxStore{DB: db, Observers: observers},
+		Imports:      &ImportStore{DB: db, Observers: observers},
+		Collections:  &CollectionStore{DB: db},
+		Genres:       &GenreStore{DB: db},
+		Similarities: &SimilarityStore{DB: db},
+		Stats:        &StatsStore{DB: db},
+		Idempotency:  &IdempotencyStore{DB: db},
+		Users:        &UserStore{DB: db},
+		Tokens:       &TokenStore{DB: db},
+		Permissions:  &PermissionStore{DB: db},
 	}
 }
@@ -50,8 +50,8 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 }
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+	v.Check(tokenPlaintext != "", "token", validator.CodeRequired, "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", validator.CodeInvalidLength, "must be 26 bytes long")
 }
 
 type TokenStore struct {
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// TxStorage holds the stores that can take part in a transaction opened by TxStore.InTx.
+// Everything written through them commits or rolls back together.
+type TxStorage struct {
+	Movies interface {
+		Create(ctx context.Context, movie *Movie) error
+		Get(ctx context.Context, id int64) (*Movie, error)
+		Update(ctx context.Context, movie *Movie) error
+		Delete(ctx context.Context, movie *Movie) error
+		CreateWithExternalID(ctx context.Context, movie *Movie, source, externalID string) error
+		ReplaceLocalizations(ctx context.Context, movie *Movie, l *Localizations) error
+	}
+	Revisions interface {
+		Create(ctx context.Context, rev *MovieRevision) error
+	}
+}
+
+type TxStore struct {
+	DB        *sql.DB
+	Observers []MovieObserver
+}
+
+// InTx runs fn with stores bound to a single transaction, which is committed when fn returns nil
+// and rolled back otherwise. Observers only hear about the movies written once it has committed.
+func (s *TxStore) InTx(ctx context.Context, fn func(tx TxStorage) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	pending := &pendingObserver{}
+
+	err = fn(TxStorage{
+		Movies:    &MovieStore{DB: s.DB, Tx: tx, Observers: []MovieObserver{pending}},
+		Revisions: &RevisionStore{DB: s.DB, Tx: tx},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	pending.flush(s.Observers)
+	return nil
+}
+
+// pendingObserver holds back the notifications of a transaction until it commits
+type pendingObserver struct {
+	events []func(o MovieObserver)
+}
+
+func (p *pendingObserver) MovieSaved(movie *Movie) {
+	saved := *movie
+	p.events = append(p.events, func(o MovieObserver) { o.MovieSaved(&saved) })
+}
+
+func (p *pendingObserver) MovieDeleted(id int64) {
+	p.events = append(p.events, func(o MovieObserver) { o.MovieDeleted(id) })
+}
+
+func (p *pendingObserver) flush(observers []MovieObserver) {
+	for _, event := range p.events {
+		for _, o := range observers {
+			event(o)
+		}
+	}
+}
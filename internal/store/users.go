@@ -218,20 +218,20 @@ func (s *UserStore) GetForToken(ctx context.Context, tokenScope, tokenPlainText
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be valid email address")
+	v.Check(email != "", "email", validator.CodeRequired, "must be provided")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", validator.CodeInvalidFormat, "must be valid email address")
 }
 
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(password != "", "password", validator.CodeRequired, "must be provided")
+	v.Check(len(password) >= 8, "password", validator.CodeInvalidLength, "must be at least 8 bytes long")
+	v.Check(len(password) <= 72, "password", validator.CodeInvalidLength, "must not be more than 72 bytes long")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
 
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(user.Name != "", "name", validator.CodeRequired, "must be provided")
+	v.Check(len(user.Name) <= 500, "name", validator.CodeInvalidLength, "must not be more than 500 bytes long")
 
 	ValidateEmail(v, user.Email)
 
@@ -0,0 +1,420 @@
+package suggest
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+// the number of word matches looked at for a single query, which keeps one-letter queries cheap
+const maxCandidates = 2000
+
+type Title struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year,omitempty"`
+}
+
+type Genre struct {
+	Name   string `json:"name"`
+	Movies int    `json:"movies"`
+}
+
+type entry struct {
+	title  Title
+	words  []string
+	genres []string
+}
+
+// term is one word of a title, kept sorted so that every word starting with a prefix
+// sits in a single contiguous range
+type term struct {
+	word string
+	id   int64
+}
+
+// Index is an in-memory prefix index over movie titles and genres. It implements
+// store.MovieBatchObserver so that it follows writes made through the MovieStore and imports.
+type Index struct {
+	mu      sync.RWMutex
+	entries map[int64]*entry
+	terms   []term
+	genres  map[string]int
+	ready   bool
+
+	// loads counts the Loads reading the store, which keep the writes heard meanwhile in missed
+	// since their snapshot may not have them
+	loads  int
+	missed []write
+}
+
+// write is a movie saved, or the id of a movie deleted when movie is nil
+type write struct {
+	movie *store.Movie
+	id    int64
+}
+
+func New() *Index {
+	return &Index{
+		entries: make(map[int64]*entry),
+		genres:  make(map[string]int),
+	}
+}
+
+// normalize lower-cases s and splits it into words of letters and digits
+func normalize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func newEntry(movie *store.Movie) *entry {
+	return &entry{
+		title:  Title{ID: movie.ID, Title: movie.Title, Year: movie.Year},
+		words:  normalize(movie.Title),
+		genres: append([]string(nil), movie.Genres...),
+	}
+}
+
+// Ready reports whether the index has been loaded at least once
+func (ix *Index) Ready() bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.ready
+}
+
+// Replace swaps the whole content of the index for the given movies
+func (ix *Index) Replace(movies []*store.Movie) {
+	ix.replace(movies, false)
+}
+
+// replace swaps the content of the index for the given movies and, with replay, applies the
+// missed writes on top under the same lock, so that no search sees the content without them
+func (ix *Index) replace(movies []*store.Movie, replay bool) {
+	entries := make(map[int64]*entry, len(movies))
+	genres := make(map[string]int)
+	terms := make([]term, 0, len(movies)*3)
+
+	for _, movie := range movies {
+		e := newEntry(movie)
+		entries[movie.ID] = e
+		for _, word := range e.words {
+			terms = append(terms, term{word: word, id: movie.ID})
+		}
+		for _, genre := range e.genres {
+			genres[genre]++
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		return lessTerm(terms[i], terms[j])
+	})
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.entries = entries
+	ix.terms = terms
+	ix.genres = genres
+	ix.ready = true
+
+	if !replay || len(ix.missed) == 0 {
+		return
+	}
+	// only the last write of each movie matters
+	latest := make(map[int64]*entry, len(ix.missed))
+	for _, w := range ix.missed {
+		if w.movie != nil {
+			latest[w.movie.ID] = newEntry(w.movie)
+		} else {
+			latest[w.id] = nil
+		}
+	}
+	ix.apply(latest)
+}
+
+func lessTerm(a, b term) bool {
+	if a.word != b.word {
+		return a.word < b.word
+	}
+	return a.id < b.id
+}
+
+func (ix *Index) MovieSaved(movie *store.Movie) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if ix.loads > 0 {
+		saved := *movie
+		ix.missed = append(ix.missed, write{movie: &saved})
+	}
+	ix.save(movie)
+}
+
+// MoviesSaved adds or updates the movies of a bulk write at once, in a single pass over the
+// terms where saving them one by one would shift the terms once per word of every movie
+func (ix *Index) MoviesSaved(movies []*store.Movie) {
+	// a movie written twice keeps its last state
+	entries := make(map[int64]*entry, len(movies))
+	for _, movie := range movies {
+		entries[movie.ID] = newEntry(movie)
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if ix.loads > 0 {
+		for _, movie := range movies {
+			saved := *movie
+			ix.missed = append(ix.missed, write{movie: &saved})
+		}
+	}
+	ix.apply(entries)
+}
+
+// apply puts the entries in place of the movies with the same ids, a nil entry removing its
+// movie, merging their terms into the sorted ones at once. The caller must hold the write lock.
+func (ix *Index) apply(entries map[int64]*entry) {
+	var added []term
+	for id, e := range entries {
+		if e == nil {
+			continue
+		}
+		for _, word := range e.words {
+			added = append(added, term{word: word, id: id})
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return lessTerm(added[i], added[j])
+	})
+
+	for id := range entries {
+		if old, ok := ix.entries[id]; ok {
+			for _, genre := range old.genres {
+				if ix.genres[genre]--; ix.genres[genre] <= 0 {
+					delete(ix.genres, genre)
+				}
+			}
+			delete(ix.entries, id)
+		}
+	}
+
+	terms := make([]term, 0, len(ix.terms)+len(added))
+	i := 0
+	for _, t := range ix.terms {
+		if _, ok := entries[t.id]; ok {
+			continue
+		}
+		for ; i < len(added) && lessTerm(added[i], t); i++ {
+			terms = append(terms, added[i])
+		}
+		terms = append(terms, t)
+	}
+	ix.terms = append(terms, added[i:]...)
+
+	for id, e := range entries {
+		if e == nil {
+			continue
+		}
+		ix.entries[id] = e
+		for _, genre := range e.genres {
+			ix.genres[genre]++
+		}
+	}
+}
+
+// save adds a movie to the index or updates it. The caller must hold the write lock.
+func (ix *Index) save(movie *store.Movie) {
+	ix.remove(movie.ID)
+
+	e := newEntry(movie)
+	ix.entries[movie.ID] = e
+	for _, word := range e.words {
+		t := term{word: word, id: movie.ID}
+		i := sort.Search(len(ix.terms), func(i int) bool { return !lessTerm(ix.terms[i], t) })
+		ix.terms = append(ix.terms, term{})
+		copy(ix.terms[i+1:], ix.terms[i:])
+		ix.terms[i] = t
+	}
+	for _, genre := range e.genres {
+		ix.genres[genre]++
+	}
+}
+
+func (ix *Index) MovieDeleted(id int64) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if ix.loads > 0 {
+		ix.missed = append(ix.missed, write{id: id})
+	}
+	ix.remove(id)
+}
+
+// remove drops a movie from the index. The caller must hold the write lock.
+func (ix *Index) remove(id int64) {
+	e, ok := ix.entries[id]
+	if !ok {
+		return
+	}
+	delete(ix.entries, id)
+
+	for _, word := range e.words {
+		t := term{word: word, id: id}
+		i := sort.Search(len(ix.terms), func(i int) bool { return !lessTerm(ix.terms[i], t) })
+		if i < len(ix.terms) && ix.terms[i] == t {
+			ix.terms = append(ix.terms[:i], ix.terms[i+1:]...)
+		}
+	}
+	for _, genre := range e.genres {
+		ix.genres[genre]--
+		if ix.genres[genre] <= 0 {
+			delete(ix.genres, genre)
+		}
+	}
+}
+
+// Titles returns up to limit titles where every word of q starts a word of the title.
+// Titles that begin with q come first, then shorter titles.
+func (ix *Index) Titles(q string, limit int) []Title {
+	words := normalize(q)
+	if len(words) == 0 || limit <= 0 {
+		return []Title{}
+	}
+	phrase := strings.Join(words, " ")
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	// the longest word narrows the range of terms the most
+	longest := words[0]
+	for _, word := range words[1:] {
+		if len(word) > len(longest) {
+			longest = word
+		}
+	}
+
+	type candidate struct {
+		e      *entry
+		starts bool
+	}
+	var candidates []candidate
+	seen := make(map[int64]bool)
+
+	i := sort.Search(len(ix.terms), func(i int) bool { return ix.terms[i].word >= longest })
+	for ; i < len(ix.terms) && strings.HasPrefix(ix.terms[i].word, longest) && len(candidates) < maxCandidates; i++ {
+		id := ix.terms[i].id
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		e := ix.entries[id]
+		if !matchesAll(e.words, words) {
+			continue
+		}
+		candidates = append(candidates, candidate{
+			e:      e,
+			starts: strings.HasPrefix(strings.Join(e.words, " "), phrase),
+		})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.starts != b.starts {
+			return a.starts
+		}
+		if len(a.e.title.Title) != len(b.e.title.Title) {
+			return len(a.e.title.Title) < len(b.e.title.Title)
+		}
+		return a.e.title.ID < b.e.title.ID
+	})
+
+	titles := make([]Title, 0, min(limit, len(candidates)))
+	for _, c := range candidates {
+		if len(titles) == limit {
+			break
+		}
+		titles = append(titles, c.e.title)
+	}
+	return titles
+}
+
+// matchesAll reports whether every query word is the prefix of a word of the title
+func matchesAll(titleWords, queryWords []string) bool {
+	for _, q := range queryWords {
+		found := false
+		for _, w := range titleWords {
+			if strings.HasPrefix(w, q) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Genres returns up to limit genres starting with q, the most used first
+func (ix *Index) Genres(q string, limit int) []Genre {
+	q = strings.ToLower(strings.TrimSpace(q))
+	if q == "" || limit <= 0 {
+		return []Genre{}
+	}
+
+	ix.mu.RLock()
+	genres := []Genre{}
+	for name, count := range ix.genres {
+		if strings.HasPrefix(strings.ToLower(name), q) {
+			genres = append(genres, Genre{Name: name, Movies: count})
+		}
+	}
+	ix.mu.RUnlock()
+
+	sort.Slice(genres, func(i, j int) bool {
+		if genres[i].Movies != genres[j].Movies {
+			return genres[i].Movies > genres[j].Movies
+		}
+		return genres[i].Name < genres[j].Name
+	})
+	if len(genres) > limit {
+		genres = genres[:limit]
+	}
+	return genres
+}
+
+// Load replaces the content of the index with every movie in the store. The writes heard while
+// the store is read are applied again on top, as the snapshot read may predate them. Writes made
+// on other replicas only reach the index through a reload.
+func (ix *Index) Load(ctx context.Context, s store.Storage) error {
+	ix.mu.Lock()
+	ix.loads++
+	ix.mu.Unlock()
+
+	defer func() {
+		ix.mu.Lock()
+		defer ix.mu.Unlock()
+
+		if ix.loads--; ix.loads == 0 {
+			ix.missed = nil
+		}
+	}()
+
+	var movies []*store.Movie
+
+	err := s.Movies.Export(ctx, store.MovieFilter{}, func(movie *store.Movie) error {
+		movies = append(movies, movie)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ix.replace(movies, true)
+	return nil
+}
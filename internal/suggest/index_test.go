@@ -0,0 +1,90 @@
+package suggest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AmiyoKm/green_light/internal/store"
+)
+
+func TestMoviesSavedMatchesSavingOneByOne(t *testing.T) {
+	existing := []*store.Movie{
+		{ID: 1, Title: "Moana", Year: 2016, Genres: []string{"animation", "adventure"}},
+		{ID: 2, Title: "The Breakfast Club", Year: 1985, Genres: []string{"comedy", "drama"}},
+		{ID: 3, Title: "Black Panther", Year: 2018, Genres: []string{"action"}},
+	}
+	batch := []*store.Movie{
+		// a new movie, an update of an existing one and a movie written twice
+		{ID: 4, Title: "Back to the Future", Year: 1985, Genres: []string{"adventure", "comedy"}},
+		{ID: 2, Title: "Breakfast at Tiffany's", Year: 1961, Genres: []string{"romance"}},
+		{ID: 5, Title: "Zootopia", Year: 2016, Genres: []string{"animation"}},
+		{ID: 5, Title: "Zootropolis", Year: 2016, Genres: []string{"animation", "comedy"}},
+	}
+
+	batched := New()
+	batched.Replace(existing)
+	batched.MoviesSaved(batch)
+
+	single := New()
+	single.Replace(existing)
+	for _, movie := range batch {
+		single.MovieSaved(movie)
+	}
+
+	if !reflect.DeepEqual(batched.terms, single.terms) {
+		t.Errorf("got terms %v, want %v", batched.terms, single.terms)
+	}
+	if !reflect.DeepEqual(batched.genres, single.genres) {
+		t.Errorf("got genres %v, want %v", batched.genres, single.genres)
+	}
+
+	tests := []struct {
+		q    string
+		want []Title
+	}{
+		{"break", []Title{{ID: 2, Title: "Breakfast at Tiffany's", Year: 1961}}},
+		{"b", []Title{
+			{ID: 3, Title: "Black Panther", Year: 2018},
+			{ID: 4, Title: "Back to the Future", Year: 1985},
+			{ID: 2, Title: "Breakfast at Tiffany's", Year: 1961},
+		}},
+		{"zoot", []Title{{ID: 5, Title: "Zootropolis", Year: 2016}}},
+		{"club", []Title{}},
+	}
+	for _, tt := range tests {
+		if got := batched.Titles(tt.q, 10); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.q, got, tt.want)
+		}
+	}
+
+	if got, want := batched.Genres("comedy", 1), []Genre{{Name: "comedy", Movies: 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := batched.Genres("drama", 1); len(got) != 0 {
+		t.Errorf("got %v, want no genre left without movies", got)
+	}
+}
+
+func TestReplaceReplaysTheLastMissedWrite(t *testing.T) {
+	ix := New()
+	ix.loads = 1
+	ix.MoviesSaved([]*store.Movie{
+		{ID: 1, Title: "Moana", Year: 2016, Genres: []string{"animation"}},
+		{ID: 2, Title: "Coco", Year: 2017, Genres: []string{"animation"}},
+	})
+	ix.MovieDeleted(2)
+	ix.loads = 0
+
+	// the snapshot predates every write
+	ix.replace([]*store.Movie{{ID: 2, Title: "Coco", Year: 2017, Genres: []string{"animation"}}}, true)
+
+	if got, want := ix.Titles("mo", 10), []Title{{ID: 1, Title: "Moana", Year: 2016}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := ix.Titles("coco", 10); len(got) != 0 {
+		t.Errorf("got %v, want the deleted movie gone", got)
+	}
+	if got, want := ix.Genres("anim", 1), []Genre{{Name: "animation", Movies: 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -8,12 +8,34 @@ var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
+// Codes name the kind of each error, so that clients can branch on it without parsing the message
+const (
+	CodeRequired      = "required"
+	CodeNotAllowed    = "not_allowed"
+	CodeImmutable     = "immutable"
+	CodeInvalid       = "invalid"
+	CodeInvalidType   = "invalid_type"
+	CodeInvalidFormat = "invalid_format"
+	CodeInvalidLength = "invalid_length"
+	CodeInvalidToken  = "invalid_token"
+	CodeNotPermitted  = "not_permitted"
+	CodeTooSmall      = "too_small"
+	CodeTooLarge      = "too_large"
+	CodeTooFew        = "too_few"
+	CodeTooMany       = "too_many"
+	CodeDuplicate     = "duplicate"
+	CodeConflict      = "conflict"
+	CodeNotFound      = "not_found"
+)
+
 type Validator struct {
 	Errors map[string]string
+	// Codes holds the code of each error in Errors, under the same key
+	Codes map[string]string
 }
 
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{Errors: make(map[string]string), Codes: make(map[string]string)}
 }
 
 // Checks if there is an error.
@@ -23,16 +45,17 @@ func (v *Validator) Valid() bool {
 }
 
 // Add an Error Manually
-func (v *Validator) AddError(key, message string) {
+func (v *Validator) AddError(key, code, message string) {
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
+		v.Codes[key] = code
 	}
 }
 
 // Adds an Error if the given condition is not true
-func (v *Validator) Check(ok bool, key, message string) {
+func (v *Validator) Check(ok bool, key, code, message string) {
 	if !ok {
-		v.AddError(key, message)
+		v.AddError(key, code, message)
 	}
 }
 
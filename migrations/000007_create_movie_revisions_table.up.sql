@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    movie_id BIGINT NOT NULL,
+    version INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    author_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    title TEXT NOT NULL,
+    year INTEGER NOT NULL,
+    runtime INTEGER NOT NULL,
+    genres TEXT[] NOT NULL,
+    changes JSONB NOT NULL DEFAULT '{}',
+    UNIQUE (movie_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS movie_revisions_movie_id_idx ON movie_revisions (movie_id, version DESC);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS movie_external_ids (
+    movie_id BIGINT NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+    source TEXT NOT NULL,
+    external_id TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (source, external_id),
+    UNIQUE (movie_id, source)
+);
@@ -0,0 +1,3 @@
+-- the genre arrays of the movies keep their slugs, the original spellings are not restored
+DROP TABLE IF EXISTS genre_aliases;
+DROP TABLE IF EXISTS genres;
@@ -0,0 +1,88 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    slug TEXT UNIQUE NOT NULL,
+    name TEXT NOT NULL,
+    parent_id BIGINT REFERENCES genres(id) ON DELETE SET NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT genres_parent_check CHECK (parent_id <> id)
+);
+
+CREATE TABLE IF NOT EXISTS genre_aliases (
+    alias TEXT PRIMARY KEY,
+    genre_id BIGINT NOT NULL REFERENCES genres(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS genre_aliases_genre_id_idx ON genre_aliases (genre_id);
+
+INSERT INTO genres (slug , name)
+VALUES
+    ('action' , 'Action'),
+    ('adventure' , 'Adventure'),
+    ('animation' , 'Animation'),
+    ('comedy' , 'Comedy'),
+    ('crime' , 'Crime'),
+    ('documentary' , 'Documentary'),
+    ('drama' , 'Drama'),
+    ('family' , 'Family'),
+    ('fantasy' , 'Fantasy'),
+    ('history' , 'History'),
+    ('horror' , 'Horror'),
+    ('music' , 'Music'),
+    ('mystery' , 'Mystery'),
+    ('romance' , 'Romance'),
+    ('science-fiction' , 'Science Fiction'),
+    ('thriller' , 'Thriller'),
+    ('war' , 'War'),
+    ('western' , 'Western')
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO genre_aliases (alias , genre_id)
+SELECT aliases.alias , genres.id
+FROM (
+    VALUES
+        ('sci-fi' , 'science-fiction'),
+        ('scifi' , 'science-fiction'),
+        ('sf' , 'science-fiction'),
+        ('animated' , 'animation'),
+        ('cartoon' , 'animation'),
+        ('docu' , 'documentary'),
+        ('historical' , 'history'),
+        ('musical' , 'music'),
+        ('romantic' , 'romance')
+) AS aliases (alias , slug)
+INNER JOIN genres ON genres.slug = aliases.slug
+ON CONFLICT (alias) DO NOTHING;
+
+-- every other genre already used by a movie becomes a genre of its own. The key is the one
+-- computed by store.GenreSlug: lower case words of letters and digits joined by hyphens.
+INSERT INTO genres (slug , name)
+SELECT DISTINCT ON (used.slug) used.slug , used.genre
+FROM (
+    SELECT trim(BOTH '-' FROM regexp_replace(lower(genre), '[^[:alnum:]]+', '-', 'g')) AS slug , genre
+    FROM movies , unnest(genres) AS genre
+) AS used
+WHERE used.slug <> ''
+AND NOT EXISTS (SELECT 1 FROM genre_aliases WHERE genre_aliases.alias = used.slug)
+ORDER BY used.slug , used.genre
+ON CONFLICT (slug) DO NOTHING;
+
+-- the genre arrays are rewritten to slugs, keeping their order and dropping the duplicates
+-- created when two spellings of a genre were both used
+UPDATE movies
+SET genres = normalised.genres , version = movies.version + 1
+FROM (
+    SELECT id , array_agg(slug ORDER BY position) AS genres
+    FROM (
+        SELECT movies.id , coalesce(genres.slug , aliased.slug) AS slug , min(used.position) AS position
+        FROM movies
+        CROSS JOIN unnest(movies.genres) WITH ORDINALITY AS used (genre , position)
+        LEFT JOIN genres ON genres.slug = trim(BOTH '-' FROM regexp_replace(lower(used.genre), '[^[:alnum:]]+', '-', 'g'))
+        LEFT JOIN genre_aliases ON genre_aliases.alias = trim(BOTH '-' FROM regexp_replace(lower(used.genre), '[^[:alnum:]]+', '-', 'g'))
+        LEFT JOIN genres AS aliased ON aliased.id = genre_aliases.genre_id
+        WHERE coalesce(genres.slug , aliased.slug) IS NOT NULL
+        GROUP BY movies.id , coalesce(genres.slug , aliased.slug)
+    ) AS slugs
+    GROUP BY id
+) AS normalised
+WHERE movies.id = normalised.id AND movies.genres <> normalised.genres;
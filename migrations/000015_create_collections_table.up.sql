@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS collections (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    kind TEXT NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    owner_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    visibility TEXT NOT NULL DEFAULT 'private',
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT collections_kind_check CHECK (kind IN ('franchise' , 'list')),
+    CONSTRAINT collections_visibility_check CHECK (visibility IN ('public' , 'private'))
+);
+
+CREATE INDEX IF NOT EXISTS collections_owner_id_idx ON collections (owner_id);
+
+-- positions are renumbered by single UPDATE statements, so their uniqueness is only checked at commit
+CREATE TABLE IF NOT EXISTS collection_movies (
+    collection_id BIGINT NOT NULL REFERENCES collections(id) ON DELETE CASCADE,
+    movie_id BIGINT NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    added_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (collection_id , movie_id),
+    CONSTRAINT collection_movies_position_key UNIQUE (collection_id , position) DEFERRABLE INITIALLY DEFERRED,
+    CONSTRAINT collection_movies_position_check CHECK (position > 0)
+);
+
+CREATE INDEX IF NOT EXISTS collection_movies_movie_id_idx ON collection_movies (movie_id);
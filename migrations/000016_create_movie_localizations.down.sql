@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS movies_title_idx;
+DROP INDEX IF EXISTS movies_title_english_idx;
+
+CREATE INDEX IF NOT EXISTS movies_title_idx ON movies USING GIN (to_tsvector('simple' , title));
+CREATE INDEX IF NOT EXISTS movies_title_english_idx ON movies USING GIN (to_tsvector('english' , title));
+
+DROP TABLE IF EXISTS movie_releases;
+DROP TABLE IF EXISTS movie_titles;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS alternate_titles;
@@ -0,0 +1,24 @@
+-- alternate_titles holds every localized title of the movie, kept in sync by the store, so that
+-- a single full-text index covers the original and the alternate titles
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS alternate_titles TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS movie_titles (
+    movie_id BIGINT NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+    locale TEXT NOT NULL,
+    title TEXT NOT NULL,
+    PRIMARY KEY (movie_id , locale)
+);
+
+CREATE TABLE IF NOT EXISTS movie_releases (
+    movie_id BIGINT NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+    country TEXT NOT NULL,
+    release_date DATE NOT NULL,
+    certification TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (movie_id , country)
+);
+
+DROP INDEX IF EXISTS movies_title_idx;
+DROP INDEX IF EXISTS movies_title_english_idx;
+
+CREATE INDEX IF NOT EXISTS movies_title_idx ON movies USING GIN (to_tsvector('simple' , title || ' ' || alternate_titles));
+CREATE INDEX IF NOT EXISTS movies_title_english_idx ON movies USING GIN (to_tsvector('english' , title || ' ' || alternate_titles));
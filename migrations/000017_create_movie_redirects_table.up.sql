@@ -0,0 +1,8 @@
+-- old_id is the id of a movie merged into movie_id. It has no foreign key since that movie is gone.
+CREATE TABLE IF NOT EXISTS movie_redirects (
+    old_id BIGINT PRIMARY KEY,
+    movie_id BIGINT NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS movie_redirects_movie_id_idx ON movie_redirects (movie_id);
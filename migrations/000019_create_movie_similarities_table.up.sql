@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS movie_similarities (
+    movie_id BIGINT NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+    similar_id BIGINT NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+    score DOUBLE PRECISION NOT NULL,
+    reasons TEXT[] NOT NULL DEFAULT '{}',
+    computed_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id , similar_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_similarities_score_idx ON movie_similarities (movie_id , score DESC);
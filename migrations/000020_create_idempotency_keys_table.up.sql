@@ -0,0 +1,14 @@
+-- each user has a namespace of their own, and anonymous requests share user_id 0, which is why
+-- it is no foreign key. The keys of a deleted user expire with the others.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id BIGINT NOT NULL,
+    key TEXT NOT NULL,
+    fingerprint BYTEA NOT NULL,
+    status INTEGER,
+    header JSONB,
+    body BYTEA,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id , key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS movies_search_document_trgm_idx;
+DROP INDEX IF EXISTS movies_title_spanish_idx;
+DROP INDEX IF EXISTS movies_title_portuguese_idx;
+DROP INDEX IF EXISTS movies_title_italian_idx;
+DROP INDEX IF EXISTS movies_title_german_idx;
+DROP INDEX IF EXISTS movies_title_french_idx;
@@ -0,0 +1,12 @@
+-- every configuration in store.SearchLanguages gets a full-text index on the search document,
+-- 'simple' and 'english' already have theirs in movies_title_idx and movies_title_english_idx
+CREATE INDEX IF NOT EXISTS movies_title_french_idx ON movies USING GIN (to_tsvector('french' , title || ' ' || alternate_titles));
+CREATE INDEX IF NOT EXISTS movies_title_german_idx ON movies USING GIN (to_tsvector('german' , title || ' ' || alternate_titles));
+CREATE INDEX IF NOT EXISTS movies_title_italian_idx ON movies USING GIN (to_tsvector('italian' , title || ' ' || alternate_titles));
+CREATE INDEX IF NOT EXISTS movies_title_portuguese_idx ON movies USING GIN (to_tsvector('portuguese' , title || ' ' || alternate_titles));
+CREATE INDEX IF NOT EXISTS movies_title_spanish_idx ON movies USING GIN (to_tsvector('spanish' , title || ' ' || alternate_titles));
+
+-- the trigram fallback of q= searches matches the same document with the word similarity operator
+-- <%, which gin_trgm_ops answers as well. movies_title_trgm_idx is kept
+-- for the title alone that suggestions and duplicate detection compare
+CREATE INDEX IF NOT EXISTS movies_search_document_trgm_idx ON movies USING GIN ((title || ' ' || alternate_titles) gin_trgm_ops);